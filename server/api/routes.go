@@ -3,24 +3,58 @@ package api
 import (
 	"shiplabs/schat/internal/base"
 	"shiplabs/schat/internal/middlewares"
+	"shiplabs/schat/internal/pkg/blob"
+	"shiplabs/schat/internal/pkg/broker"
 	"shiplabs/schat/internal/pkg/db"
+	"shiplabs/schat/internal/pkg/keyring"
+	"shiplabs/schat/internal/pkg/mailer"
 	"shiplabs/schat/internal/pkg/store"
 
 	"github.com/gin-gonic/gin"
 )
 
 func RoutesHandler(e *gin.Engine) {
-	app := base.New(db.DB, store.WebsocketStore).MountHandlers()
+	app := base.New(db.DB, store.WebsocketStore, blob.Store, broker.PubSub, broker.Presence, keyring.Active, mailer.Mail).MountHandlers()
+
+	e.GET("/.well-known/jwks.json", app.AuthH.JWKS)
 
 	v1 := e.Group("api/v1")
 	authRequired := v1.Group("").Use(middlewares.Auth)
 
 	v1.POST("/register", app.AuthH.SignUp)
 	v1.POST("/login", app.AuthH.Login)
+	v1.POST("/login/2fa", app.AuthH.CompleteLogin)
+	v1.POST("/refresh", app.AuthH.Refresh)
+	v1.POST("/verify-email", app.AuthH.VerifyEmail)
+	v1.POST("/password/forgot", app.AuthH.ForgotPassword)
+	v1.POST("/password/reset", app.AuthH.ResetPassword)
+	v1.GET("/files/:attachment_id", app.AttachmentH.ServeSigned)
+	v1.GET("/ws", middlewares.SocketAuth, app.WsH.Connect)
+
+	authRequired.POST("/logout", app.AuthH.Logout)
+	authRequired.POST("/verify-email/resend", app.AuthH.ResendVerification)
+	authRequired.GET("/2fa", app.AuthH.TwoFactorStatus)
+	authRequired.POST("/2fa/enroll", app.AuthH.EnrollTwoFactor)
+	authRequired.POST("/2fa/confirm", app.AuthH.ConfirmTwoFactor)
+	authRequired.POST("/2fa/recovery-codes", app.AuthH.RegenerateRecoveryCodes)
+	authRequired.DELETE("/2fa", app.AuthH.DisableTwoFactor)
+	authRequired.POST("/ws/ticket", app.AuthH.ConnectTicket)
+	authRequired.GET("/devices", app.WsH.ListDevices)
+	authRequired.DELETE("/devices/:device_id", app.WsH.DisconnectDevice)
+	authRequired.GET("/presence", app.WsH.GetPresence)
+
+	authRequired.POST("/attachments", app.AttachmentH.Upload)
+	authRequired.GET("/attachments/:attachment_id", app.AttachmentH.Download)
+	authRequired.GET("/attachments/:attachment_id/url", app.AttachmentH.GetSignedURL)
+
+	authRequired.GET("/search/messages", app.SearchH.SearchMessages)
 
-	authRequired.GET("/connect", app.ChatH.EstablishConnection)
-	authRequired.GET("/chat", app.ChatH.HandlePrivateChat)
-	authRequired.GET("/group/create", app.ChatH.GroupCreationHandler)
-	authRequired.GET("/group/message", app.ChatH.HandleGroupChat)
-	authRequired.GET("/group/manage", app.ChatH.HandleMembership)
+	authRequired.GET("/conversations", app.ChatH.GetConversations)
+	authRequired.GET("/chats/:chat_id/messages", app.ChatH.GetChatHistory)
+	authRequired.GET("/chats/:chat_id/sync", app.ChatH.SyncChat)
+	authRequired.GET("/messages/:message_id/edits", app.ChatH.GetEditHistory)
+	authRequired.GET("/groups/:group_id/messages", app.GroupH.GetGroupHistory)
+	authRequired.GET("/groups/:group_id/sync", app.GroupH.SyncGroup)
+	authRequired.GET("/groups/:group_id/threads/:message_id/messages", app.GroupH.GetThreadHistory)
+	authRequired.GET("/groups/:group_id/messages/:message_id/receipts", app.GroupH.GetMessageReceipts)
 }
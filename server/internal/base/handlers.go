@@ -1,11 +1,72 @@
 package base
 
-import "shiplabs/schat/internal/handlers"
+import (
+	"fmt"
+	"shiplabs/schat/internal/handlers"
+	"shiplabs/schat/internal/pkg/config"
+	"shiplabs/schat/internal/pkg/socket"
+)
 
 func (b *base) WithAuthController() handlers.AuthHandlerInterface {
 	return handlers.NewAuthHandler(b.WithAuthService())
 }
 
-func (b *base) WithChatController() handlers.WsHandlerInterface {
-	return handlers.NewWebSocketHandler(b.wsStore, b.WithPrivateChatService(), b.WithGroupService())
+func (b *base) WithWsController() handlers.WsHandlerInterface {
+	return handlers.NewWebSocketHandler(
+		b.wsStore,
+		b.pubsub,
+		b.registry,
+		b.socketOptions(),
+		config.Configs.WS.AllowedOrigins,
+		b.outboxOptions(),
+		b.WithPrivateChatService(),
+		b.WithGroupService(),
+		b.WithDeliveryService(),
+		b.WithPresenceService(),
+		b.WithOutboxService(),
+	)
+}
+
+func (b *base) WithChatController() handlers.ChatHandlerInterface {
+	return handlers.NewChatHandler(b.WithPrivateChatService())
+}
+
+func (b *base) WithGroupController() handlers.GroupHandlerInterface {
+	return handlers.NewGroupHandler(b.WithPrivateChatService(), b.WithDeliveryService())
+}
+
+func (b *base) WithAttachmentController() handlers.AttachmentHandlerInterface {
+	return handlers.NewAttachmentHandler(b.WithAttachmentService())
+}
+
+func (b *base) WithSearchController() handlers.SearchHandlerInterface {
+	return handlers.NewSearchHandler(b.WithSearchService())
+}
+
+func (b *base) socketOptions() socket.Options {
+	wsConfig := config.Configs.WS
+	policy, err := socket.ParseOverflowPolicy(wsConfig.SlowConsumerPolicy)
+	if err != nil {
+		fmt.Println("Error configuring websockets: ", err)
+		panic(err)
+	}
+
+	return socket.Options{
+		SendQueueSize:  wsConfig.SendQueueSize,
+		WriteTimeout:   wsConfig.WriteTimeout,
+		PongWait:       wsConfig.PongWait,
+		PingInterval:   wsConfig.PingInterval,
+		MaxMessageSize: wsConfig.MaxMessageSize,
+		Policy:         policy,
+	}
+}
+
+func (b *base) outboxOptions() handlers.OutboxOptions {
+	outboxConfig := config.Configs.Outbox
+	return handlers.OutboxOptions{
+		PollInterval: outboxConfig.PollInterval,
+		BatchSize:    outboxConfig.BatchSize,
+		Lease:        outboxConfig.Lease,
+		Retention:    outboxConfig.Retention,
+	}
 }
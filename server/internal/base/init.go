@@ -2,25 +2,51 @@ package base
 
 import (
 	"shiplabs/schat/internal/handlers"
+	"shiplabs/schat/internal/pkg/blob"
+	"shiplabs/schat/internal/pkg/broker"
+	"shiplabs/schat/internal/pkg/keyring"
+	"shiplabs/schat/internal/pkg/mailer"
 	"shiplabs/schat/internal/pkg/store"
 
 	"gorm.io/gorm"
 )
 
 type base struct {
-	db      *gorm.DB
-	wsStore store.ConnectionStoreInterface
+	db       *gorm.DB
+	wsStore  store.ConnectionStoreInterface
+	storage  blob.Storage
+	pubsub   broker.Broker
+	registry broker.Registry
+	signer   *keyring.Signer
+	mailer   mailer.Mailer
 }
 
 type baseHandlers struct {
-	AuthH handlers.AuthHandlerInterface
-	ChatH handlers.WsHandlerInterface
+	AuthH       handlers.AuthHandlerInterface
+	WsH         handlers.WsHandlerInterface
+	ChatH       handlers.ChatHandlerInterface
+	GroupH      handlers.GroupHandlerInterface
+	AttachmentH handlers.AttachmentHandlerInterface
+	SearchH     handlers.SearchHandlerInterface
 }
 
-func New(db *gorm.DB, store store.ConnectionStoreInterface) *base {
+func New(
+	db *gorm.DB,
+	store store.ConnectionStoreInterface,
+	storage blob.Storage,
+	pubsub broker.Broker,
+	registry broker.Registry,
+	signer *keyring.Signer,
+	mail mailer.Mailer,
+) *base {
 	return &base{
-		db:      db,
-		wsStore: store,
+		db:       db,
+		wsStore:  store,
+		storage:  storage,
+		pubsub:   pubsub,
+		registry: registry,
+		signer:   signer,
+		mailer:   mail,
 	}
 }
 
@@ -28,7 +54,11 @@ func (b *base) MountHandlers() baseHandlers {
 	var h baseHandlers
 
 	h.AuthH = b.WithAuthController()
+	h.WsH = b.WithWsController()
 	h.ChatH = b.WithChatController()
+	h.GroupH = b.WithGroupController()
+	h.AttachmentH = b.WithAttachmentController()
+	h.SearchH = b.WithSearchController()
 
 	return h
 }
@@ -21,3 +21,43 @@ func (b *base) WithGroupRepo() repos.GroupRepoInterface {
 func (b *base) WithPrivateChatRepo() repos.PrivateChatRepoInterface {
 	return repos.NewPrivateChatRepo(*b.db)
 }
+
+func (b *base) WithDeliveryRepo() repos.DeliveryRepoInterface {
+	return repos.NewDeliveryRepo(*b.db)
+}
+
+func (b *base) WithMessageActionRepo() repos.MessageActionRepoInterface {
+	return repos.NewMessageActionRepo(*b.db)
+}
+
+func (b *base) WithReactionRepo() repos.ReactionRepoInterface {
+	return repos.NewReactionRepo(*b.db)
+}
+
+func (b *base) WithAttachmentRepo() repos.AttachmentRepoInterface {
+	return repos.NewAttachmentRepo(*b.db)
+}
+
+func (b *base) WithSearchRepo() repos.SearchRepoInterface {
+	return repos.NewSearchRepo(*b.db)
+}
+
+func (b *base) WithOutboxRepo() repos.OutboxRepoInterface {
+	return repos.NewOutboxRepo(*b.db)
+}
+
+func (b *base) WithSessionRepo() repos.SessionRepoInterface {
+	return repos.NewSessionRepo(*b.db)
+}
+
+func (b *base) WithSigningKeyRepo() repos.SigningKeyRepoInterface {
+	return repos.NewSigningKeyRepo(*b.db)
+}
+
+func (b *base) WithUserTokenRepo() repos.UserTokenRepoInterface {
+	return repos.NewUserTokenRepo(*b.db)
+}
+
+func (b *base) WithTwoFactorRepo() repos.TwoFactorRepoInterface {
+	return repos.NewTwoFactorRepo(*b.db)
+}
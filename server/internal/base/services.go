@@ -5,7 +5,16 @@ import (
 )
 
 func (b *base) WithAuthService() services.AuthServiceInterface {
-	return services.NewAuthService(b.WithUserRepo())
+	return services.NewAuthService(
+		b.WithUserRepo(),
+		b.WithSessionRepo(),
+		b.WithOutboxRepo(),
+		b.WithSigningKeyRepo(),
+		b.WithUserTokenRepo(),
+		b.WithTwoFactorRepo(),
+		b.signer,
+		b.mailer,
+	)
 }
 
 func (b *base) WithPrivateChatService() services.ChatServiceInterface {
@@ -15,6 +24,11 @@ func (b *base) WithPrivateChatService() services.ChatServiceInterface {
 		b.WithGroupRepo(),
 		b.WithGroupMsgRepo(),
 		b.WithPrivateMsgRepo(),
+		b.WithDeliveryRepo(),
+		b.WithMessageActionRepo(),
+		b.WithReactionRepo(),
+		b.WithAttachmentRepo(),
+		b.WithOutboxRepo(),
 	)
 }
 
@@ -22,5 +36,39 @@ func (b *base) WithGroupService() services.GroupServiceInterface {
 	return services.NewGroupService(
 		b.WithUserRepo(),
 		b.WithGroupRepo(),
+		b.WithOutboxRepo(),
 	)
 }
+
+func (b *base) WithDeliveryService() services.DeliveryServiceInterface {
+	return services.NewDeliveryService(
+		b.WithDeliveryRepo(),
+		b.WithPrivateMsgRepo(),
+		b.WithGroupMsgRepo(),
+		b.WithGroupRepo(),
+		b.WithOutboxRepo(),
+	)
+}
+
+func (b *base) WithAttachmentService() services.AttachmentServiceInterface {
+	return services.NewAttachmentService(
+		b.WithAttachmentRepo(),
+		b.WithPrivateMsgRepo(),
+		b.WithGroupMsgRepo(),
+		b.WithPrivateChatRepo(),
+		b.WithGroupRepo(),
+		b.storage,
+	)
+}
+
+func (b *base) WithSearchService() services.SearchServiceInterface {
+	return services.NewSearchService(b.WithSearchRepo())
+}
+
+func (b *base) WithPresenceService() services.PresenceServiceInterface {
+	return services.NewPresenceService(b.WithUserRepo(), b.WithPrivateChatRepo(), b.WithGroupRepo())
+}
+
+func (b *base) WithOutboxService() services.OutboxServiceInterface {
+	return services.NewOutboxService(b.WithOutboxRepo())
+}
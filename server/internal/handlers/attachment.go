@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/internal/services"
+	"shiplabs/schat/pkg/shared"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type AttachmentHandlerInterface interface {
+	Upload(ctx *gin.Context)
+	Download(ctx *gin.Context)
+	GetSignedURL(ctx *gin.Context)
+	ServeSigned(ctx *gin.Context)
+}
+
+type attachmentHandler struct {
+	attachmentService services.AttachmentServiceInterface
+}
+
+func NewAttachmentHandler(attachmentS services.AttachmentServiceInterface) AttachmentHandlerInterface {
+	return &attachmentHandler{
+		attachmentService: attachmentS,
+	}
+}
+
+// multipartOverhead leaves room for the multipart boundaries and the other
+// form fields on top of the largest file we accept.
+const multipartOverhead = 1 << 20
+
+func (a *attachmentHandler) Upload(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, a.attachmentService.MaxUploadSize()+multipartOverhead)
+
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			shared.ErrorResponse(ctx, http.StatusRequestEntityTooLarge, services.ErrFileTooLarge.Error())
+			return
+		}
+		shared.ErrorResponse(ctx, http.StatusBadRequest, "file is required")
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, "could not read file")
+		return
+	}
+	defer file.Close()
+
+	attachment, err := a.attachmentService.Upload(ctx.Request.Context(), userID, services.UploadDto{
+		Type:     models.ValidMsgType(ctx.PostForm("type")),
+		FileName: fileHeader.Filename,
+		Size:     fileHeader.Size,
+		File:     file,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrFileTooLarge):
+			shared.ErrorResponse(ctx, http.StatusRequestEntityTooLarge, err.Error())
+		case errors.Is(err, services.ErrUnsupportedMedia), errors.Is(err, services.ErrMimeMismatch):
+			shared.ErrorResponse(ctx, http.StatusUnsupportedMediaType, err.Error())
+		default:
+			shared.ErrorResponse(ctx, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusCreated, shared.SUCCESS, attachment)
+}
+
+// Download serves an attachment to an authenticated chat member. Range
+// requests are honoured so audio and video can be seeked.
+func (a *attachmentHandler) Download(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	attachmentID, err := uuid.Parse(ctx.Param("attachment_id"))
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, services.ErrInvalidID.Error())
+		return
+	}
+
+	var query services.DownloadQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, "invalid query parameters")
+		return
+	}
+
+	file, err := a.attachmentService.Open(ctx.Request.Context(), userID, attachmentID, query.Variant)
+	if err != nil {
+		shared.ErrorResponse(ctx, downloadStatus(err), err.Error())
+		return
+	}
+
+	ctx.Header("Cache-Control", "private, no-store")
+	serveFile(ctx, file)
+}
+
+func (a *attachmentHandler) GetSignedURL(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	attachmentID, err := uuid.Parse(ctx.Param("attachment_id"))
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, services.ErrInvalidID.Error())
+		return
+	}
+
+	signed, err := a.attachmentService.SignURL(userID, attachmentID, services.AttachmentVariant(ctx.Query("variant")))
+	if err != nil {
+		shared.ErrorResponse(ctx, downloadStatus(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, signed)
+}
+
+// ServeSigned is mounted outside the auth middleware; the signature in the
+// query string is the credential.
+func (a *attachmentHandler) ServeSigned(ctx *gin.Context) {
+	attachmentID, err := uuid.Parse(ctx.Param("attachment_id"))
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, services.ErrInvalidID.Error())
+		return
+	}
+
+	var query services.DownloadQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, "invalid query parameters")
+		return
+	}
+
+	file, err := a.attachmentService.OpenSigned(ctx.Request.Context(), attachmentID, query)
+	if err != nil {
+		shared.ErrorResponse(ctx, downloadStatus(err), err.Error())
+		return
+	}
+
+	ctx.Header("Cache-Control", "private, max-age=300")
+	serveFile(ctx, file)
+}
+
+// serveFile sends the file from the API origin, so it must never be able to
+// run script there: the CSP sandboxes it and only known media types are
+// displayed inline.
+func serveFile(ctx *gin.Context, file services.AttachmentFile) {
+	defer file.Content.Close()
+
+	disposition := "attachment"
+	if services.InlineType(file.MimeType) {
+		disposition = "inline"
+	}
+
+	ctx.Header("Content-Type", file.MimeType)
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	ctx.Header("Content-Security-Policy", "default-src 'none'; sandbox")
+	ctx.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": file.Name}))
+	http.ServeContent(ctx.Writer, ctx.Request, file.Name, file.ModTime, file.Content)
+}
+
+func downloadStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrAttachmentNotFound), errors.Is(err, services.ErrNoThumbnail):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidSignature), errors.Is(err, services.ErrLinkExpired):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrInvalidVariant):
+		return http.StatusBadRequest
+	default:
+		fmt.Println("Error serving attachment: ", err)
+		return http.StatusInternalServerError
+	}
+}
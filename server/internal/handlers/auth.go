@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"shiplabs/schat/internal/services"
 	"shiplabs/schat/pkg/shared"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type LoginDto struct {
@@ -19,9 +21,49 @@ type SignUpDto struct {
 	Password string `json:"password"`
 }
 
+type RefreshDto struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type CompleteLoginDto struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type TwoFactorCodeDto struct {
+	Code string `json:"code"`
+}
+
+type VerifyEmailDto struct {
+	Token string `json:"token"`
+}
+
+type ForgotPasswordDto struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordDto struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
 type AuthHandlerInterface interface {
 	SignUp(ctx *gin.Context)
 	Login(ctx *gin.Context)
+	CompleteLogin(ctx *gin.Context)
+	Refresh(ctx *gin.Context)
+	Logout(ctx *gin.Context)
+	JWKS(ctx *gin.Context)
+	ConnectTicket(ctx *gin.Context)
+	VerifyEmail(ctx *gin.Context)
+	ResendVerification(ctx *gin.Context)
+	ForgotPassword(ctx *gin.Context)
+	ResetPassword(ctx *gin.Context)
+	TwoFactorStatus(ctx *gin.Context)
+	EnrollTwoFactor(ctx *gin.Context)
+	ConfirmTwoFactor(ctx *gin.Context)
+	RegenerateRecoveryCodes(ctx *gin.Context)
+	DisableTwoFactor(ctx *gin.Context)
 }
 
 type authHandler struct {
@@ -40,13 +82,13 @@ func (a *authHandler) SignUp(ctx *gin.Context) {
 		return
 	}
 
-	token, err := a.authService.SignUp(b.Name, b.Email, b.Password)
+	result, err := a.authService.SignUp(b.Name, b.Email, b.Password)
 	if err != nil {
 		shared.ErrorResponse(ctx, http.StatusUnprocessableEntity, err.Error())
 		return
 	}
 
-	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, map[string]string{"token": token})
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, result)
 }
 
 func (a *authHandler) Login(ctx *gin.Context) {
@@ -55,11 +97,230 @@ func (a *authHandler) Login(ctx *gin.Context) {
 		return
 	}
 
-	token, err := a.authService.Login(b.Email, b.Password)
+	result, err := a.authService.Login(b.Email, b.Password)
 	if err != nil {
 		shared.ErrorResponse(ctx, http.StatusUnprocessableEntity, err.Error())
 		return
 	}
 
-	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, map[string]string{"token": token})
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, result)
+}
+
+// CompleteLogin trades a login challenge and a TOTP or recovery code for
+// the tokens.
+func (a *authHandler) CompleteLogin(ctx *gin.Context) {
+	var b CompleteLoginDto
+	if !shared.ParseBody(ctx, &b) {
+		return
+	}
+
+	tokens, err := a.authService.CompleteLogin(b.ChallengeToken, b.Code)
+	if err != nil {
+		shared.ErrorResponse(ctx, twoFactorStatus(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, tokens)
+}
+
+func (a *authHandler) Refresh(ctx *gin.Context) {
+	var b RefreshDto
+	if !shared.ParseBody(ctx, &b) {
+		return
+	}
+
+	tokens, err := a.authService.Refresh(b.RefreshToken)
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, tokens)
+}
+
+// Logout revokes the caller's session, its access and refresh tokens stop
+// working and its sockets are closed.
+func (a *authHandler) Logout(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	sessionID := uuid.MustParse(ctx.GetString("sessionID"))
+	if err := a.authService.Logout(userID, sessionID); err != nil {
+		shared.ErrorResponse(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, nil)
+}
+
+// JWKS publishes the public keys schat tokens can be verified with. It is
+// served as a bare JWK set, the format verifiers expect.
+func (a *authHandler) JWKS(ctx *gin.Context) {
+	set, err := a.authService.JWKS()
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, set)
+}
+
+// ConnectTicket issues the ticket a browser opens its websocket with.
+func (a *authHandler) ConnectTicket(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	sessionID := uuid.MustParse(ctx.GetString("sessionID"))
+	ticket, err := a.authService.IssueConnectTicket(userID, sessionID)
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, ticket)
+}
+
+func (a *authHandler) VerifyEmail(ctx *gin.Context) {
+	var b VerifyEmailDto
+	if !shared.ParseBody(ctx, &b) {
+		return
+	}
+
+	if err := a.authService.VerifyEmail(b.Token); err != nil {
+		shared.ErrorResponse(ctx, tokenStatus(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, nil)
+}
+
+func (a *authHandler) ResendVerification(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	if err := a.authService.ResendVerification(userID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrEmailAlreadyVerified) {
+			status = http.StatusConflict
+		}
+		shared.ErrorResponse(ctx, status, err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, nil)
+}
+
+// ForgotPassword answers the same whether or not the email is known.
+func (a *authHandler) ForgotPassword(ctx *gin.Context) {
+	var b ForgotPasswordDto
+	if !shared.ParseBody(ctx, &b) {
+		return
+	}
+
+	if err := a.authService.RequestPasswordReset(b.Email); err != nil {
+		shared.ErrorResponse(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusAccepted, shared.SUCCESS, nil)
+}
+
+func (a *authHandler) ResetPassword(ctx *gin.Context) {
+	var b ResetPasswordDto
+	if !shared.ParseBody(ctx, &b) {
+		return
+	}
+
+	if err := a.authService.ResetPassword(b.Token, b.Password); err != nil {
+		shared.ErrorResponse(ctx, tokenStatus(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, nil)
+}
+
+func tokenStatus(err error) int {
+	if errors.Is(err, services.ErrInvalidUserToken) || errors.Is(err, services.ErrWeakPassword) ||
+		errors.Is(err, services.ErrPasswordTooLong) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func (a *authHandler) TwoFactorStatus(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	status, err := a.authService.TwoFactorStatus(userID)
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, status)
+}
+
+func (a *authHandler) EnrollTwoFactor(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	enrollment, err := a.authService.EnrollTwoFactor(userID)
+	if err != nil {
+		shared.ErrorResponse(ctx, twoFactorStatus(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, enrollment)
+}
+
+func (a *authHandler) ConfirmTwoFactor(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	var b TwoFactorCodeDto
+	if !shared.ParseBody(ctx, &b) {
+		return
+	}
+
+	codes, err := a.authService.ConfirmTwoFactor(userID, b.Code)
+	if err != nil {
+		shared.ErrorResponse(ctx, twoFactorStatus(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, codes)
+}
+
+func (a *authHandler) RegenerateRecoveryCodes(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	var b TwoFactorCodeDto
+	if !shared.ParseBody(ctx, &b) {
+		return
+	}
+
+	codes, err := a.authService.RegenerateRecoveryCodes(userID, b.Code)
+	if err != nil {
+		shared.ErrorResponse(ctx, twoFactorStatus(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, codes)
+}
+
+func (a *authHandler) DisableTwoFactor(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	var b TwoFactorCodeDto
+	if !shared.ParseBody(ctx, &b) {
+		return
+	}
+
+	if err := a.authService.DisableTwoFactor(userID, b.Code); err != nil {
+		shared.ErrorResponse(ctx, twoFactorStatus(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, nil)
+}
+
+func twoFactorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidTwoFactorCode), errors.Is(err, services.ErrInvalidChallenge):
+		return http.StatusUnauthorized
+	case errors.Is(err, services.ErrTwoFactorLocked):
+		return http.StatusTooManyRequests
+	case errors.Is(err, services.ErrTwoFactorEnabled), errors.Is(err, services.ErrTwoFactorNotEnabled),
+		errors.Is(err, services.ErrTwoFactorNotEnrolled):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
 }
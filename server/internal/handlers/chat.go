@@ -1 +1,103 @@
 package handlers
+
+import (
+	"net/http"
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/internal/services"
+	"shiplabs/schat/pkg/shared"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ChatHandlerInterface interface {
+	GetConversations(ctx *gin.Context)
+	GetChatHistory(ctx *gin.Context)
+	SyncChat(ctx *gin.Context)
+	GetEditHistory(ctx *gin.Context)
+}
+
+type chatHandler struct {
+	chatService services.ChatServiceInterface
+}
+
+func NewChatHandler(chatS services.ChatServiceInterface) ChatHandlerInterface {
+	return &chatHandler{
+		chatService: chatS,
+	}
+}
+
+func (c *chatHandler) GetConversations(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	conversations, err := c.chatService.GetConversations(userID)
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, conversations)
+}
+
+func (c *chatHandler) GetChatHistory(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	chatID, err := uuid.Parse(ctx.Param("chat_id"))
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, services.ErrInvalidID.Error())
+		return
+	}
+
+	var query services.HistoryQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, "invalid query parameters")
+		return
+	}
+
+	history, err := c.chatService.GetPrivateChatHistory(userID, chatID, query)
+	if err != nil {
+		shared.ErrorResponse(ctx, statusFor(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, history)
+}
+
+// SyncChat returns the messages a client is missing after the last
+// sequence number it has.
+func (c *chatHandler) SyncChat(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	chatID, err := uuid.Parse(ctx.Param("chat_id"))
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, services.ErrInvalidID.Error())
+		return
+	}
+
+	var query services.SyncQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, "invalid query parameters")
+		return
+	}
+
+	sync, err := c.chatService.SyncPrivateChat(userID, chatID, query)
+	if err != nil {
+		shared.ErrorResponse(ctx, statusFor(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, sync)
+}
+
+func (c *chatHandler) GetEditHistory(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	ref := services.MessageRef{
+		ConversationType: models.ConversationType(ctx.Query("conversation_type")),
+		MessageID:        ctx.Param("message_id"),
+	}
+
+	edits, err := c.chatService.GetEditHistory(userID, ref)
+	if err != nil {
+		shared.ErrorResponse(ctx, statusFor(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, edits)
+}
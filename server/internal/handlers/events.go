@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"shiplabs/schat/internal/pkg/socket"
+	"shiplabs/schat/internal/services"
+)
+
+// Handlers only persist the change. Everyone else hears about it from the
+// outbox dispatcher once the change is committed.
+func (w *wsHandler) registerEvents() {
+	w.on(socket.EventPrivateMessage, w.onPrivateMessage)
+	w.on(socket.EventGroupMessage, w.onGroupMessage)
+	w.on(socket.EventGroupCreate, w.onGroupCreate)
+	w.on(socket.EventGroupMembership, w.onGroupMembership)
+	w.on(socket.EventReceipt, w.onReceipt)
+	w.on(socket.EventMessageEdit, w.onMessageEdit)
+	w.on(socket.EventMessageDelete, w.onMessageDelete)
+	w.on(socket.EventGroupMute, w.onGroupMute)
+	w.on(socket.EventReactionAdd, w.onReaction(services.AddReaction))
+	w.on(socket.EventReactionRemove, w.onReaction(services.RemoveReaction))
+	w.on(socket.EventTyping, w.onTyping)
+	w.on(socket.EventPresence, w.onPresence)
+}
+
+func (w *wsHandler) onPrivateMessage(sender *socket.Client, payload json.RawMessage) (any, error) {
+	var data services.PrivateMessageDto
+	if err := decodePayload(payload, &data); err != nil {
+		return nil, err
+	}
+	data.DeviceID = sender.DeviceID
+
+	return w.chatService.SendPrivateMsg(sender.UserID, data)
+}
+
+func (w *wsHandler) onGroupMessage(sender *socket.Client, payload json.RawMessage) (any, error) {
+	var data services.GroupMessageDto
+	if err := decodePayload(payload, &data); err != nil {
+		return nil, err
+	}
+	data.DeviceID = sender.DeviceID
+
+	return w.chatService.SendMsgToGroup(sender.UserID, data)
+}
+
+func (w *wsHandler) onGroupCreate(creator *socket.Client, payload json.RawMessage) (any, error) {
+	var data services.CreateGroupDto
+	if err := decodePayload(payload, &data); err != nil {
+		return nil, err
+	}
+	data.DeviceID = creator.DeviceID
+
+	return w.groupService.CreateGroup(creator.UserID, data)
+}
+
+func (w *wsHandler) onGroupMembership(admin *socket.Client, payload json.RawMessage) (any, error) {
+	var data services.GroupMembershipDto
+	if err := decodePayload(payload, &data); err != nil {
+		return nil, err
+	}
+	data.DeviceID = admin.DeviceID
+
+	return w.groupService.HandleMembership(admin.UserID, data)
+}
+
+func (w *wsHandler) onGroupMute(client *socket.Client, payload json.RawMessage) (any, error) {
+	var data services.GroupMuteDto
+	if err := decodePayload(payload, &data); err != nil {
+		return nil, err
+	}
+	data.DeviceID = client.DeviceID
+
+	return w.groupService.SetMuted(client.UserID, data)
+}
+
+func (w *wsHandler) onReceipt(client *socket.Client, payload json.RawMessage) (any, error) {
+	var data services.ReceiptDto
+	if err := decodePayload(payload, &data); err != nil {
+		return nil, err
+	}
+
+	return w.deliveryService.Acknowledge(client.UserID, data)
+}
+
+func (w *wsHandler) onMessageEdit(client *socket.Client, payload json.RawMessage) (any, error) {
+	var data services.EditMessageDto
+	if err := decodePayload(payload, &data); err != nil {
+		return nil, err
+	}
+	data.DeviceID = client.DeviceID
+
+	return w.chatService.EditMessage(client.UserID, data)
+}
+
+func (w *wsHandler) onMessageDelete(client *socket.Client, payload json.RawMessage) (any, error) {
+	var data services.DeleteMessageDto
+	if err := decodePayload(payload, &data); err != nil {
+		return nil, err
+	}
+	data.DeviceID = client.DeviceID
+
+	return w.chatService.DeleteMessage(client.UserID, data)
+}
+
+func (w *wsHandler) onReaction(action services.ReactionAction) eventHandlerFunc {
+	return func(client *socket.Client, payload json.RawMessage) (any, error) {
+		var data services.ReactionDto
+		if err := decodePayload(payload, &data); err != nil {
+			return nil, err
+		}
+		data.DeviceID = client.DeviceID
+
+		return w.chatService.React(client.UserID, data, action)
+	}
+}
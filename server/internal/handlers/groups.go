@@ -1 +1,143 @@
 package handlers
+
+import (
+	"errors"
+	"net/http"
+	"shiplabs/schat/internal/services"
+	"shiplabs/schat/pkg/shared"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type GroupHandlerInterface interface {
+	GetGroupHistory(ctx *gin.Context)
+	SyncGroup(ctx *gin.Context)
+	GetThreadHistory(ctx *gin.Context)
+	GetMessageReceipts(ctx *gin.Context)
+}
+
+type groupHandler struct {
+	chatService     services.ChatServiceInterface
+	deliveryService services.DeliveryServiceInterface
+}
+
+func NewGroupHandler(
+	chatS services.ChatServiceInterface,
+	deliveryS services.DeliveryServiceInterface,
+) GroupHandlerInterface {
+	return &groupHandler{
+		chatService:     chatS,
+		deliveryService: deliveryS,
+	}
+}
+
+func (g *groupHandler) GetGroupHistory(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	groupID, err := uuid.Parse(ctx.Param("group_id"))
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, ErrInvalidGroup.Error())
+		return
+	}
+
+	var query services.HistoryQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, "invalid query parameters")
+		return
+	}
+
+	history, err := g.chatService.GetGroupHistory(userID, groupID, query)
+	if err != nil {
+		shared.ErrorResponse(ctx, statusFor(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, history)
+}
+
+func (g *groupHandler) SyncGroup(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	groupID, err := uuid.Parse(ctx.Param("group_id"))
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, ErrInvalidGroup.Error())
+		return
+	}
+
+	var query services.SyncQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, "invalid query parameters")
+		return
+	}
+
+	sync, err := g.chatService.SyncGroup(userID, groupID, query)
+	if err != nil {
+		shared.ErrorResponse(ctx, statusFor(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, sync)
+}
+
+func (g *groupHandler) GetThreadHistory(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	groupID, err := uuid.Parse(ctx.Param("group_id"))
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, ErrInvalidGroup.Error())
+		return
+	}
+	rootID, err := uuid.Parse(ctx.Param("message_id"))
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, services.ErrInvalidID.Error())
+		return
+	}
+
+	var query services.HistoryQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, "invalid query parameters")
+		return
+	}
+
+	history, err := g.chatService.GetThreadHistory(userID, groupID, rootID, query)
+	if err != nil {
+		shared.ErrorResponse(ctx, statusFor(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, history)
+}
+
+func (g *groupHandler) GetMessageReceipts(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	groupID, err := uuid.Parse(ctx.Param("group_id"))
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, ErrInvalidGroup.Error())
+		return
+	}
+	messageID, err := uuid.Parse(ctx.Param("message_id"))
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, services.ErrInvalidID.Error())
+		return
+	}
+
+	receipts, err := g.deliveryService.GetGroupReceipts(userID, groupID, messageID)
+	if err != nil {
+		shared.ErrorResponse(ctx, statusFor(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, receipts)
+}
+
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, services.ErrNotMember), errors.Is(err, services.ErrNotSender):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrMessageNotFound), errors.Is(err, services.ErrChat404):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidID), errors.Is(err, services.ErrInvalidCursor),
+		errors.Is(err, services.ErrBothCursors), errors.Is(err, services.ErrInvalidConversation):
+		return http.StatusBadRequest
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
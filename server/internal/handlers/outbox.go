@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"shiplabs/schat/internal/pkg/socket"
+	"shiplabs/schat/internal/services"
+	"time"
+)
+
+const outboxPurgeInterval = time.Hour
+
+// OutboxOptions tunes the dispatcher pushing committed notices to clients.
+type OutboxOptions struct {
+	PollInterval time.Duration
+	BatchSize    int
+	Lease        time.Duration
+	Retention    time.Duration
+}
+
+// runOutbox dispatches notices as they are committed. Every instance runs
+// it, claims are leased so a notice claimed by an instance that dies is
+// picked up again once the lease runs out.
+func (w *wsHandler) runOutbox() {
+	poll := time.NewTicker(w.outboxOpts.PollInterval)
+	defer poll.Stop()
+	purge := time.NewTicker(outboxPurgeInterval)
+	defer purge.Stop()
+
+	for {
+		select {
+		case <-poll.C:
+		case <-services.OutboxWake():
+		case <-purge.C:
+			if err := w.outboxService.Purge(w.outboxOpts.Retention); err != nil {
+				log.Println("failed to purge outbox", err)
+			}
+			continue
+		}
+		w.drainOutbox()
+	}
+}
+
+func (w *wsHandler) drainOutbox() {
+	for {
+		notices, err := w.outboxService.Claim(w.outboxOpts.BatchSize, w.outboxOpts.Lease)
+		if err != nil {
+			log.Println("failed to claim outbox events", err)
+			return
+		}
+		for _, notice := range notices {
+			w.dispatchNotice(notice)
+		}
+		if len(notices) < w.outboxOpts.BatchSize {
+			return
+		}
+	}
+}
+
+// dispatchNotice pushes a notice to its recipients. Delivery is at least
+// once, a notice whose completion isn't recorded is sent again.
+func (w *wsHandler) dispatchNotice(notice services.Notice) {
+	event := socket.Event{
+		Type:       notice.Kind,
+		StatusCode: http.StatusOK,
+		Payload:    notice.Payload,
+	}
+	if notice.Kind == services.NoticeSessionRevoked {
+		for _, recipient := range notice.Recipients {
+			w.revokeSession(recipient.UserID, event)
+		}
+		w.completeNotice(notice)
+		return
+	}
+
+	for _, recipient := range notice.Recipients {
+		recipientEvent := event
+		recipientEvent.Silent = recipient.Silent
+		if recipient.Track && notice.MessageID != nil {
+			w.deliver(recipient.UserID, *notice.MessageID, recipientEvent)
+			continue
+		}
+		w.transmitExcept(recipient.UserID, recipient.SkipDevice, recipientEvent)
+	}
+
+	w.completeNotice(notice)
+}
+
+func (w *wsHandler) completeNotice(notice services.Notice) {
+	if err := w.outboxService.Complete(notice.ID); err != nil {
+		log.Println("failed to complete outbox event", notice.ID, err)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"shiplabs/schat/internal/pkg/broker"
+	"shiplabs/schat/internal/pkg/store"
+	"shiplabs/schat/internal/services"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// noticeQueue hands out its notices a batch at a time and records which
+// were completed.
+type noticeQueue struct {
+	services.OutboxServiceInterface
+	notices   []services.Notice
+	claims    int
+	completed []uuid.UUID
+}
+
+func (q *noticeQueue) Claim(limit int, _ time.Duration) ([]services.Notice, error) {
+	q.claims++
+	batch := q.notices[:min(limit, len(q.notices))]
+	q.notices = q.notices[len(batch):]
+	return batch, nil
+}
+
+func (q *noticeQueue) Complete(id uuid.UUID) error {
+	q.completed = append(q.completed, id)
+	return nil
+}
+
+func TestDrainOutbox(t *testing.T) {
+	client, peer := newTestClient(t)
+	clients := store.NewWsStore()
+	clients.SaveClient(client)
+
+	var notices []services.Notice
+	for n := range 5 {
+		notices = append(notices, services.Notice{
+			ID:         uuid.New(),
+			Kind:       services.NoticeGroupMembership,
+			Payload:    json.RawMessage(strconv.Itoa(n)),
+			Recipients: []services.Recipient{{UserID: client.UserID}},
+		})
+	}
+	queue := &noticeQueue{notices: notices}
+	w := &wsHandler{
+		store:         clients,
+		broker:        broker.NewMemoryBroker("node"),
+		registry:      broker.NewMemoryRegistry(),
+		outboxService: queue,
+		outboxOpts:    OutboxOptions{BatchSize: 2},
+	}
+
+	w.drainOutbox()
+
+	// two full batches and the short one ending the drain
+	if queue.claims != 3 {
+		t.Fatalf("claimed %d times, want 3", queue.claims)
+	}
+	if len(queue.completed) != len(notices) {
+		t.Fatalf("%d notices completed, want %d", len(queue.completed), len(notices))
+	}
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for n, notice := range notices {
+		if queue.completed[n] != notice.ID {
+			t.Fatalf("completed %v, want the notices in order", queue.completed)
+		}
+		var event struct {
+			Type    string `json:"type"`
+			Payload int    `json:"payload"`
+		}
+		if err := peer.ReadJSON(&event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != notice.Kind || event.Payload != n {
+			t.Fatalf("pushed %s %d, want %s %d", event.Type, event.Payload, notice.Kind, n)
+		}
+	}
+}
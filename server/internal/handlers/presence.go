@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/internal/pkg/socket"
+	"shiplabs/schat/internal/services"
+	"shiplabs/schat/pkg/shared"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// typingThrottle caps how often one device may fan out the same typing
+	// state for a conversation. typingTimeout is how long receivers keep
+	// showing the indicator without a refresh.
+	typingThrottle = 3 * time.Second
+	typingTimeout  = 6 * time.Second
+	// participantsTTL is how long a device reuses the participants of a
+	// conversation it types in, sparing a lookup per indicator. Membership
+	// changes reach the indicators at most that late.
+	participantsTTL = 30 * time.Second
+	// presenceThrottle caps how often a device may flip between online
+	// and away.
+	presenceThrottle = 2 * time.Second
+)
+
+var ErrRateLimited = errors.New("too many requests, slow down")
+
+// onTyping relays a typing indicator to the other participants. Repeats
+// inside the throttle window are acknowledged but not fanned out.
+func (w *wsHandler) onTyping(client *socket.Client, payload json.RawMessage) (any, error) {
+	var data services.TypingDto
+	if err := decodePayload(payload, &data); err != nil {
+		return nil, err
+	}
+	if data.State != services.TypingStarted && data.State != services.TypingStopped {
+		return nil, services.ErrInvalidTyping
+	}
+	conversationID, err := uuid.Parse(data.ConversationID)
+	if err != nil {
+		return nil, services.ErrInvalidID
+	}
+
+	update := services.TypingUpdate{
+		UserID:           client.UserID,
+		ConversationType: data.ConversationType,
+		ConversationID:   conversationID,
+		State:            data.State,
+		ExpiresIn:        int(typingTimeout.Seconds()),
+	}
+	// throttled repeats are answered before any lookup, a device typing away
+	// costs one per conversation and throttle interval at most
+	if !client.Allow("typing:"+conversationID.String()+":"+string(data.State), typingThrottle) {
+		return update, nil
+	}
+	participants, err := w.typingParticipants(client, data.ConversationType, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(participants, client.UserID) {
+		return nil, services.ErrNotMember
+	}
+
+	event := socket.Event{
+		Type:       socket.EventTyping,
+		StatusCode: http.StatusOK,
+		Payload:    update,
+	}
+	for _, participant := range participants {
+		if participant != client.UserID {
+			w.transmit(participant, event)
+		}
+	}
+
+	return update, nil
+}
+
+// typingParticipants looks the participants of a conversation up once per
+// participantsTTL for each device.
+func (w *wsHandler) typingParticipants(client *socket.Client, conversationType models.ConversationType, conversationID uuid.UUID) ([]uuid.UUID, error) {
+	participants, err := client.Cached("participants:"+string(conversationType)+":"+conversationID.String(), participantsTTL, func() (any, error) {
+		return w.chatService.GetParticipants(conversationType, conversationID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return participants.([]uuid.UUID), nil
+}
+
+// onPresence lets a device mark itself away or back. The user only shows
+// as away once every connected device is.
+func (w *wsHandler) onPresence(client *socket.Client, payload json.RawMessage) (any, error) {
+	var data services.PresenceDto
+	if err := decodePayload(payload, &data); err != nil {
+		return nil, err
+	}
+	if data.Status != services.Online && data.Status != services.Away {
+		return nil, services.ErrInvalidPresence
+	}
+	if !client.Allow("presence", presenceThrottle) {
+		return nil, ErrRateLimited
+	}
+
+	client.SetAway(data.Status == services.Away)
+	w.register(client)
+	w.publishPresence(client.UserID)
+
+	return w.currentPresence(client.UserID), nil
+}
+
+// GetPresence returns the presence of everyone the caller may follow, so a
+// freshly connected client can render its contact list.
+func (w *wsHandler) GetPresence(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	audience, err := w.presenceService.GetAudience(userID)
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	presences := make([]services.Presence, 0, len(audience))
+	var offline []uuid.UUID
+	for _, contactID := range audience {
+		presence := w.currentPresence(contactID)
+		if presence.Status == services.Offline {
+			offline = append(offline, contactID)
+		}
+		presences = append(presences, presence)
+	}
+
+	lastSeen, err := w.presenceService.GetLastSeen(offline)
+	if err != nil {
+		shared.ErrorResponse(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i := range presences {
+		if seen, ok := lastSeen[presences[i].UserID]; ok {
+			presences[i].LastSeen = &seen
+		}
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, presences)
+}
+
+// currentPresence derives a user's presence from their live connections
+// across every instance.
+func (w *wsHandler) currentPresence(userID uuid.UUID) services.Presence {
+	presence := services.Presence{UserID: userID, Status: services.Offline}
+	conns, err := w.registry.Connections(userID)
+	if err != nil {
+		log.Println("failed to look up connections for", userID, err)
+		return presence
+	}
+	for _, conn := range conns {
+		if !conn.Away {
+			presence.Status = services.Online
+			return presence
+		}
+		presence.Status = services.Away
+	}
+	return presence
+}
+
+// publishPresence pushes the user's presence to their audience when it
+// differs from what was last announced, so reconnects and extra devices
+// don't generate noise.
+func (w *wsHandler) publishPresence(userID uuid.UUID) {
+	presence := w.currentPresence(userID)
+
+	w.presenceMu.Lock()
+	announced, known := w.presence[userID]
+	if presence.Status == announced || (!known && presence.Status == services.Offline) {
+		w.presenceMu.Unlock()
+		return
+	}
+	if presence.Status == services.Offline {
+		delete(w.presence, userID)
+	} else {
+		w.presence[userID] = presence.Status
+	}
+	w.presenceMu.Unlock()
+
+	if presence.Status == services.Offline {
+		now := shared.TimeNow()
+		presence.LastSeen = &now
+		if err := w.presenceService.RecordLastSeen(userID, now); err != nil {
+			log.Println("failed to record last seen for", userID, err)
+		}
+	}
+
+	audience, err := w.presenceService.GetAudience(userID)
+	if err != nil {
+		log.Println("failed to load presence audience for", userID, err)
+		return
+	}
+	event := socket.Event{
+		Type:       socket.EventPresence,
+		StatusCode: http.StatusOK,
+		Payload:    presence,
+	}
+	for _, contactID := range audience {
+		w.transmit(contactID, event)
+	}
+}
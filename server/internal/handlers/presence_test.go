@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/internal/pkg/socket"
+	"shiplabs/schat/internal/services"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// participantsLookup answers GetParticipants and counts the calls, the
+// rest of the chat service is never reached.
+type participantsLookup struct {
+	services.ChatServiceInterface
+	participants []uuid.UUID
+	calls        int
+}
+
+func (p *participantsLookup) GetParticipants(models.ConversationType, uuid.UUID) ([]uuid.UUID, error) {
+	p.calls++
+	return p.participants, nil
+}
+
+func typing(t *testing.T, conversationID uuid.UUID, state services.TypingState) json.RawMessage {
+	t.Helper()
+	payload, err := json.Marshal(services.TypingDto{
+		ConversationType: models.PrivateConversation,
+		ConversationID:   conversationID.String(),
+		State:            state,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestOnTypingLookups(t *testing.T) {
+	conversationID := uuid.New()
+	started := typing(t, conversationID, services.TypingStarted)
+	stopped := typing(t, conversationID, services.TypingStopped)
+
+	tests := []struct {
+		name      string
+		member    bool
+		frames    []json.RawMessage
+		wantErr   error
+		wantCalls int
+	}{
+		{"one lookup", true, []json.RawMessage{started}, nil, 1},
+		{"throttled repeats skip the lookup", true, []json.RawMessage{started, started, started}, nil, 1},
+		{"membership is reused", true, []json.RawMessage{started, stopped}, nil, 1},
+		{"not a member", false, []json.RawMessage{started}, services.ErrNotMember, 1},
+		{"not a member keeps failing", false, []json.RawMessage{started, stopped}, services.ErrNotMember, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &socket.Client{UserID: uuid.New()}
+			lookup := &participantsLookup{}
+			if tt.member {
+				lookup.participants = []uuid.UUID{client.UserID}
+			}
+			w := &wsHandler{chatService: lookup}
+
+			var err error
+			for _, frame := range tt.frames {
+				_, err = w.onTyping(client, frame)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("onTyping() error = %v, want %v", err, tt.wantErr)
+			}
+			if lookup.calls != tt.wantCalls {
+				t.Fatalf("%d participant lookups, want %d", lookup.calls, tt.wantCalls)
+			}
+		})
+	}
+}
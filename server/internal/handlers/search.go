@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"shiplabs/schat/internal/services"
+	"shiplabs/schat/pkg/shared"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type SearchHandlerInterface interface {
+	SearchMessages(ctx *gin.Context)
+}
+
+type searchHandler struct {
+	searchService services.SearchServiceInterface
+}
+
+func NewSearchHandler(searchS services.SearchServiceInterface) SearchHandlerInterface {
+	return &searchHandler{
+		searchService: searchS,
+	}
+}
+
+func (s *searchHandler) SearchMessages(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+
+	var query services.SearchQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		shared.ErrorResponse(ctx, http.StatusBadRequest, "invalid query parameters")
+		return
+	}
+
+	results, err := s.searchService.SearchMessages(userID, query)
+	if err != nil {
+		shared.ErrorResponse(ctx, searchStatus(err), err.Error())
+		return
+	}
+
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, results)
+}
+
+func searchStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrEmptySearch), errors.Is(err, services.ErrConversationFilter),
+		errors.Is(err, services.ErrInvalidDate), errors.Is(err, services.ErrInvalidOffset),
+		errors.Is(err, services.ErrInvalidID), errors.Is(err, services.ErrInvalidConversation),
+		errors.Is(err, services.ErrInvalidMsgType):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
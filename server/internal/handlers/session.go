@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"shiplabs/schat/internal/pkg/broker"
+	"shiplabs/schat/internal/pkg/socket"
+	"shiplabs/schat/internal/services"
+
+	"github.com/google/uuid"
+)
+
+// revokeSession closes the connections opened with a revoked session, here
+// and on every other instance holding one of the user's connections.
+func (w *wsHandler) revokeSession(userID uuid.UUID, event socket.Event) {
+	w.closeSession(userID, event)
+	w.publishRemote(broker.Delivery{
+		UserID: userID,
+		Event:  event,
+	})
+}
+
+// closeSession closes the session's local connections, the close frame
+// says why.
+func (w *wsHandler) closeSession(userID uuid.UUID, event socket.Event) {
+	revocation, err := decodeRevocation(event.Payload)
+	if err != nil {
+		log.Println("invalid session revocation for", userID, err)
+		return
+	}
+
+	sessionID := revocation.SessionID.String()
+	for _, client := range w.store.GetClients(userID) {
+		if client.SessionID != sessionID {
+			continue
+		}
+		client.CloseWithReason(services.ErrSessionRevoked.Error())
+		w.store.RemoveClient(client)
+	}
+}
+
+// decodeRevocation reads the payload whether it comes straight from the
+// outbox or was decoded by a broker.
+func decodeRevocation(payload any) (services.SessionRevocation, error) {
+	var revocation services.SessionRevocation
+	raw, ok := payload.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(payload); err != nil {
+			return revocation, err
+		}
+	}
+	err := json.Unmarshal(raw, &revocation)
+	return revocation, err
+}
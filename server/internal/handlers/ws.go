@@ -1,318 +1,484 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
+	"net/url"
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/internal/pkg/broker"
+	"shiplabs/schat/internal/pkg/socket"
 	"shiplabs/schat/internal/pkg/store"
 	"shiplabs/schat/internal/services"
 	"shiplabs/schat/pkg/shared"
+	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+// dispatchQueue is how many frames a client may have read but not yet
+// handled. Past that its read loop blocks until the handler catches up.
+const dispatchQueue = 32
+
 var (
 	ErrHandShakeFail        = errors.New("failed handshake, connection not established")
 	ErrInvalidGroup         = errors.New("invalid group")
-	ErrInvalidMemberID      = errors.New("invalid member id")
 	ErrInvalidMessageFormat = errors.New("invalid message format")
+	ErrUnknownEvent         = errors.New("unknown event type")
 )
 
-type WSResponse struct {
-	StatusCode   int    `json:"status_code"`
-	ErrorMessage string `json:"error_msg"`
-	Data         string `json:"data"`
-}
+type eventHandlerFunc func(client *socket.Client, payload json.RawMessage) (any, error)
 
+// wsHandler serves the connections held by this instance. Events for users
+// connected to other instances travel through the broker; the registry says
+// which instances those are.
 type wsHandler struct {
-	store        store.ConnectionStoreInterface
-	chatService  services.ChatServiceInterface
-	groupService services.GroupServiceInterface
+	store           store.ConnectionStoreInterface
+	broker          broker.Broker
+	registry        broker.Registry
+	clientOpts      socket.Options
+	upgrader        websocket.Upgrader
+	outboxOpts      OutboxOptions
+	chatService     services.ChatServiceInterface
+	groupService    services.GroupServiceInterface
+	deliveryService services.DeliveryServiceInterface
+	presenceService services.PresenceServiceInterface
+	outboxService   services.OutboxServiceInterface
+	events          map[string]eventHandlerFunc
+
+	// spilled holds the clients whose missed deliveries are being replayed
+	spilled sync.Map
+
+	// presence is the last status announced for each user not offline
+	presenceMu sync.Mutex
+	presence   map[uuid.UUID]services.PresenceStatus
 }
 
 type WsHandlerInterface interface {
-	EstablishConnection(ctx *gin.Context)
-	HandlePrivateChat(ctx *gin.Context)
-	GroupCreationHandler(ctx *gin.Context)
-	HandleGroupChat(ctx *gin.Context)
-	HandleMembership(ctx *gin.Context)
+	Connect(ctx *gin.Context)
+	ListDevices(ctx *gin.Context)
+	DisconnectDevice(ctx *gin.Context)
+	GetPresence(ctx *gin.Context)
 }
 
 func NewWebSocketHandler(
 	store store.ConnectionStoreInterface,
+	pubsub broker.Broker,
+	registry broker.Registry,
+	clientOpts socket.Options,
+	allowedOrigins []string,
+	outboxOpts OutboxOptions,
 	pChatService services.ChatServiceInterface,
 	groupService services.GroupServiceInterface,
+	deliveryService services.DeliveryServiceInterface,
+	presenceService services.PresenceServiceInterface,
+	outboxService services.OutboxServiceInterface,
 ) WsHandlerInterface {
-	return &wsHandler{
-		store:        store,
-		chatService:  pChatService,
-		groupService: groupService,
+	w := &wsHandler{
+		store:           store,
+		broker:          pubsub,
+		registry:        registry,
+		clientOpts:      clientOpts,
+		upgrader:        newUpgrader(allowedOrigins),
+		outboxOpts:      outboxOpts,
+		chatService:     pChatService,
+		groupService:    groupService,
+		deliveryService: deliveryService,
+		presenceService: presenceService,
+		outboxService:   outboxService,
+		events:          map[string]eventHandlerFunc{},
+		presence:        map[uuid.UUID]services.PresenceStatus{},
 	}
-}
+	if w.clientOpts.Policy == socket.SpillOnOverflow {
+		w.clientOpts.OnSpill = w.onSpill
+	}
+	w.registerEvents()
+	store.OnDisconnect(w.onClientDisconnect)
+	pubsub.Subscribe(w.onRemoteDelivery)
+	go w.runOutbox()
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true //dev purposes only
-	},
+	return w
 }
 
-func (w *wsHandler) EstablishConnection(ctx *gin.Context) {
-	userID := uuid.MustParse(ctx.GetString("userID"))
-	_, err := w.connect(userID, ctx)
-	if err != nil {
-		shared.ErrorResponse(ctx, http.StatusBadRequest, "error establishing connection")
+// newUpgrader accepts requests without an Origin, which don't come from a
+// browser, same origin requests and those from the allowed origins.
+func newUpgrader(allowedOrigins []string) websocket.Upgrader {
+	allowed := map[string]bool{}
+	for _, origin := range allowedOrigins {
+		allowed[strings.ToLower(strings.TrimRight(strings.TrimSpace(origin), "/"))] = true
 	}
-}
 
-func (w *wsHandler) connect(userID uuid.UUID, ctx *gin.Context) (*websocket.Conn, error) {
-	//TOD0: how do I manage connections better (at scale or not???)
-	eConn, err := w.store.GetConn(userID)
-	if err == nil {
-		return eConn, nil
-	}
-	conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
-	if err != nil {
-		log.Println(err)
-		return nil, ErrHandShakeFail
+	return websocket.Upgrader{
+		Subprotocols: []string{socket.Subprotocol},
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" || allowed["*"] || allowed[strings.ToLower(origin)] {
+				return true
+			}
+			u, err := url.Parse(origin)
+			return err == nil && strings.EqualFold(u.Host, r.Host)
+		},
 	}
+}
 
-	w.store.SaveConn(userID, conn)
-
-	return conn, nil
+func (w *wsHandler) on(eventType string, handle eventHandlerFunc) {
+	w.events[eventType] = handle
 }
 
-func (w *wsHandler) HandlePrivateChat(ctx *gin.Context) {
+func (w *wsHandler) Connect(ctx *gin.Context) {
 	userID := uuid.MustParse(ctx.GetString("userID"))
-	conn, err := w.connect(userID, ctx)
+	conn, err := w.upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
-		shared.ErrorResponse(ctx, http.StatusUnprocessableEntity, err.Error())
+		// the upgrader has already written an http error to the client
+		log.Println(ErrHandShakeFail, err)
 		return
 	}
-	defer w.closeConn(conn, userID)
+
+	client := socket.NewClient(userID, deviceID(ctx), conn, w.clientOpts)
+	client.UserAgent = ctx.Request.UserAgent()
+	client.SessionID = ctx.GetString("sessionID")
+	go client.WritePump()
+	client.Send(socket.Event{
+		Type:       socket.EventConnected,
+		StatusCode: http.StatusOK,
+		Payload:    client.Info(),
+	})
+
+	// replay the backlog before the client becomes reachable for live
+	// traffic, then once more for anything persisted in between
+	w.flushUndelivered(client)
+	w.store.SaveClient(client)
+	w.register(client)
+	defer w.closeClient(client)
+	w.flushUndelivered(client)
+	w.publishPresence(userID)
+
+	frames, handled := w.serveFrames(client)
+	defer func() {
+		close(frames)
+		<-handled
+	}()
 
 	for {
-		var message services.PrivateMessageDto
-		if err := conn.ReadJSON(&message); err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+		frame, err := client.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				log.Println("closing connection of", userID, "after an oversized frame")
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
 				log.Printf("WebSocket closed unexpectedly: %v", err)
-				break
 			}
-			log.Println(err)
-			w.handleResponse(conn, http.StatusBadRequest, err, "")
+			break
+		}
+
+		var envelope socket.Envelope
+		if err := json.Unmarshal(frame, &envelope); err != nil || envelope.Type == "" {
+			w.reply(client, socket.Envelope{Type: socket.EventError}, http.StatusBadRequest, ErrInvalidMessageFormat, nil)
+			continue
 		}
 
-		go w.handlerIncomingPrivateMsg(userID, &message, conn)
+		frames <- envelope
 	}
 }
 
-func (w *wsHandler) HandleGroupChat(ctx *gin.Context) {
-	userID := uuid.MustParse(ctx.GetString("userID"))
-	conn, err := w.connect(userID, ctx)
+// serveFrames handles the client's frames one at a time in the order they
+// were read, so a send, an edit of it and the read receipt that follows
+// can't overtake each other. handled is closed once frames is closed and
+// drained.
+func (w *wsHandler) serveFrames(client *socket.Client) (chan<- socket.Envelope, <-chan struct{}) {
+	frames := make(chan socket.Envelope, dispatchQueue)
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		for envelope := range frames {
+			w.dispatch(client, envelope)
+		}
+	}()
+	return frames, handled
+}
+
+func (w *wsHandler) dispatch(client *socket.Client, envelope socket.Envelope) {
+	handle, ok := w.events[envelope.Type]
+	if !ok {
+		w.reply(client, envelope, http.StatusBadRequest, ErrUnknownEvent, nil)
+		return
+	}
+
+	data, err := handle(client, envelope.Payload)
 	if err != nil {
-		shared.ErrorResponse(ctx, http.StatusUnprocessableEntity, err.Error())
+		w.reply(client, envelope, http.StatusBadRequest, err, nil)
 		return
 	}
-	defer w.closeConn(conn, userID)
 
-	for {
-		var message services.GroupMessageDto
-		if err := conn.ReadJSON(&message); err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket closed unexpectedly: %v", err)
-				break
-			}
-			log.Println(err)
-			w.handleResponse(conn, http.StatusBadRequest, err, "")
-		}
+	w.reply(client, envelope, http.StatusOK, nil, data)
+}
 
-		go w.handleGroupMessage(userID, &message, conn)
-	}
+func (w *wsHandler) ListDevices(ctx *gin.Context) {
+	userID := uuid.MustParse(ctx.GetString("userID"))
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, w.store.ListDevices(userID))
 }
 
-func (w *wsHandler) GroupCreationHandler(ctx *gin.Context) {
+func (w *wsHandler) DisconnectDevice(ctx *gin.Context) {
 	userID := uuid.MustParse(ctx.GetString("userID"))
-	conn, err := w.connect(userID, ctx)
-	if err != nil {
-		shared.ErrorResponse(ctx, http.StatusUnprocessableEntity, err.Error())
+	if err := w.store.DisconnectDevice(userID, ctx.Param("device_id")); err != nil {
+		shared.ErrorResponse(ctx, http.StatusNotFound, err.Error())
 		return
 	}
-	defer w.closeConn(conn, userID)
 
-	for {
-		var message services.CreateGroupDto
-		if err := conn.ReadJSON(&message); err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket closed unexpectedly: %v", err)
-				break
-			}
-			w.handleResponse(conn, http.StatusBadRequest, err, "")
-		}
+	shared.SuccessResponse(ctx, http.StatusOK, shared.SUCCESS, nil)
+}
 
-		go w.handleGroupCreation(userID, &message, conn)
-	}
+// transmit fans the event out to every device the user has connected, on
+// this instance or any other, and reports whether it reached at least one
+// of them or was handed to an instance that holds one.
+func (w *wsHandler) transmit(userID uuid.UUID, event socket.Event) bool {
+	return w.transmitExcept(userID, "", event)
 }
 
-func (w *wsHandler) HandleMembership(ctx *gin.Context) {
-	userID := uuid.MustParse(ctx.GetString("userID"))
-	groupID, err := uuid.Parse(ctx.Param("group_id"))
-	if err != nil {
-		shared.ErrorResponse(ctx, http.StatusUnprocessableEntity, "invalid group id")
-		return
+// transmitExcept skips one device, typically the one that originated the
+// event and already gets it as a reply.
+func (w *wsHandler) transmitExcept(userID uuid.UUID, skipDeviceID string, event socket.Event) bool {
+	local := w.sendLocal(userID, skipDeviceID, event)
+	remote := w.publishRemote(broker.Delivery{
+		UserID:       userID,
+		SkipDeviceID: skipDeviceID,
+		Event:        event,
+	})
+	return local || remote
+}
+
+// sendLocal hands the event to the user's devices connected here.
+func (w *wsHandler) sendLocal(userID uuid.UUID, skipDeviceID string, event socket.Event) bool {
+	sent := false
+	for _, client := range w.store.GetClients(userID) {
+		if client.DeviceID == skipDeviceID {
+			continue
+		}
+		if err := client.Send(event); err != nil {
+			log.Println(err)
+			continue
+		}
+		sent = true
 	}
-	conn, err := w.connect(userID, ctx)
+
+	return sent
+}
+
+// publishRemote forwards a delivery to every other instance holding one of
+// the user's connections and reports whether any of them took it.
+func (w *wsHandler) publishRemote(delivery broker.Delivery) bool {
+	conns, err := w.registry.Connections(delivery.UserID)
 	if err != nil {
-		return
+		log.Println("failed to look up connections for", delivery.UserID, err)
+		return false
 	}
-	defer w.closeConn(conn, userID)
 
-	for {
-		var message services.GroupMembershipDto
-		if err := conn.ReadJSON(&message); err != nil {
-			//TOD: check unepected connection closure error
-			log.Println(err)
-			w.handleResponse(conn, http.StatusBadRequest, err, "")
+	published := false
+	for _, nodeID := range broker.Nodes(conns) {
+		if nodeID == w.broker.NodeID() {
+			continue
 		}
-
-		go w.handleGroupMemberShip(userID, groupID, &message, conn)
+		if err := w.broker.Publish(context.Background(), nodeID, delivery); err != nil {
+			log.Println("failed to publish to node", nodeID, err)
+			continue
+		}
+		published = true
 	}
+	return published
 }
 
-func (w *wsHandler) handleGroupMemberShip(userID, groupID uuid.UUID, data *services.GroupMembershipDto, createrConn *websocket.Conn) {
-	memberID, err := uuid.Parse(data.MemberID)
-	if err != nil {
-		log.Println(err)
-		w.handleResponse(createrConn, http.StatusBadRequest, ErrInvalidMemberID, "")
+// onRemoteDelivery handles what another instance published for users
+// connected here.
+func (w *wsHandler) onRemoteDelivery(delivery broker.Delivery) {
+	if delivery.Event.Type == socket.EventSessionRevoked {
+		w.closeSession(delivery.UserID, delivery.Event)
 		return
 	}
-	if err := w.groupService.HandleMembership(groupID, userID, memberID, data.Action); err != nil {
-		log.Println(err)
-		w.handleResponse(createrConn, http.StatusBadRequest, err, "")
+	if !w.sendLocal(delivery.UserID, delivery.SkipDeviceID, delivery.Event) {
 		return
 	}
+	if delivery.MessageID != nil {
+		w.settle(delivery.UserID, *delivery.MessageID)
+	}
+}
 
-	members, err := w.groupService.GetGroupMembers(groupID)
+// deliver transmits a persisted message and settles its delivery record.
+// Instances settle for their own devices, so the record is only marked
+// delivered once a device actually accepted the message. If none does it
+// stays pending and is replayed when the recipient reconnects.
+func (w *wsHandler) deliver(recipientID, messageID uuid.UUID, event socket.Event) {
+	if w.sendLocal(recipientID, "", event) {
+		w.settle(recipientID, messageID)
+	}
+	w.publishRemote(broker.Delivery{
+		UserID:    recipientID,
+		MessageID: &messageID,
+		Event:     event,
+	})
+}
+
+func (w *wsHandler) settle(recipientID, messageID uuid.UUID) {
+	updates, err := w.deliveryService.MarkDelivered(recipientID, messageID)
 	if err != nil {
 		log.Println(err)
-		//group has been created but members could not be fetched for notification, how to best handle???
-		w.handleResponse(createrConn, http.StatusBadRequest, err, "")
 		return
 	}
+	w.pushReceipts(updates)
+}
 
-	for _, member := range members {
-		go w.groupMembershipNotification(member.UserID, data)
+// pushReceipts tells senders how far their messages have progressed.
+func (w *wsHandler) pushReceipts(updates []services.ReceiptUpdate) {
+	for _, update := range updates {
+		w.transmit(update.SenderID, socket.Event{
+			Type:       socket.EventReceipt,
+			StatusCode: http.StatusOK,
+			Payload:    update,
+		})
 	}
 }
 
-func (w *wsHandler) handleGroupCreation(userID uuid.UUID, data *services.CreateGroupDto, createrConn *websocket.Conn) {
-	if err := w.groupService.CreateGroup(userID, *data); err != nil {
-		log.Println(err)
-		w.handleResponse(createrConn, http.StatusBadRequest, err, "")
-	}
+func (w *wsHandler) flushUndelivered(client *socket.Client) {
+	for {
+		pending, err := w.deliveryService.GetUndelivered(client.UserID)
+		if err != nil {
+			log.Println("failed to load undelivered messages for", client.UserID, err)
+			return
+		}
+		if len(pending) == 0 {
+			return
+		}
 
-	for _, memberID := range data.Members {
-		//handle this better - find a way
-		memberUUID, err := uuid.Parse(memberID)
+		var flushed []uuid.UUID
+		for _, p := range pending {
+			event := socket.Event{
+				Type:       messageEventType(p.ConversationType),
+				StatusCode: http.StatusOK,
+				Payload:    p.Message,
+			}
+			if err := client.SendWait(event); err != nil {
+				break
+			}
+			flushed = append(flushed, p.MessageID)
+		}
+
+		updates, err := w.deliveryService.MarkDelivered(client.UserID, flushed...)
 		if err != nil {
-			log.Println("invalid member UUID:", memberID)
-			continue
+			log.Println(err)
+			return
+		}
+		w.pushReceipts(updates)
+		if len(flushed) < len(pending) {
+			return
 		}
-		go w.groupCreationNotification(memberUUID, data)
 	}
 }
 
-func (w *wsHandler) handleGroupMessage(senderID uuid.UUID, data *services.GroupMessageDto, senderConn *websocket.Conn) {
-	groupUUID, err := uuid.Parse(data.GroupID)
-	if err != nil {
-		log.Println(err)
-		w.handleResponse(senderConn, http.StatusBadRequest, err, "")
-		return
-	}
-	if err := w.chatService.SendMsgToGroup(senderID, *data); err != nil {
-		log.Println(err)
-		w.handleResponse(senderConn, http.StatusBadRequest, err, "")
-		return
-	}
-	members, err := w.groupService.GetGroupMembers(groupUUID)
-	if err != nil {
-		log.Println(err)
-		w.handleResponse(senderConn, http.StatusBadRequest, err, "")
+// onSpill runs when a client with the spill policy falls behind. A message
+// it missed stays pending and is replayed once its queue has room again.
+// Anything else it missed the client catches up on through the sync
+// endpoints, which the resync event asks it to do.
+func (w *wsHandler) onSpill(client *socket.Client, event socket.Event) {
+	if _, replaying := w.spilled.LoadOrStore(client, true); replaying {
 		return
 	}
 
-	for _, member := range members {
-		if member.UserID != senderID {
-			go w.groupMessageNotification(member.UserID, data)
+	go func() {
+		err := client.SendWait(socket.Event{Type: socket.EventResync, StatusCode: http.StatusOK})
+		if err == nil {
+			w.flushUndelivered(client)
 		}
-	}
+		w.spilled.Delete(client)
+		// whatever spilled while replaying, before the next spill can
+		// start a replay of its own
+		if err == nil {
+			w.flushUndelivered(client)
+		}
+	}()
 }
 
-func (w *wsHandler) groupMessageNotification(userID uuid.UUID, data *services.GroupMessageDto) {
-	w.transmit(userID, data.Content)
-}
+func (w *wsHandler) reply(client *socket.Client, envelope socket.Envelope, code int, err error, data any) {
+	resp := socket.Event{
+		Type:       envelope.Type,
+		ID:         envelope.ID,
+		StatusCode: code,
+		Payload:    data,
+	}
 
-func (w *wsHandler) groupMembershipNotification(userID uuid.UUID, data *services.GroupMembershipDto) {
-	var action string
-	if data.Action == services.Add {
-		action += "ed"
-	} else {
-		action += "d"
+	if err != nil {
+		log.Println(err.Error())
+		resp.ErrorMessage = err.Error()
 	}
-	msg := data.MemberID + " has been " + action
 
-	w.transmit(userID, msg)
+	if err := client.Send(resp); err != nil {
+		log.Println("error sending resp: ", err)
+	}
 }
 
-func (w *wsHandler) groupCreationNotification(userID uuid.UUID, data *services.CreateGroupDto) {
-	msg := "you have been added to " + data.GroupName
-	w.transmit(userID, msg)
+func (w *wsHandler) closeClient(client *socket.Client) {
+	w.store.RemoveClient(client)
 }
 
-func (w *wsHandler) handlerIncomingPrivateMsg(senderID uuid.UUID, message *services.PrivateMessageDto, senderConn *websocket.Conn) {
-	if err := w.chatService.SendPrivateMsg(senderID, *message); err != nil {
-		w.handleResponse(senderConn, http.StatusBadRequest, err, "")
+// register records the client in the cluster-wide registry. It is called
+// again whenever the client's away flag changes.
+func (w *wsHandler) register(client *socket.Client) {
+	if err := w.registry.Register(w.connection(client)); err != nil {
+		log.Println("failed to register connection for", client.UserID, err)
 	}
-
-	w.transmit(uuid.MustParse(message.ReceiverID), message.Content)
 }
 
-func (w *wsHandler) transmit(userID uuid.UUID, content string) {
-	conn, err := w.store.GetConn(userID)
-	if err != nil {
-		log.Println("receiver is not online")
-		return
+func (w *wsHandler) connection(client *socket.Client) broker.Connection {
+	return broker.Connection{
+		UserID:      client.UserID,
+		DeviceID:    client.DeviceID,
+		NodeID:      w.broker.NodeID(),
+		Away:        client.Away(),
+		ConnectedAt: client.ConnectedAt,
 	}
-
-	w.handleResponse(conn, http.StatusOK, nil, content)
 }
 
-func (w *wsHandler) handleResponse(conn *websocket.Conn, code int, err error, data string) {
-	resp := WSResponse{
-		StatusCode: code,
-		Data:       data,
+// onClientDisconnect lets the user's remaining devices know one of them
+// went away so device lists stay accurate.
+func (w *wsHandler) onClientDisconnect(client *socket.Client) {
+	log.Println("connection closed for user with id", client.UserID, "device", client.DeviceID)
+	if err := w.registry.Unregister(w.connection(client)); err != nil {
+		log.Println("failed to unregister connection for", client.UserID, err)
 	}
+	w.transmit(client.UserID, socket.Event{
+		Type:       socket.EventDisconnected,
+		StatusCode: http.StatusOK,
+		Payload:    client.Info(),
+	})
+	w.publishPresence(client.UserID)
+}
 
-	if err != nil {
-		log.Println(err.Error())
-		resp.ErrorMessage = err.Error()
-	} else {
-		resp.ErrorMessage = ""
+// deviceID identifies the connecting device so several can be online for
+// the same user. Clients should persist the ID they get back in the
+// connected event and send it on reconnect.
+func deviceID(ctx *gin.Context) string {
+	if id := ctx.Query("device_id"); id != "" {
+		return id
 	}
-	if conn == nil {
-		log.Println("error sending resp: ", resp)
-		return
+	if id := ctx.GetHeader("X-Device-ID"); id != "" {
+		return id
 	}
 
-	if err := conn.WriteJSON(&resp); err != nil {
-		log.Println(err)
+	return uuid.NewString()
+}
+
+func messageEventType(conversationType models.ConversationType) string {
+	if conversationType == models.GroupConversation {
+		return socket.EventGroupMessage
 	}
+	return socket.EventPrivateMessage
 }
 
-func (w *wsHandler) closeConn(conn *websocket.Conn, userID uuid.UUID) {
-	if err := conn.Close(); err != nil {
-		log.Println("failed to close connection for user with id", userID)
+func decodePayload(payload json.RawMessage, dest any) error {
+	if err := json.Unmarshal(payload, dest); err != nil {
+		return ErrInvalidMessageFormat
 	}
-	w.store.DeleteConn(userID)
-	log.Println("connection closed for user with id", userID)
+	return nil
 }
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/internal/pkg/socket"
+	"shiplabs/schat/internal/services"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// newTestClient upgrades a loopback connection and wraps the server side of
+// it. The returned conn is the peer's end.
+func newTestClient(t *testing.T) (*socket.Client, *websocket.Conn) {
+	t.Helper()
+	clients := make(chan *socket.Client, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(rw, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		clients <- socket.NewClient(uuid.New(), "device", conn, socket.Options{})
+	}))
+	t.Cleanup(server.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	client := <-clients
+	go client.WritePump()
+	t.Cleanup(client.Close)
+	return client, peer
+}
+
+func TestServeFramesInOrder(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	var mu sync.Mutex
+	var handled []int
+	running, maxRunning := 0, 0
+	w := &wsHandler{events: map[string]eventHandlerFunc{}}
+	w.on("step", func(_ *socket.Client, payload json.RawMessage) (any, error) {
+		var n int
+		if err := json.Unmarshal(payload, &n); err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+
+		// the earliest frames are the slowest, they'd finish last if
+		// frames were handled concurrently
+		time.Sleep(time.Duration(10-n) * time.Millisecond)
+
+		mu.Lock()
+		running--
+		handled = append(handled, n)
+		mu.Unlock()
+		return nil, nil
+	})
+
+	frames, done := w.serveFrames(client)
+	for n := range 10 {
+		frames <- socket.Envelope{Type: "step", Payload: json.RawMessage{byte('0' + n)}}
+	}
+	close(frames)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("frames were not drained")
+	}
+	for i, n := range handled {
+		if n != i {
+			t.Fatalf("handled %v, want frames in the order they were read", handled)
+		}
+	}
+	if len(handled) != 10 {
+		t.Fatalf("handled %d frames, want 10", len(handled))
+	}
+	if maxRunning != 1 {
+		t.Fatalf("%d frames handled at once, want 1", maxRunning)
+	}
+}
+
+func TestServeFramesReplies(t *testing.T) {
+	client, peer := newTestClient(t)
+
+	w := &wsHandler{events: map[string]eventHandlerFunc{}}
+	w.on("echo", func(_ *socket.Client, payload json.RawMessage) (any, error) {
+		return payload, nil
+	})
+
+	frames, done := w.serveFrames(client)
+	frames <- socket.Envelope{Type: "echo", ID: "1", Payload: json.RawMessage(`"hi"`)}
+	frames <- socket.Envelope{Type: "nope", ID: "2"}
+	close(frames)
+	<-done
+
+	tests := []struct {
+		id     string
+		status int
+		errMsg string
+	}{
+		{"1", http.StatusOK, ""},
+		{"2", http.StatusBadRequest, ErrUnknownEvent.Error()},
+	}
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, tt := range tests {
+		var event socket.Event
+		if err := peer.ReadJSON(&event); err != nil {
+			t.Fatal(err)
+		}
+		if event.ID != tt.id || event.StatusCode != tt.status || event.ErrorMessage != tt.errMsg {
+			t.Fatalf("reply = %+v, want id %s status %d error %q", event, tt.id, tt.status, tt.errMsg)
+		}
+	}
+}
+
+// pendingQueue hands out the batches in order and records what was marked
+// delivered.
+type pendingQueue struct {
+	services.DeliveryServiceInterface
+	batches   [][]services.PendingMessage
+	delivered []uuid.UUID
+}
+
+func (q *pendingQueue) GetUndelivered(uuid.UUID) ([]services.PendingMessage, error) {
+	if len(q.batches) == 0 {
+		return nil, nil
+	}
+	batch := q.batches[0]
+	q.batches = q.batches[1:]
+	return batch, nil
+}
+
+func (q *pendingQueue) MarkDelivered(_ uuid.UUID, messageIDs ...uuid.UUID) ([]services.ReceiptUpdate, error) {
+	q.delivered = append(q.delivered, messageIDs...)
+	return nil, nil
+}
+
+func TestFlushUndelivered(t *testing.T) {
+	client, peer := newTestClient(t)
+	pending := func(conversationType models.ConversationType) services.PendingMessage {
+		id := uuid.New()
+		return services.PendingMessage{MessageID: id, ConversationType: conversationType, Message: map[string]string{"id": id.String()}}
+	}
+	first := []services.PendingMessage{pending(models.PrivateConversation), pending(models.GroupConversation)}
+	second := []services.PendingMessage{pending(models.PrivateConversation)}
+	queue := &pendingQueue{batches: [][]services.PendingMessage{first, second}}
+	w := &wsHandler{deliveryService: queue}
+
+	w.flushUndelivered(client)
+
+	want := append(first, second...)
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, p := range want {
+		var event struct {
+			Type    string            `json:"type"`
+			Payload map[string]string `json:"payload"`
+		}
+		if err := peer.ReadJSON(&event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != messageEventType(p.ConversationType) || event.Payload["id"] != p.MessageID.String() {
+			t.Fatalf("replayed %s %s, want %s %s", event.Type, event.Payload["id"], messageEventType(p.ConversationType), p.MessageID)
+		}
+	}
+	if len(queue.delivered) != len(want) {
+		t.Fatalf("%d messages marked delivered, want %d", len(queue.delivered), len(want))
+	}
+	for i, p := range want {
+		if queue.delivered[i] != p.MessageID {
+			t.Fatalf("marked %v delivered, want the replayed messages in order", queue.delivered)
+		}
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	upgrader := newUpgrader([]string{" https://App.example.com/ ", "http://localhost:5173"})
+
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{"not a browser", "", true},
+		{"same origin", "https://chat.example.com", true},
+		{"allowed", "https://app.example.com", true},
+		{"allowed dev server", "http://localhost:5173", true},
+		{"other port", "http://localhost:3000", false},
+		{"other site", "https://evil.example.net", false},
+		{"lookalike", "https://app.example.com.evil.net", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "https://chat.example.com/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := upgrader.CheckOrigin(r); got != tt.want {
+				t.Fatalf("CheckOrigin(%q) = %t, want %t", tt.origin, got, tt.want)
+			}
+		})
+	}
+
+	if !newUpgrader([]string{"*"}).CheckOrigin(&http.Request{Host: "chat.example.com", Header: http.Header{"Origin": {"https://anywhere.net"}}}) {
+		t.Fatal("wildcard origin refused")
+	}
+}
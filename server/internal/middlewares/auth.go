@@ -3,14 +3,16 @@ package middlewares
 import (
 	"errors"
 	"net/http"
-	"shiplabs/schat/internal/pkg/config"
 	"shiplabs/schat/internal/pkg/db"
+	"shiplabs/schat/internal/pkg/keyring"
+	"shiplabs/schat/internal/pkg/mailer"
+	"shiplabs/schat/internal/pkg/socket"
 	repos "shiplabs/schat/internal/repositories"
+	"shiplabs/schat/internal/services"
 	"shiplabs/schat/pkg/shared"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -18,8 +20,22 @@ const (
 	ErrCredentialsRequired = "credentials required"
 )
 
-// var userRepo = repos.NewUserRepo(*db.DB) find how to instantiate the user repo and have it available
+// authService is built per request until middlewares get wired through base
+func authService() services.AuthServiceInterface {
+	return services.NewAuthService(
+		repos.NewUserRepo(*db.DB),
+		repos.NewSessionRepo(*db.DB),
+		repos.NewOutboxRepo(*db.DB),
+		repos.NewSigningKeyRepo(*db.DB),
+		repos.NewUserTokenRepo(*db.DB),
+		repos.NewTwoFactorRepo(*db.DB),
+		keyring.Active,
+		mailer.Mail,
+	)
+}
 
+// Auth accepts access tokens of live sessions only, tokens of a session
+// that logged out or was revoked stop working before they expire.
 func Auth(ctx *gin.Context) {
 	authT := ctx.GetHeader("Authorization")
 	if authT == "" {
@@ -35,20 +51,37 @@ func Auth(ctx *gin.Context) {
 		return
 	}
 
-	claims := jwt.RegisteredClaims{}
-	jwt.ParseWithClaims(jwtToken, &claims, func(token *jwt.Token) (any, error) {
-		return []byte(config.Configs.APP_SECRET), nil
-	})
+	userID, sessionID, err := authService().Authenticate(jwtToken)
+	grant(ctx, userID, sessionID, err)
+}
+
+// SocketAuth guards the websocket upgrade. Browsers can't set headers on
+// it, so a single use connect ticket in the ticket query parameter or an
+// access token offered as a subprotocol work as well as Authorization.
+func SocketAuth(ctx *gin.Context) {
+	if ticket := ctx.Query("ticket"); ticket != "" {
+		userID, sessionID, err := authService().RedeemConnectTicket(ticket)
+		grant(ctx, userID, sessionID, err)
+		return
+	}
+	if token := socket.ProtocolToken(ctx.Request); token != "" {
+		userID, sessionID, err := authService().Authenticate(token)
+		grant(ctx, userID, sessionID, err)
+		return
+	}
+
+	Auth(ctx)
+}
 
-	userId := uuid.MustParse(claims.Subject)
-	user, err := repos.NewUserRepo(*db.DB).FindByID(userId)
+func grant(ctx *gin.Context, userID, sessionID uuid.UUID, err error) {
 	if err != nil {
 		shared.ErrorResponse(ctx, http.StatusUnauthorized, err.Error())
 		ctx.Abort()
 		return
 	}
 
-	ctx.Set("userID", user.ID.String())
+	ctx.Set("userID", userID.String())
+	ctx.Set("sessionID", sessionID.String())
 	ctx.Next()
 }
 
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Attachment is an uploaded media file. Messages of type IMAGE, VIDEO and
+// AUDIO point at one instead of carrying the media in Content. Dimensions
+// and duration are only set when they could be read from the file.
+type Attachment struct {
+	gorm.Model      `json:"-"`
+	ID              uuid.UUID    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OwnerID         uuid.UUID    `gorm:"not null;type:uuid;index" json:"owner_id"`
+	Owner           User         `gorm:"foreignKey:owner_id" json:"-"`
+	Type            ValidMsgType `gorm:"not null" json:"type"`
+	MimeType        string       `gorm:"not null" json:"mime_type"`
+	Size            int64        `gorm:"not null" json:"size"`
+	FileName        string       `json:"file_name"`
+	Width           int          `json:"width,omitempty"`
+	Height          int          `json:"height,omitempty"`
+	DurationMs      int64        `json:"duration_ms,omitempty"`
+	StorageKey      string       `gorm:"not null" json:"-"`
+	ThumbnailKey    string       `json:"-"`
+	ThumbnailWidth  int          `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int          `json:"thumbnail_height,omitempty"`
+	CreatedAt       time.Time    `gorm:"not null" json:"created_at"`
+	UpdatedAt       time.Time    `gorm:"not null" json:"updated_at"`
+}
+
+func (a Attachment) HasThumbnail() bool {
+	return a.ThumbnailKey != ""
+}
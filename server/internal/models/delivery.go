@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ConversationType string
+
+const (
+	PrivateConversation ConversationType = "private"
+	GroupConversation   ConversationType = "group"
+)
+
+type ReceiptStatus string
+
+const (
+	Sent      ReceiptStatus = "sent"
+	Delivered ReceiptStatus = "delivered"
+	Read      ReceiptStatus = "read"
+)
+
+// MessageDelivery is the receipt of one message for one recipient. It stays
+// pending until the message has been handed to one of their devices and
+// records when the recipient acknowledged delivery and read it.
+type MessageDelivery struct {
+	gorm.Model       `json:"-"`
+	ID               uuid.UUID        `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	MessageID        uuid.UUID        `gorm:"not null;type:uuid;uniqueIndex:idx_delivery_message_recipient" json:"message_id"`
+	RecipientID      uuid.UUID        `gorm:"not null;type:uuid;uniqueIndex:idx_delivery_message_recipient;index" json:"recipient_id"`
+	Recipient        User             `gorm:"foreignKey:recipient_id" json:"-"`
+	ConversationType ConversationType `gorm:"not null" json:"conversation_type"`
+	ConversationID   uuid.UUID        `gorm:"not null;type:uuid;index" json:"conversation_id"`
+	SenderID         uuid.UUID        `gorm:"not null;type:uuid;index" json:"sender_id"`
+	DeliveredAt      *time.Time       `json:"delivered_at"`
+	ReadAt           *time.Time       `json:"read_at"`
+	CreatedAt        time.Time        `gorm:"not null" json:"created_at"`
+	UpdatedAt        time.Time        `gorm:"not null" json:"updated_at"`
+}
+
+func (d MessageDelivery) Status() ReceiptStatus {
+	switch {
+	case d.ReadAt != nil:
+		return Read
+	case d.DeliveredAt != nil:
+		return Delivered
+	default:
+		return Sent
+	}
+}
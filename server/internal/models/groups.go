@@ -21,6 +21,7 @@ type Group struct {
 	CreatorID   uuid.UUID `gorm:"not null" json:"creator_id"`
 	Creator     User      `gorm:"foreignKey:creator_id" json:"-"`
 	Description *string   `json:"description"`
+	LastSeq     int64     `gorm:"not null;default:0" json:"last_seq"`
 	CreatedAt   time.Time `gorm:"not null" json:"created_at"`
 	UpdatedAt   time.Time `gorm:"not null" json:"updated_at"`
 }
@@ -32,6 +33,7 @@ type GroupMember struct {
 	GroupID    uuid.UUID `gorm:"primaryKey;type:uuid" json:"group_id"`
 	Group      Group     `gorm:"foreignKey:group_id" json:"-"`
 	Role       GroupRole `gorm:"not null;default:member" json:"role"`
+	Muted      bool      `gorm:"not null;default:false" json:"muted"`
 	CreatedAt  time.Time `gorm:"not null" json:"created_at"`
 	UpdatedAt  time.Time `gorm:"not null" json:"updated_at"`
 }
@@ -17,24 +17,82 @@ const (
 )
 
 type BaseMessage struct {
-	gorm.Model `json:"-"`
-	ID         uuid.UUID    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
-	SenderID   uuid.UUID    `gorm:"not null;index" json:"sender_id"`
-	Sender     User         `gorm:"foreignKey:sender_id" json:"-"`
-	Type       ValidMsgType `gorm:"not null" json:"type"`
-	Content    string       `gorm:"not null" json:"content"`
-	CreatedAt  time.Time    `gorm:"not null" json:"created_at"`
-	UpdatedAt  time.Time    `gorm:"not null" json:"updated_at"`
+	gorm.Model      `json:"-"`
+	ID              uuid.UUID       `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	SenderID        uuid.UUID       `gorm:"not null;index;uniqueIndex:,composite:sender_client_message,priority:1" json:"sender_id"`
+	Sender          User            `gorm:"foreignKey:sender_id" json:"-"`
+	ClientMessageID *string         `gorm:"size:64;uniqueIndex:,composite:sender_client_message,priority:2" json:"client_message_id,omitempty"`
+	Type            ValidMsgType    `gorm:"not null" json:"type"`
+	Content         string          `gorm:"not null" json:"content"`
+	AttachmentID    *uuid.UUID      `gorm:"type:uuid" json:"attachment_id"`
+	Attachment      *Attachment     `gorm:"foreignKey:attachment_id" json:"attachment,omitempty"`
+	ReplyToID       *uuid.UUID      `gorm:"type:uuid;index" json:"reply_to_id"`
+	ReplyTo         *MessagePreview `gorm:"-" json:"reply_to,omitempty"`
+	Reactions       []ReactionCount `gorm:"-" json:"reactions,omitempty"`
+	EditedAt        *time.Time      `json:"edited_at"`
+	DeletedForAllAt *time.Time      `json:"deleted_for_all_at"`
+	CreatedAt       time.Time       `gorm:"not null" json:"created_at"`
+	UpdatedAt       time.Time       `gorm:"not null" json:"updated_at"`
 }
 
+// PrivateMessage Seq numbers the chat's messages from 1 without gaps, so a
+// client missing one can tell and sync from the last it has.
 type PrivateMessage struct {
 	BaseMessage
 	ChatID uuid.UUID   `gorm:"not null;index" json:"chat_id"`
 	Chat   PrivateChat `gorm:"foreignKey:chat_id" json:"-"`
+	Seq    int64       `gorm:"not null;default:0" json:"seq"`
 }
 
+// GroupMessage replies posted to a thread carry the root message ID. The
+// root keeps a running count of its thread replies. Seq is shared by the
+// group and its threads.
 type GroupMessage struct {
 	BaseMessage
-	GroupID uuid.UUID `gorm:"not null;index" json:"group_id"`
-	Group   Group     `gorm:"foreignKey:group_id" json:"-"`
+	GroupID      uuid.UUID  `gorm:"not null;index" json:"group_id"`
+	Group        Group      `gorm:"foreignKey:group_id" json:"-"`
+	Seq          int64      `gorm:"not null;default:0" json:"seq"`
+	ThreadRootID *uuid.UUID `gorm:"type:uuid;index" json:"thread_root_id"`
+	ReplyCount   int        `gorm:"not null;default:0" json:"reply_count"`
+}
+
+// MessagePreview is the quoted excerpt of the message being replied to.
+type MessagePreview struct {
+	ID         uuid.UUID    `json:"id"`
+	SenderID   uuid.UUID    `json:"sender_id"`
+	Type       ValidMsgType `json:"type"`
+	Content    string       `json:"content"`
+	Attachment *Attachment  `json:"attachment,omitempty"`
+}
+
+func (m BaseMessage) Preview() *MessagePreview {
+	return &MessagePreview{
+		ID:         m.ID,
+		SenderID:   m.SenderID,
+		Type:       m.Type,
+		Content:    m.Content,
+		Attachment: m.Attachment,
+	}
+}
+
+// MessageEdit keeps the content a message had before an edit.
+type MessageEdit struct {
+	gorm.Model       `json:"-"`
+	ID               uuid.UUID        `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	MessageID        uuid.UUID        `gorm:"not null;type:uuid;index" json:"message_id"`
+	ConversationType ConversationType `gorm:"not null" json:"conversation_type"`
+	PreviousContent  string           `gorm:"not null" json:"previous_content"`
+	CreatedAt        time.Time        `gorm:"not null" json:"created_at"`
+	UpdatedAt        time.Time        `gorm:"not null" json:"updated_at"`
+}
+
+// HiddenMessage is a message a user deleted for themselves only. The
+// unique index keeps hiding it twice from adding a second row.
+type HiddenMessage struct {
+	gorm.Model `json:"-"`
+	MessageID  uuid.UUID `gorm:"primaryKey;type:uuid;uniqueIndex:idx_hidden_message_user" json:"message_id"`
+	UserID     uuid.UUID `gorm:"primaryKey;type:uuid;uniqueIndex:idx_hidden_message_user" json:"user_id"`
+	User       User      `gorm:"foreignKey:user_id" json:"-"`
+	CreatedAt  time.Time `gorm:"not null" json:"created_at"`
+	UpdatedAt  time.Time `gorm:"not null" json:"updated_at"`
 }
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OutboxEvent is a realtime notification written in the same transaction
+// as the change it announces, so nothing is pushed for data that never
+// committed. Kind is the event type clients receive. MessageID is set for
+// new messages so delivery receipts can be settled as recipients get them.
+type OutboxEvent struct {
+	gorm.Model   `json:"-"`
+	ID           uuid.UUID       `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Kind         string          `gorm:"not null" json:"kind"`
+	Payload      json.RawMessage `gorm:"type:jsonb;not null" json:"payload"`
+	Recipients   json.RawMessage `gorm:"type:jsonb;not null" json:"recipients"`
+	MessageID    *uuid.UUID      `gorm:"type:uuid" json:"message_id"`
+	Attempts     int             `gorm:"not null;default:0" json:"attempts"`
+	LastError    string          `json:"last_error"`
+	AvailableAt  time.Time       `gorm:"not null;index" json:"available_at"`
+	DispatchedAt *time.Time      `gorm:"index" json:"dispatched_at"`
+	CreatedAt    time.Time       `gorm:"not null" json:"created_at"`
+	UpdatedAt    time.Time       `gorm:"not null" json:"updated_at"`
+}
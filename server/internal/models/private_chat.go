@@ -14,6 +14,7 @@ type PrivateChat struct {
 	FirstMember    User      `gorm:"foreignKey:first_member_id" json:"-"`
 	SecondMemberID uuid.UUID `gorm:"not null;index" json:"second_member_id"`
 	SecondMember   User      `gorm:"foreignKey:second_member_id" json:"-"`
+	LastSeq        int64     `gorm:"not null;default:0" json:"last_seq"`
 	CreatedAt      time.Time `gorm:"not null" json:"created_at"`
 	UpdatedAt      time.Time `gorm:"not null" json:"updated_at"`
 }
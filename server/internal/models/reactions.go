@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MessageReaction the embedded gorm.Model makes its ID part of the primary
+// key, the unique index is what keeps a user from reacting twice with the
+// same emoji.
+type MessageReaction struct {
+	gorm.Model       `json:"-"`
+	MessageID        uuid.UUID        `gorm:"primaryKey;type:uuid;uniqueIndex:idx_reaction_message_user_emoji" json:"message_id"`
+	UserID           uuid.UUID        `gorm:"primaryKey;type:uuid;uniqueIndex:idx_reaction_message_user_emoji" json:"user_id"`
+	User             User             `gorm:"foreignKey:user_id" json:"-"`
+	Emoji            string           `gorm:"primaryKey;uniqueIndex:idx_reaction_message_user_emoji" json:"emoji"`
+	ConversationType ConversationType `gorm:"not null" json:"conversation_type"`
+	ConversationID   uuid.UUID        `gorm:"not null;type:uuid;index" json:"conversation_id"`
+	CreatedAt        time.Time        `gorm:"not null" json:"created_at"`
+	UpdatedAt        time.Time        `gorm:"not null" json:"updated_at"`
+}
+
+// ReactionCount aggregates one emoji on a message. Reacted tells whether
+// the user reading it is among the reactors.
+type ReactionCount struct {
+	Emoji   string `json:"emoji"`
+	Count   int64  `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Session is one login of a user. Access tokens name the session they were
+// issued for, so revoking it locks them out before they expire.
+type Session struct {
+	gorm.Model   `json:"-"`
+	ID           uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID       uuid.UUID  `gorm:"not null;index" json:"user_id"`
+	User         User       `gorm:"foreignKey:user_id" json:"-"`
+	ExpiresAt    time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	RevokeReason string     `json:"revoke_reason,omitempty"`
+	CreatedAt    time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+// RefreshToken only the hash of the token is stored. Every refresh rotates
+// it, rotated tokens are kept so presenting one again can be caught.
+type RefreshToken struct {
+	gorm.Model `json:"-"`
+	ID         uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	SessionID  uuid.UUID  `gorm:"not null;index" json:"session_id"`
+	Session    Session    `gorm:"foreignKey:session_id" json:"-"`
+	TokenHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	RotatedAt  *time.Time `json:"rotated_at"`
+	CreatedAt  time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+// ConnectTicket lets a browser open a websocket, which can't carry an
+// Authorization header. A ticket is short lived and works once.
+type ConnectTicket struct {
+	gorm.Model `json:"-"`
+	ID         uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	SessionID  uuid.UUID  `gorm:"not null;index" json:"session_id"`
+	Session    Session    `gorm:"foreignKey:session_id" json:"-"`
+	UserID     uuid.UUID  `gorm:"not null" json:"user_id"`
+	TokenHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt  time.Time  `gorm:"not null;index" json:"expires_at"`
+	UsedAt     *time.Time `json:"used_at"`
+	CreatedAt  time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"not null" json:"updated_at"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SigningKey is the public half of a key tokens are signed with, shared so
+// every instance can verify what any other issued. A key is retired once a
+// newer one is in use and dropped from verification after a grace period.
+type SigningKey struct {
+	gorm.Model `json:"-"`
+	ID         uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	KeyID      string     `gorm:"not null;uniqueIndex" json:"kid"`
+	Algorithm  string     `gorm:"not null" json:"alg"`
+	PublicKey  string     `gorm:"not null" json:"-"`
+	RetiredAt  *time.Time `json:"retired_at"`
+	CreatedAt  time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"not null" json:"updated_at"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TwoFactor is a user's TOTP enrollment, in effect once confirmed. Secret
+// is encrypted, LastStep is the last time step a code was accepted for so
+// a code can't be replayed. FailedAttempts counts wrong codes since the
+// last right one across logins, LockedUntil stops guessing for a while.
+type TwoFactor struct {
+	gorm.Model     `json:"-"`
+	ID             uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID         uuid.UUID  `gorm:"not null;uniqueIndex" json:"user_id"`
+	User           User       `gorm:"foreignKey:user_id" json:"-"`
+	Secret         string     `gorm:"not null" json:"-"`
+	ConfirmedAt    *time.Time `json:"confirmed_at"`
+	LastStep       int64      `gorm:"not null;default:0" json:"-"`
+	FailedAttempts int        `gorm:"not null;default:0" json:"-"`
+	LockedUntil    *time.Time `json:"-"`
+	CreatedAt      time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+// RecoveryCode stands in for a TOTP code once, when the authenticator is
+// lost. Only its hash is stored.
+type RecoveryCode struct {
+	gorm.Model `json:"-"`
+	ID         uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID     uuid.UUID  `gorm:"not null;index" json:"user_id"`
+	User       User       `gorm:"foreignKey:user_id" json:"-"`
+	CodeHash   string     `gorm:"not null;uniqueIndex" json:"-"`
+	UsedAt     *time.Time `json:"used_at"`
+	CreatedAt  time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"not null" json:"updated_at"`
+}
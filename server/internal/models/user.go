@@ -8,11 +8,40 @@ import (
 )
 
 type User struct {
+	gorm.Model      `json:"-"`
+	ID              uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Name            string     `gorm:"not null" json:"name"`
+	Email           string     `gorm:"not null;uniqueIndex" json:"email"`
+	Password        string     `gorm:"not null" json:"-"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	LastSeenAt      *time.Time `json:"last_seen_at"`
+	CreatedAt       time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt       time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+type TokenPurpose string
+
+const (
+	VerifyEmail    TokenPurpose = "verify_email"
+	ResetPassword  TokenPurpose = "reset_password"
+	LoginChallenge TokenPurpose = "login_challenge"
+)
+
+// UserToken is a single use token, emailed or handed out as a login
+// challenge, only its hash is stored. Email is the address it was issued
+// for, a verification only counts for it. Attempts counts the codes tried
+// against a challenge.
+type UserToken struct {
 	gorm.Model `json:"-"`
-	ID         uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
-	Name       string    `gorm:"not null" json:"name"`
-	Email      string    `gorm:"not null;uniqueIndex" json:"email"`
-	Password   string    `gorm:"not null" json:"password"`
-	CreatedAt  time.Time `gorm:"not null" json:"created_at"`
-	UpdatedAt  time.Time `gorm:"not null" json:"updated_at"`
+	ID         uuid.UUID    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID     uuid.UUID    `gorm:"not null;index" json:"user_id"`
+	User       User         `gorm:"foreignKey:user_id" json:"-"`
+	Purpose    TokenPurpose `gorm:"not null" json:"purpose"`
+	Email      string       `gorm:"not null" json:"email"`
+	TokenHash  string       `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt  time.Time    `gorm:"not null" json:"expires_at"`
+	Attempts   int          `gorm:"not null;default:0" json:"-"`
+	UsedAt     *time.Time   `json:"used_at"`
+	CreatedAt  time.Time    `gorm:"not null" json:"created_at"`
+	UpdatedAt  time.Time    `gorm:"not null" json:"updated_at"`
 }
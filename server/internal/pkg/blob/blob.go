@@ -0,0 +1,51 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"shiplabs/schat/internal/pkg/config"
+)
+
+var Store Storage
+
+var (
+	ErrObjectNotFound = errors.New("object not found")
+	ErrInvalidKey     = errors.New("invalid object key")
+)
+
+type Info struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Storage is where attachment bytes live. Objects are returned as
+// ReadSeekers so they can be served with HTTP range requests.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, Info, error)
+	Delete(ctx context.Context, key string) error
+}
+
+func InitStorage() {
+	storageConfig := config.Configs.Storage
+
+	var err error
+	switch storageConfig.Driver {
+	case "s3":
+		Store, err = NewS3Storage(storageConfig)
+	case "local", "":
+		Store, err = NewLocalStorage(storageConfig.LocalDir)
+	default:
+		err = fmt.Errorf("unknown storage driver %q", storageConfig.Driver)
+	}
+
+	if err != nil {
+		fmt.Println("Error initialising blob storage: ", err)
+		panic(err)
+	}
+}
@@ -0,0 +1,124 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"shiplabs/schat/internal/pkg/config"
+	"shiplabs/schat/internal/pkg/socket"
+
+	"github.com/google/uuid"
+)
+
+var (
+	PubSub   Broker
+	Presence Registry
+)
+
+var ErrUnknownNode = errors.New("node is not reachable through this broker")
+
+// Delivery is an event addressed to one user's devices on a given node.
+// When MessageID is set the receiving node settles the delivery receipt
+// once one of its devices accepted the event.
+type Delivery struct {
+	UserID       uuid.UUID    `json:"user_id"`
+	SkipDeviceID string       `json:"skip_device_id,omitempty"`
+	MessageID    *uuid.UUID   `json:"message_id,omitempty"`
+	Event        socket.Event `json:"event"`
+}
+
+type Handler func(delivery Delivery)
+
+// Broker carries deliveries between schat instances. Each instance only
+// receives what is published to its own node ID; the registry tells the
+// sender which nodes hold a user's connections.
+type Broker interface {
+	NodeID() string
+	Publish(ctx context.Context, nodeID string, delivery Delivery) error
+	Subscribe(handler Handler)
+	Close() error
+}
+
+// Connection is one live device as recorded in the cluster-wide registry.
+type Connection struct {
+	UserID      uuid.UUID `json:"user_id"`
+	DeviceID    string    `json:"device_id"`
+	NodeID      string    `json:"node_id"`
+	Away        bool      `json:"away"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// Registry records which node holds which user's connections. Entries of
+// nodes that stop heartbeating are ignored, so a crashed instance does not
+// keep its users online forever.
+type Registry interface {
+	Register(conn Connection) error
+	// Unregister removes the entry only if it still belongs to conn, so a
+	// device that already reconnected elsewhere is left alone.
+	Unregister(conn Connection) error
+	Connections(userID uuid.UUID) ([]Connection, error)
+	Heartbeat(ctx context.Context) error
+}
+
+// Nodes lists the distinct nodes among the connections.
+func Nodes(conns []Connection) []string {
+	seen := map[string]bool{}
+	var nodes []string
+	for _, conn := range conns {
+		if !seen[conn.NodeID] {
+			seen[conn.NodeID] = true
+			nodes = append(nodes, conn.NodeID)
+		}
+	}
+	return nodes
+}
+
+func InitBroker() {
+	brokerConfig := config.Configs.Broker
+
+	nodeID := brokerConfig.NodeID
+	if nodeID == "" {
+		host, _ := os.Hostname()
+		nodeID = fmt.Sprintf("%s-%s", host, uuid.NewString()[:8])
+	}
+
+	switch brokerConfig.Driver {
+	case "redis":
+		client, err := newRedisClient(brokerConfig)
+		if err != nil {
+			fmt.Println("Error connecting to redis: ", err)
+			panic(err)
+		}
+		PubSub = NewRedisBroker(client, nodeID)
+		Presence = NewRedisRegistry(client, nodeID, brokerConfig.NodeTTL)
+	case "memory", "":
+		PubSub = NewMemoryBroker(nodeID)
+		Presence = NewMemoryRegistry()
+	default:
+		err := fmt.Errorf("unknown broker driver %q", brokerConfig.Driver)
+		fmt.Println("Error initialising broker: ", err)
+		panic(err)
+	}
+
+	go keepAlive(brokerConfig.NodeTTL / 3)
+}
+
+// keepAlive refreshes this node's liveness in the registry.
+func keepAlive(interval time.Duration) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := Presence.Heartbeat(ctx); err != nil {
+			fmt.Println("Error refreshing node heartbeat: ", err)
+		}
+		cancel()
+	}
+}
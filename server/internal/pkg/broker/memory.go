@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// memoryBroker serves a single instance: everything published to its own
+// node is handed straight to the subscriber.
+type memoryBroker struct {
+	nodeID  string
+	mu      sync.RWMutex
+	handler Handler
+}
+
+func NewMemoryBroker(nodeID string) Broker {
+	return &memoryBroker{nodeID: nodeID}
+}
+
+func (m *memoryBroker) NodeID() string {
+	return m.nodeID
+}
+
+func (m *memoryBroker) Publish(_ context.Context, nodeID string, delivery Delivery) error {
+	if nodeID != m.nodeID {
+		return ErrUnknownNode
+	}
+
+	m.mu.RLock()
+	handler := m.handler
+	m.mu.RUnlock()
+	if handler != nil {
+		go handler(delivery)
+	}
+	return nil
+}
+
+func (m *memoryBroker) Subscribe(handler Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handler = handler
+}
+
+func (m *memoryBroker) Close() error {
+	return nil
+}
+
+type memoryRegistry struct {
+	mu    sync.RWMutex
+	conns map[uuid.UUID]map[string]Connection
+}
+
+func NewMemoryRegistry() Registry {
+	return &memoryRegistry{
+		conns: map[uuid.UUID]map[string]Connection{},
+	}
+}
+
+func (m *memoryRegistry) Register(conn Connection) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	devices, ok := m.conns[conn.UserID]
+	if !ok {
+		devices = map[string]Connection{}
+		m.conns[conn.UserID] = devices
+	}
+	devices[conn.DeviceID] = conn
+	return nil
+}
+
+func (m *memoryRegistry) Unregister(conn Connection) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	devices := m.conns[conn.UserID]
+	current, ok := devices[conn.DeviceID]
+	if ok && current.NodeID == conn.NodeID && current.ConnectedAt.Equal(conn.ConnectedAt) {
+		delete(devices, conn.DeviceID)
+	}
+	if len(devices) == 0 {
+		delete(m.conns, conn.UserID)
+	}
+	return nil
+}
+
+func (m *memoryRegistry) Connections(userID uuid.UUID) ([]Connection, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	conns := make([]Connection, 0, len(m.conns[userID]))
+	for _, conn := range m.conns[userID] {
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+func (m *memoryRegistry) Heartbeat(context.Context) error {
+	return nil
+}
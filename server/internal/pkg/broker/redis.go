@@ -0,0 +1,195 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"shiplabs/schat/internal/pkg/config"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const keyPrefix = "schat:"
+
+func nodeChannel(nodeID string) string {
+	return keyPrefix + "node:" + nodeID + ":deliveries"
+}
+
+func nodeAliveKey(nodeID string) string {
+	return keyPrefix + "node:" + nodeID + ":alive"
+}
+
+func presenceKey(userID uuid.UUID) string {
+	return keyPrefix + "presence:" + userID.String()
+}
+
+func newRedisClient(cfg config.Broker) (*redis.Client, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+type redisBroker struct {
+	client *redis.Client
+	nodeID string
+	pubsub *redis.PubSub
+}
+
+func NewRedisBroker(client *redis.Client, nodeID string) Broker {
+	return &redisBroker{
+		client: client,
+		nodeID: nodeID,
+	}
+}
+
+func (r *redisBroker) NodeID() string {
+	return r.nodeID
+}
+
+func (r *redisBroker) Publish(ctx context.Context, nodeID string, delivery Delivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, nodeChannel(nodeID), data).Err()
+}
+
+// Subscribe listens on this node's channel until the broker is closed.
+// go-redis reconnects and resubscribes by itself after network errors.
+func (r *redisBroker) Subscribe(handler Handler) {
+	r.pubsub = r.client.Subscribe(context.Background(), nodeChannel(r.nodeID))
+
+	go func() {
+		for msg := range r.pubsub.Channel() {
+			var delivery Delivery
+			// keep the payload as raw JSON so it reaches clients untouched
+			payload := json.RawMessage{}
+			delivery.Event.Payload = &payload
+			if err := json.Unmarshal([]byte(msg.Payload), &delivery); err != nil {
+				log.Println("dropping malformed delivery: ", err)
+				continue
+			}
+			handler(delivery)
+		}
+	}()
+}
+
+func (r *redisBroker) Close() error {
+	if r.pubsub != nil {
+		return r.pubsub.Close()
+	}
+	return nil
+}
+
+// redisRegistry keeps a hash per user mapping device ID to its connection.
+// Node liveness is a separate key with a TTL refreshed by Heartbeat.
+type redisRegistry struct {
+	client *redis.Client
+	nodeID string
+	ttl    time.Duration
+}
+
+func NewRedisRegistry(client *redis.Client, nodeID string, ttl time.Duration) Registry {
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	return &redisRegistry{
+		client: client,
+		nodeID: nodeID,
+		ttl:    ttl,
+	}
+}
+
+func (r *redisRegistry) Register(conn Connection) error {
+	data, err := json.Marshal(conn)
+	if err != nil {
+		return err
+	}
+	return r.client.HSet(context.Background(), presenceKey(conn.UserID), conn.DeviceID, data).Err()
+}
+
+// unregisterScript deletes the device entry only when it still points at
+// the same node and connection, atomically. A device that reconnected in the
+// meantime keeps its new entry.
+var unregisterScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], ARGV[1])
+if not current then
+	return 0
+end
+local conn = cjson.decode(current)
+if conn.node_id == ARGV[2] and conn.connected_at == ARGV[3] then
+	return redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// Unregister compares connected_at as it was JSON encoded by Register.
+func (r *redisRegistry) Unregister(conn Connection) error {
+	return r.remove(context.Background(), conn)
+}
+
+func (r *redisRegistry) remove(ctx context.Context, conn Connection) error {
+	return unregisterScript.Run(
+		ctx, r.client,
+		[]string{presenceKey(conn.UserID)},
+		conn.DeviceID, conn.NodeID, conn.ConnectedAt.Format(time.RFC3339Nano),
+	).Err()
+}
+
+// Connections returns the user's devices on live nodes. Entries left
+// behind by dead nodes are pruned on the way.
+func (r *redisRegistry) Connections(userID uuid.UUID) ([]Connection, error) {
+	ctx := context.Background()
+	entries, err := r.client.HGetAll(ctx, presenceKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	conns := make([]Connection, 0, len(entries))
+	for _, raw := range entries {
+		var conn Connection
+		if err := json.Unmarshal([]byte(raw), &conn); err != nil {
+			continue
+		}
+		conns = append(conns, conn)
+	}
+
+	alive := map[string]bool{r.nodeID: true}
+	for _, nodeID := range Nodes(conns) {
+		if alive[nodeID] {
+			continue
+		}
+		exists, err := r.client.Exists(ctx, nodeAliveKey(nodeID)).Result()
+		if err != nil {
+			return nil, err
+		}
+		alive[nodeID] = exists == 1
+	}
+
+	live := conns[:0]
+	for _, conn := range conns {
+		if alive[conn.NodeID] {
+			live = append(live, conn)
+			continue
+		}
+		// the device may have reconnected to a live node since it was read
+		r.remove(ctx, conn)
+	}
+	return live, nil
+}
+
+func (r *redisRegistry) Heartbeat(ctx context.Context) error {
+	return r.client.Set(ctx, nodeAliveKey(r.nodeID), time.Now().UTC().Format(time.RFC3339), r.ttl).Err()
+}
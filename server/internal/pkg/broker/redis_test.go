@@ -0,0 +1,272 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"shiplabs/schat/internal/pkg/socket"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+// waitSubscribed blocks until the node's subscription reached the server,
+// Subscribe returns before it does.
+func waitSubscribed(t *testing.T, server *miniredis.Miniredis, nodeID string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for server.PubSubNumSub(nodeChannel(nodeID))[nodeChannel(nodeID)] == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription never reached redis")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRedisBrokerDelivers(t *testing.T) {
+	server, client := newTestRedis(t)
+	broker := NewRedisBroker(client, "node-a")
+	other := NewRedisBroker(client, "node-b")
+	t.Cleanup(func() { broker.Close() })
+
+	received := make(chan Delivery, 1)
+	broker.Subscribe(func(delivery Delivery) { received <- delivery })
+	waitSubscribed(t, server, "node-a")
+
+	messageID := uuid.New()
+	sent := Delivery{
+		UserID:       uuid.New(),
+		SkipDeviceID: "phone",
+		MessageID:    &messageID,
+		Event:        socket.Event{Type: "message", StatusCode: 200, Payload: map[string]string{"content": "hi"}},
+	}
+	if err := other.Publish(context.Background(), "node-a", sent); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-received:
+		if got.UserID != sent.UserID || got.SkipDeviceID != sent.SkipDeviceID || *got.MessageID != messageID {
+			t.Fatalf("delivery = %+v, want %+v", got, sent)
+		}
+		payload, ok := got.Event.Payload.(*json.RawMessage)
+		if !ok {
+			t.Fatalf("payload is %T, want raw JSON", got.Event.Payload)
+		}
+		if string(*payload) != `{"content":"hi"}` {
+			t.Fatalf("payload = %s", *payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("delivery never arrived")
+	}
+}
+
+func TestRedisBrokerDropsMalformed(t *testing.T) {
+	server, client := newTestRedis(t)
+	broker := NewRedisBroker(client, "node-a")
+	t.Cleanup(func() { broker.Close() })
+
+	received := make(chan Delivery, 2)
+	broker.Subscribe(func(delivery Delivery) { received <- delivery })
+	waitSubscribed(t, server, "node-a")
+
+	// deliveries are handled in order, so the valid one arriving first
+	// means the malformed one was skipped
+	server.Publish(nodeChannel("node-a"), "{not json")
+	userID := uuid.New()
+	if err := broker.Publish(context.Background(), "node-a", Delivery{UserID: userID}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-received:
+		if got.UserID != userID {
+			t.Fatalf("got delivery for %s, want %s", got.UserID, userID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("delivery never arrived")
+	}
+}
+
+func TestRedisBrokerClose(t *testing.T) {
+	_, client := newTestRedis(t)
+	if err := NewRedisBroker(client, "node-a").Close(); err != nil {
+		t.Fatalf("closing an unsubscribed broker: %v", err)
+	}
+}
+
+func deviceIDs(conns []Connection) []string {
+	ids := make([]string, 0, len(conns))
+	for _, conn := range conns {
+		ids = append(ids, conn.DeviceID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestRedisRegistry(t *testing.T) {
+	userID := uuid.New()
+	connectedAt := time.Date(2026, 10, 18, 9, 30, 0, 123456789, time.UTC)
+	phone := Connection{UserID: userID, DeviceID: "phone", NodeID: "node-a", ConnectedAt: connectedAt}
+	laptop := Connection{UserID: userID, DeviceID: "laptop", NodeID: "node-b", ConnectedAt: connectedAt}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, server *miniredis.Miniredis, a, b Registry)
+		want []string
+	}{
+		{
+			name: "live nodes",
+			run: func(t *testing.T, _ *miniredis.Miniredis, a, b Registry) {
+				mustDo(t, b.Heartbeat(context.Background()))
+			},
+			want: []string{"laptop", "phone"},
+		},
+		{
+			name: "node never seen alive",
+			run:  func(*testing.T, *miniredis.Miniredis, Registry, Registry) {},
+			want: []string{"phone"},
+		},
+		{
+			name: "heartbeat expired",
+			run: func(t *testing.T, server *miniredis.Miniredis, a, b Registry) {
+				mustDo(t, b.Heartbeat(context.Background()))
+				server.FastForward(time.Minute)
+			},
+			want: []string{"phone"},
+		},
+		{
+			name: "unregister",
+			run: func(t *testing.T, _ *miniredis.Miniredis, a, b Registry) {
+				mustDo(t, b.Heartbeat(context.Background()))
+				mustDo(t, b.Unregister(laptop))
+			},
+			want: []string{"phone"},
+		},
+		{
+			name: "unregister after reconnecting elsewhere",
+			run: func(t *testing.T, _ *miniredis.Miniredis, a, b Registry) {
+				mustDo(t, b.Heartbeat(context.Background()))
+				moved := laptop
+				moved.NodeID = "node-a"
+				moved.ConnectedAt = connectedAt.Add(time.Second)
+				mustDo(t, a.Register(moved))
+				mustDo(t, b.Unregister(laptop))
+			},
+			want: []string{"laptop", "phone"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := newTestRedis(t)
+			a := NewRedisRegistry(client, "node-a", 30*time.Second)
+			b := NewRedisRegistry(client, "node-b", 30*time.Second)
+			mustDo(t, a.Register(phone))
+			mustDo(t, b.Register(laptop))
+
+			tt.run(t, server, a, b)
+
+			conns, err := a.Connections(userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := deviceIDs(conns); !equal(got, tt.want) {
+				t.Fatalf("Connections() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRedisRegistryPrunes checks the entries of a dead node are removed,
+// not just hidden.
+func TestRedisRegistryPrunes(t *testing.T) {
+	server, client := newTestRedis(t)
+	registry := NewRedisRegistry(client, "node-a", 30*time.Second)
+	userID := uuid.New()
+	mustDo(t, registry.Register(Connection{UserID: userID, DeviceID: "phone", NodeID: "node-gone"}))
+
+	if _, err := registry.Connections(userID); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists(presenceKey(userID)) {
+		keys, _ := server.HKeys(presenceKey(userID))
+		t.Fatalf("dead node entries left behind: %v", keys)
+	}
+}
+
+// TestRedisRegistryPruneKeepsReconnected covers a device that reconnected
+// to a live node after Connections read its stale entry.
+func TestRedisRegistryPruneKeepsReconnected(t *testing.T) {
+	server, client := newTestRedis(t)
+	registry := NewRedisRegistry(client, "node-a", 30*time.Second).(*redisRegistry)
+	userID := uuid.New()
+	connectedAt := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	stale := Connection{UserID: userID, DeviceID: "phone", NodeID: "node-gone", ConnectedAt: connectedAt}
+	mustDo(t, registry.Register(stale))
+
+	moved := stale
+	moved.NodeID = "node-a"
+	moved.ConnectedAt = connectedAt.Add(time.Second)
+	mustDo(t, registry.Register(moved))
+	mustDo(t, registry.remove(context.Background(), stale))
+
+	if !server.Exists(presenceKey(userID)) {
+		t.Fatal("pruning the stale entry removed the reconnected device")
+	}
+	conns, err := registry.Connections(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conns) != 1 || conns[0].NodeID != "node-a" {
+		t.Fatalf("Connections() = %+v, want the device on node-a", conns)
+	}
+}
+
+func TestRedisRegistryHeartbeatTTL(t *testing.T) {
+	server, client := newTestRedis(t)
+	mustDo(t, NewRedisRegistry(client, "node-a", 0).Heartbeat(context.Background()))
+	if ttl := server.TTL(nodeAliveKey("node-a")); ttl != 30*time.Second {
+		t.Fatalf("heartbeat ttl = %s, want the 30s default", ttl)
+	}
+}
+
+func TestRedisRegistryUnavailable(t *testing.T) {
+	server, client := newTestRedis(t)
+	server.Close()
+	_, err := NewRedisRegistry(client, "node-a", time.Second).Connections(uuid.New())
+	if err == nil || errors.Is(err, redis.Nil) {
+		t.Fatalf("Connections() error = %v, want a connection error", err)
+	}
+}
+
+func mustDo(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 	"github.com/joho/godotenv"
@@ -17,10 +18,101 @@ type Database struct {
 	Name     string `env:"NAME,required"`
 }
 
+// WebSocket AllowedOrigins are the browser origins, as scheme://host[:port],
+// that may open a connection besides the server's own. "*" allows any.
+type WebSocket struct {
+	SendQueueSize      int           `env:"SEND_QUEUE_SIZE" envDefault:"256"`
+	WriteTimeout       time.Duration `env:"WRITE_TIMEOUT" envDefault:"10s"`
+	PongWait           time.Duration `env:"PONG_WAIT" envDefault:"60s"`
+	PingInterval       time.Duration `env:"PING_INTERVAL" envDefault:"50s"`
+	MaxMessageSize     int64         `env:"MAX_MESSAGE_SIZE" envDefault:"65536"`
+	ReaperInterval     time.Duration `env:"REAPER_INTERVAL" envDefault:"30s"`
+	SlowConsumerPolicy string        `env:"SLOW_CONSUMER_POLICY" envDefault:"disconnect"`
+	AllowedOrigins     []string      `env:"ALLOWED_ORIGINS" envSeparator:","`
+}
+
+type Storage struct {
+	Driver       string `env:"DRIVER" envDefault:"local"`
+	LocalDir     string `env:"LOCAL_DIR" envDefault:"./uploads"`
+	S3Endpoint   string `env:"S3_ENDPOINT"`
+	S3AccessKey  string `env:"S3_ACCESS_KEY"`
+	S3SecretKey  string `env:"S3_SECRET_KEY"`
+	S3Bucket     string `env:"S3_BUCKET" envDefault:"schat"`
+	S3Region     string `env:"S3_REGION"`
+	S3UseSSL     bool   `env:"S3_USE_SSL" envDefault:"true"`
+	MaxImageSize int64  `env:"MAX_IMAGE_SIZE" envDefault:"10485760"`
+	MaxVideoSize int64  `env:"MAX_VIDEO_SIZE" envDefault:"104857600"`
+	MaxAudioSize int64  `env:"MAX_AUDIO_SIZE" envDefault:"20971520"`
+	ThumbnailMax int    `env:"THUMBNAIL_MAX" envDefault:"320"`
+	// URLSigningKey signs download links; APP_SECRET is used when unset.
+	URLSigningKey string        `env:"URL_SIGNING_KEY"`
+	URLExpiry     time.Duration `env:"URL_EXPIRY" envDefault:"15m"`
+}
+
+type Broker struct {
+	Driver        string        `env:"DRIVER" envDefault:"memory"`
+	NodeID        string        `env:"NODE_ID"`
+	NodeTTL       time.Duration `env:"NODE_TTL" envDefault:"30s"`
+	RedisAddr     string        `env:"REDIS_ADDR" envDefault:"localhost:6379"`
+	RedisPassword string        `env:"REDIS_PASSWORD"`
+	RedisDB       int           `env:"REDIS_DB" envDefault:"0"`
+}
+
+type Outbox struct {
+	PollInterval time.Duration `env:"POLL_INTERVAL" envDefault:"1s"`
+	BatchSize    int           `env:"BATCH_SIZE" envDefault:"100"`
+	Lease        time.Duration `env:"LEASE" envDefault:"30s"`
+	Retention    time.Duration `env:"RETENTION" envDefault:"24h"`
+}
+
+// Mail LinkBaseURL is the web client address the links in emails open.
+type Mail struct {
+	Driver       string `env:"DRIVER" envDefault:"log"`
+	From         string `env:"FROM" envDefault:"schat <no-reply@localhost>"`
+	SMTPHost     string `env:"SMTP_HOST" envDefault:"localhost"`
+	SMTPPort     int    `env:"SMTP_PORT" envDefault:"1025"`
+	SMTPUser     string `env:"SMTP_USER"`
+	SMTPPassword string `env:"SMTP_PASSWORD"`
+	LinkBaseURL  string `env:"LINK_BASE_URL" envDefault:"http://localhost:3000"`
+}
+
+// Auth SigningKeyFile is an RSA or Ed25519 private key, SigningKeyID its
+// kid, the key thumbprint when unset. KeyGrace is how long tokens signed
+// with a key keep verifying after it was replaced, it must cover AccessTTL.
+type Auth struct {
+	AccessTTL      time.Duration `env:"ACCESS_TTL" envDefault:"15m"`
+	RefreshTTL     time.Duration `env:"REFRESH_TTL" envDefault:"720h"`
+	Issuer         string        `env:"ISSUER" envDefault:"schat"`
+	Audience       string        `env:"AUDIENCE" envDefault:"schat"`
+	SigningKeyFile string        `env:"SIGNING_KEY_FILE"`
+	SigningKeyID   string        `env:"SIGNING_KEY_ID"`
+	KeyGrace       time.Duration `env:"KEY_GRACE" envDefault:"24h"`
+	TicketTTL      time.Duration `env:"TICKET_TTL" envDefault:"30s"`
+	VerifyTTL      time.Duration `env:"VERIFY_TTL" envDefault:"48h"`
+	ResetTTL       time.Duration `env:"RESET_TTL" envDefault:"1h"`
+	// RequireVerifiedEmail refuses logins until the email is verified.
+	RequireVerifiedEmail bool `env:"REQUIRE_VERIFIED_EMAIL" envDefault:"false"`
+	// TOTPKey encrypts two-factor secrets; APP_SECRET is used when unset.
+	TOTPKey           string        `env:"TOTP_KEY"`
+	TOTPIssuer        string        `env:"TOTP_ISSUER" envDefault:"schat"`
+	ChallengeTTL      time.Duration `env:"CHALLENGE_TTL" envDefault:"5m"`
+	ChallengeAttempts int           `env:"CHALLENGE_ATTEMPTS" envDefault:"5"`
+	// every TwoFactorMaxFailures wrong codes in a row lock two-factor for
+	// TwoFactorLockout, doubling each time up to a day
+	TwoFactorMaxFailures int           `env:"TWO_FACTOR_MAX_FAILURES" envDefault:"5"`
+	TwoFactorLockout     time.Duration `env:"TWO_FACTOR_LOCKOUT" envDefault:"15m"`
+}
+
 type Config struct {
-	Port       string   `env:"PORT,required"`
-	DB         Database `env:"" envPrefix:"DB_"`
-	APP_SECRET string   `env:"APP_SECRET,required"`
+	Port       string    `env:"PORT,required"`
+	DB         Database  `env:"" envPrefix:"DB_"`
+	WS         WebSocket `env:"" envPrefix:"WS_"`
+	Storage    Storage   `env:"" envPrefix:"STORAGE_"`
+	Broker     Broker    `env:"" envPrefix:"BROKER_"`
+	Outbox     Outbox    `env:"" envPrefix:"OUTBOX_"`
+	Auth       Auth      `env:"" envPrefix:"AUTH_"`
+	Mail       Mail      `env:"" envPrefix:"MAIL_"`
+	APP_SECRET string    `env:"APP_SECRET,required"`
 }
 
 func Load() {
@@ -33,15 +33,63 @@ func Connect() {
 		panic(err)
 	}
 
+	if err = migrate(db, earlyMigrations); err != nil {
+		fmt.Println("Error migrating data: ", err)
+		panic(err)
+	}
+
 	err = db.AutoMigrate(
-		&models.User{}, &models.PrivateChat{}, &models.GroupMessage{},
+		&models.User{}, &models.Attachment{}, &models.PrivateChat{}, &models.GroupMessage{},
 		&models.PrivateMessage{}, &models.Group{}, &models.GroupMember{},
+		&models.MessageDelivery{}, &models.MessageEdit{}, &models.HiddenMessage{},
+		&models.MessageReaction{}, &models.OutboxEvent{}, &models.Session{}, &models.RefreshToken{}, &models.ConnectTicket{},
+		&models.SigningKey{}, &models.UserToken{}, &models.TwoFactor{}, &models.RecoveryCode{},
 	)
 
 	if err != nil {
 		fmt.Println("Error auto migrating database: ", err)
 		panic(err)
 	}
+
+	// full-text search indexes, the expression must match the one used by
+	// the search repo for the planner to pick them up
+	searchIndexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_private_messages_content_fts ON private_messages USING GIN (to_tsvector('english', content))",
+		"CREATE INDEX IF NOT EXISTS idx_group_messages_content_fts ON group_messages USING GIN (to_tsvector('english', content))",
+	}
+	for _, statement := range searchIndexes {
+		if err = db.Exec(statement).Error; err != nil {
+			fmt.Println("Error creating search index: ", err)
+			panic(err)
+		}
+	}
+
+	if err = migrate(db, migrations); err != nil {
+		fmt.Println("Error migrating data: ", err)
+		panic(err)
+	}
+
+	// sequence numbers are unique per conversation once the old messages
+	// are numbered
+	seqIndexes := []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_private_messages_seq ON private_messages (chat_id, seq)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_group_messages_seq ON group_messages (group_id, seq)",
+	}
+	for _, statement := range seqIndexes {
+		if err = db.Exec(statement).Error; err != nil {
+			fmt.Println("Error creating sequence index: ", err)
+			panic(err)
+		}
+	}
+
+	// one private chat per pair, once the duplicates are merged
+	err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_private_chats_pair ON private_chats
+		(LEAST(first_member_id, second_member_id), GREATEST(first_member_id, second_member_id))
+		WHERE deleted_at IS NULL`).Error
+	if err != nil {
+		fmt.Println("Error creating private chat index: ", err)
+		panic(err)
+	}
 	fmt.Println("Database connected")
 
 	DB = db
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"time"
+
+	"shiplabs/schat/pkg/shared"
+
+	"gorm.io/gorm"
+)
+
+// schemaMigration records a migration that ran, so it never runs again.
+type schemaMigration struct {
+	Version   string    `gorm:"primaryKey"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// migration is a one-off data fix AutoMigrate can't express. Its
+// statements run once, in a single transaction.
+type migration struct {
+	version    string
+	statements []string
+}
+
+// earlyMigrations run before AutoMigrate, to clear out data that would keep
+// it from adding a constraint. The tables may not exist yet.
+var earlyMigrations = []migration{
+	{
+		// reactions and hidden messages duplicated before their unique
+		// indexes existed
+		version: "0002_dedup_reactions",
+		statements: []string{
+			`DO $$ BEGIN
+				IF to_regclass('message_reactions') IS NOT NULL THEN
+					DELETE FROM message_reactions WHERE id IN (
+						SELECT id FROM (
+							SELECT id, ROW_NUMBER() OVER (PARTITION BY message_id, user_id, emoji ORDER BY id) AS n
+							FROM message_reactions
+						) numbered WHERE n > 1
+					);
+				END IF;
+			END $$`,
+			`DO $$ BEGIN
+				IF to_regclass('hidden_messages') IS NOT NULL THEN
+					DELETE FROM hidden_messages WHERE id IN (
+						SELECT id FROM (
+							SELECT id, ROW_NUMBER() OVER (PARTITION BY message_id, user_id ORDER BY id) AS n
+							FROM hidden_messages
+						) numbered WHERE n > 1
+					);
+				END IF;
+			END $$`,
+		},
+	},
+}
+
+// migrations run in order after AutoMigrate. Append new ones to either list
+// with the next version, never edit or reorder the ones released.
+var migrations = []migration{
+	{
+		// number the messages stored before sequence numbers existed
+		version: "0001_number_messages",
+		statements: []string{
+			`UPDATE private_messages SET seq = numbered.seq FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY chat_id ORDER BY created_at, id) AS seq
+				FROM private_messages WHERE seq = 0
+			) numbered WHERE private_messages.id = numbered.id`,
+			`UPDATE private_chats SET last_seq = counted.last_seq FROM (
+				SELECT chat_id, MAX(seq) AS last_seq FROM private_messages GROUP BY chat_id
+			) counted WHERE private_chats.id = counted.chat_id AND private_chats.last_seq < counted.last_seq`,
+			`UPDATE group_messages SET seq = numbered.seq FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY group_id ORDER BY created_at, id) AS seq
+				FROM group_messages WHERE seq = 0
+			) numbered WHERE group_messages.id = numbered.id`,
+			`UPDATE groups SET last_seq = counted.last_seq FROM (
+				SELECT group_id, MAX(seq) AS last_seq FROM group_messages GROUP BY group_id
+			) counted WHERE groups.id = counted.group_id AND groups.last_seq < counted.last_seq`,
+		},
+	},
+	{
+		// concurrent first messages used to open a second chat for the same
+		// pair. Fold each duplicate into the oldest chat, renumbering the
+		// merged messages (negated first so the seq index holds mid-update),
+		// then retire the duplicate.
+		version: "0003_merge_private_chats",
+		statements: []string{
+			`CREATE TEMP TABLE chat_merges ON COMMIT DROP AS
+				SELECT id, keep_id FROM (
+					SELECT id, FIRST_VALUE(id) OVER (
+						PARTITION BY LEAST(first_member_id, second_member_id), GREATEST(first_member_id, second_member_id)
+						ORDER BY created_at, id
+					) AS keep_id FROM private_chats WHERE deleted_at IS NULL
+				) paired WHERE id <> keep_id`,
+			`UPDATE private_messages SET chat_id = numbered.keep_id, seq = -numbered.seq FROM (
+				SELECT m.id, k.keep_id, ROW_NUMBER() OVER (PARTITION BY k.keep_id ORDER BY m.created_at, m.id) AS seq
+				FROM private_messages m
+				JOIN (SELECT id, keep_id FROM chat_merges UNION SELECT keep_id, keep_id FROM chat_merges) k ON m.chat_id = k.id
+			) numbered WHERE private_messages.id = numbered.id`,
+			`UPDATE private_messages SET seq = -seq WHERE seq < 0`,
+			`UPDATE private_chats SET last_seq = counted.last_seq FROM (
+				SELECT chat_id, MAX(seq) AS last_seq FROM private_messages
+				WHERE chat_id IN (SELECT keep_id FROM chat_merges) GROUP BY chat_id
+			) counted WHERE private_chats.id = counted.chat_id`,
+			`UPDATE message_deliveries SET conversation_id = chat_merges.keep_id FROM chat_merges
+				WHERE message_deliveries.conversation_type = 'private' AND message_deliveries.conversation_id = chat_merges.id`,
+			`UPDATE message_reactions SET conversation_id = chat_merges.keep_id FROM chat_merges
+				WHERE message_reactions.conversation_type = 'private' AND message_reactions.conversation_id = chat_merges.id`,
+			`UPDATE private_chats SET deleted_at = NOW() FROM chat_merges WHERE private_chats.id = chat_merges.id`,
+		},
+	},
+}
+
+// migrate runs the migrations not applied yet. Instances starting together
+// take turns on an advisory lock, the later ones find the work done.
+func migrate(db *gorm.DB, pending []migration) error {
+	if err := db.AutoMigrate(&schemaMigration{}); err != nil {
+		return err
+	}
+
+	for _, m := range pending {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('schema_migrations'))").Error; err != nil {
+				return err
+			}
+			var applied int64
+			if err := tx.Model(&schemaMigration{}).Where("version = ?", m.version).Count(&applied).Error; err != nil {
+				return err
+			}
+			if applied > 0 {
+				return nil
+			}
+
+			for _, statement := range m.statements {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Create(&schemaMigration{Version: m.version, AppliedAt: shared.TimeNow()}).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package db
+
+import "testing"
+
+// TestMigrationVersions checks every migration has its own version, a
+// reused one would be taken as applied and skipped.
+func TestMigrationVersions(t *testing.T) {
+	seen := map[string]bool{}
+	for _, m := range append(earlyMigrations, migrations...) {
+		if m.version == "" || seen[m.version] {
+			t.Fatalf("migration version %q missing or reused", m.version)
+		}
+		seen[m.version] = true
+		if len(m.statements) == 0 {
+			t.Fatalf("migration %s has no statements", m.version)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"shiplabs/schat/internal/pkg/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Active signs the tokens issued by this instance.
+var Active *Signer
+
+var (
+	ErrNoKey          = errors.New("no PEM key found")
+	ErrUnsupportedKey = errors.New("only RSA and Ed25519 keys are supported")
+	// ErrSharedKeyRequired is returned for a multi instance deployment
+	// without AUTH_SIGNING_KEY_FILE.
+	ErrSharedKeyRequired = errors.New("AUTH_SIGNING_KEY_FILE is required with a shared broker")
+)
+
+// Signer is a private key with its key ID, the kid header of every token
+// it signs.
+type Signer struct {
+	KeyID   string
+	Method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+func InitKeys() {
+	var err error
+	Active, err = loadActive(config.Configs.Auth, config.Configs.Broker)
+	if err != nil {
+		fmt.Println("Error loading signing key: ", err)
+		panic(err)
+	}
+}
+
+// loadActive picks the key this instance signs with. Instances sharing a
+// broker must load the same key file, one publishing a key of its own
+// retires the keys of the others.
+func loadActive(authConfig config.Auth, brokerConfig config.Broker) (*Signer, error) {
+	if authConfig.SigningKeyFile != "" {
+		return LoadSigner(authConfig.SigningKeyFile, authConfig.SigningKeyID)
+	}
+	if brokerConfig.Driver != "memory" {
+		return nil, ErrSharedKeyRequired
+	}
+	// a throwaway key only suits a single instance: its tokens stop
+	// verifying a grace period after a restart
+	fmt.Println("AUTH_SIGNING_KEY_FILE not set, signing with a generated Ed25519 key")
+	return GenerateSigner()
+}
+
+// LoadSigner reads a PKCS#8 or PKCS#1 private key. The key ID defaults to
+// the key's RFC 7638 thumbprint.
+func LoadSigner(path, keyID string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoKey
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	private, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	return newSigner(private, keyID)
+}
+
+func GenerateSigner() (*Signer, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newSigner(private, "")
+}
+
+func newSigner(private crypto.Signer, keyID string) (*Signer, error) {
+	method, err := MethodFor(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	if keyID == "" {
+		if keyID, err = Thumbprint(private.Public()); err != nil {
+			return nil, err
+		}
+	}
+	return &Signer{KeyID: keyID, Method: method, private: private}, nil
+}
+
+func (s *Signer) Public() crypto.PublicKey {
+	return s.private.Public()
+}
+
+func (s *Signer) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.Method, claims)
+	token.Header["kid"] = s.KeyID
+	return token.SignedString(s.private)
+}
+
+// Algorithms lists the signing algorithms tokens are accepted with.
+func Algorithms() []string {
+	return []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+}
+
+func MethodFor(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+func MarshalPublicKey(public crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+func ParsePublicKey(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, ErrNoKey
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// JWK is the public half of a key as published in the JWKS document.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewJWK(keyID string, public crypto.PublicKey) (JWK, error) {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType:   "RSA",
+			KeyID:     keyID,
+			Use:       "sig",
+			Algorithm: jwt.SigningMethodRS256.Alg(),
+			N:         encode(key.N.Bytes()),
+			E:         encode(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType:   "OKP",
+			KeyID:     keyID,
+			Use:       "sig",
+			Algorithm: jwt.SigningMethodEdDSA.Alg(),
+			Curve:     "Ed25519",
+			X:         encode(key),
+		}, nil
+	default:
+		return JWK{}, ErrUnsupportedKey
+	}
+}
+
+// Thumbprint is the RFC 7638 thumbprint of the key: the hash of its
+// required JWK members, in lexicographic order.
+func Thumbprint(public crypto.PublicKey) (string, error) {
+	jwk, err := NewJWK("", public)
+	if err != nil {
+		return "", err
+	}
+
+	var members any
+	if jwk.KeyType == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return encode(sum[:]), nil
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
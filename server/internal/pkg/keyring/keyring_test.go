@@ -0,0 +1,265 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"shiplabs/schat/internal/pkg/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func decode(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestThumbprint(t *testing.T) {
+	// the examples of RFC 7638 section 3.1 and RFC 8037 appendix A.3
+	rsaKey := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(decode(t, "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")),
+		E: 65537,
+	}
+	edKey := ed25519.PublicKey(decode(t, "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"))
+
+	tests := []struct {
+		name string
+		key  crypto.PublicKey
+		want string
+	}{
+		{"rsa", rsaKey, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"},
+		{"ed25519", edKey, "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Thumbprint(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("Thumbprint() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewJWK(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     crypto.PublicKey
+		want    JWK
+		wantErr error
+	}{
+		{"rsa", &rsaKey.PublicKey, JWK{
+			KeyType: "RSA", KeyID: "kid", Use: "sig", Algorithm: "RS256",
+			N: encode(rsaKey.N.Bytes()), E: "AQAB",
+		}, nil},
+		{"ed25519", edPublic, JWK{
+			KeyType: "OKP", KeyID: "kid", Use: "sig", Algorithm: "EdDSA",
+			Curve: "Ed25519", X: encode(edPublic),
+		}, nil},
+		{"ecdsa", &ecKey.PublicKey, JWK{}, ErrUnsupportedKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewJWK("kid", tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewJWK() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("NewJWK() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaThumbprint, _ := Thumbprint(&rsaKey.PublicKey)
+	edThumbprint, _ := Thumbprint(edKey.Public())
+
+	notPEM := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(notPEM, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		keyID      string
+		wantKeyID  string
+		wantMethod jwt.SigningMethod
+		wantErr    error
+	}{
+		{"pkcs1 rsa", writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), "", rsaThumbprint, jwt.SigningMethodRS256, nil},
+		{"pkcs8 ed25519", writePEM(t, "PRIVATE KEY", edDER), "", edThumbprint, jwt.SigningMethodEdDSA, nil},
+		{"configured kid", writePEM(t, "PRIVATE KEY", edDER), "2026-10", "2026-10", jwt.SigningMethodEdDSA, nil},
+		{"ecdsa", writePEM(t, "PRIVATE KEY", ecDER), "", "", nil, ErrUnsupportedKey},
+		{"not pem", notPEM, "", "", nil, ErrNoKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := LoadSigner(tt.path, tt.keyID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("LoadSigner() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if signer.KeyID != tt.wantKeyID {
+				t.Errorf("KeyID = %s, want %s", signer.KeyID, tt.wantKeyID)
+			}
+			if signer.Method != tt.wantMethod {
+				t.Errorf("Method = %s, want %s", signer.Method.Alg(), tt.wantMethod.Alg())
+			}
+		})
+	}
+}
+
+// TestSignRotation checks each token names the key that signed it, so
+// tokens from a rotated out key keep verifying against its published half
+// and never against the new one.
+func TestSignRotation(t *testing.T) {
+	old, err := GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.KeyID == current.KeyID {
+		t.Fatal("two generated keys share a kid")
+	}
+	published := map[string]crypto.PublicKey{
+		old.KeyID:     old.Public(),
+		current.KeyID: current.Public(),
+	}
+
+	for _, signer := range []*Signer{old, current} {
+		signed, err := signer.Sign(jwt.RegisteredClaims{Subject: "user"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := jwt.Parse(signed, func(token *jwt.Token) (any, error) {
+			keyID, _ := token.Header["kid"].(string)
+			key, ok := published[keyID]
+			if !ok {
+				return nil, ErrNoKey
+			}
+			return key, nil
+		}, jwt.WithValidMethods(Algorithms()))
+		if err != nil || !token.Valid {
+			t.Fatalf("token of %s rejected: %v", signer.KeyID, err)
+		}
+		if kid := token.Header["kid"]; kid != signer.KeyID {
+			t.Fatalf("kid = %v, want %s", kid, signer.KeyID)
+		}
+
+		other := current
+		if signer == current {
+			other = old
+		}
+		_, err = jwt.Parse(signed, func(*jwt.Token) (any, error) { return other.Public(), nil },
+			jwt.WithValidMethods(Algorithms()))
+		if err == nil {
+			t.Fatalf("token of %s verified with %s", signer.KeyID, other.KeyID)
+		}
+	}
+}
+
+func TestLoadActive(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writePEM(t, "PRIVATE KEY", edDER)
+	edThumbprint, _ := Thumbprint(edKey.Public())
+
+	tests := []struct {
+		name      string
+		keyFile   string
+		driver    string
+		wantKeyID string
+		wantErr   error
+	}{
+		{"key file", keyFile, "memory", edThumbprint, nil},
+		{"shared key file", keyFile, "redis", edThumbprint, nil},
+		{"generated for a single instance", "", "memory", "", nil},
+		{"generated behind a shared broker", "", "redis", "", ErrSharedKeyRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := loadActive(config.Auth{SigningKeyFile: tt.keyFile}, config.Broker{Driver: tt.driver})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("loadActive() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && tt.wantKeyID != "" && signer.KeyID != tt.wantKeyID {
+				t.Fatalf("KeyID = %s, want %s", signer.KeyID, tt.wantKeyID)
+			}
+		})
+	}
+}
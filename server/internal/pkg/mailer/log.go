@@ -0,0 +1,23 @@
+package mailer
+
+import (
+	"context"
+	"log"
+)
+
+// logMailer prints messages instead of sending them, for development.
+type logMailer struct {
+	from string
+}
+
+func NewLogMailer(from string) Mailer {
+	return &logMailer{from: from}
+}
+
+func (l *logMailer) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	log.Printf("mail from %s to %s\nSubject: %s\n\n%s", l.from, msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"shiplabs/schat/internal/pkg/config"
+)
+
+var Mail Mailer
+
+var ErrInvalidHeader = errors.New("line breaks are not allowed in mail headers")
+
+// Message is a plain text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends transactional email. Send returns once the message was
+// handed over, not delivered.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+func InitMailer() {
+	mailConfig := config.Configs.Mail
+
+	var err error
+	switch mailConfig.Driver {
+	case "smtp":
+		Mail, err = NewSMTPMailer(mailConfig)
+	case "log", "":
+		Mail = NewLogMailer(mailConfig.From)
+	default:
+		err = fmt.Errorf("unknown mail driver %q", mailConfig.Driver)
+	}
+
+	if err != nil {
+		fmt.Println("Error initialising mailer: ", err)
+		panic(err)
+	}
+}
+
+// validate keeps header values from smuggling in extra headers.
+func (m Message) validate() error {
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return ErrInvalidHeader
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"shiplabs/schat/internal/pkg/config"
+
+	"github.com/google/uuid"
+)
+
+// smtpMailer relays through an SMTP server. Without credentials it sends
+// unauthenticated, which is what catchers like MailHog expect.
+type smtpMailer struct {
+	addr string
+	host string
+	from mail.Address
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(mailConfig config.Mail) (Mailer, error) {
+	from, err := mail.ParseAddress(mailConfig.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address: %w", err)
+	}
+
+	m := &smtpMailer{
+		addr: net.JoinHostPort(mailConfig.SMTPHost, strconv.Itoa(mailConfig.SMTPPort)),
+		host: mailConfig.SMTPHost,
+		from: *from,
+	}
+	if mailConfig.SMTPUser != "" {
+		m.auth = smtp.PlainAuth("", mailConfig.SMTPUser, mailConfig.SMTPPassword, mailConfig.SMTPHost)
+	}
+	return m, nil
+}
+
+func (s *smtpMailer) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+
+	err = s.deliver(ctx, to.Address, s.compose(to, msg))
+	if err != nil && ctx.Err() != nil {
+		// the connection was dropped because of it
+		return ctx.Err()
+	}
+	return err
+}
+
+// deliver does what smtp.SendMail does, on a connection bound to ctx: its
+// deadline applies to every command and cancelling it drops the
+// connection, so a hung relay can't hold the caller.
+func (s *smtpMailer) deliver(ctx context.Context, to string, body []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		if err := c.Auth(s.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (s *smtpMailer) compose(to *mail.Address, msg Message) []byte {
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	header("From", s.from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@%s>", uuid.NewString(), domainOf(s.from.Address)))
+	header("MIME-Version", "1.0")
+	header("Content-Type", `text/plain; charset="utf-8"`)
+	header("Content-Transfer-Encoding", "8bit")
+	buf.WriteString("\r\n")
+	buf.WriteString(msg.Body)
+	return buf.Bytes()
+}
+
+func domainOf(address string) string {
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return address[at+1:]
+	}
+	return "localhost"
+}
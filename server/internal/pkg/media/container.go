@@ -0,0 +1,201 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+func isMP4(mimeType string) bool {
+	switch mimeType {
+	case "video/mp4", "video/quicktime", "video/3gpp", "video/3gpp2",
+		"audio/mp4", "audio/x-m4a", "audio/3gpp", "audio/3gpp2":
+		return true
+	}
+	return strings.HasPrefix(mimeType, "video/x-m4v")
+}
+
+// probeMP4 walks the ISO-BMFF box tree far enough to read the movie header
+// (mvhd) for duration and the first track header (tkhd) with a non-zero
+// size for video dimensions. Media data boxes are skipped, not read.
+func probeMP4(r io.ReadSeeker) (Metadata, error) {
+	var meta Metadata
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return meta, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return meta, err
+	}
+
+	err = walkBoxes(r, 0, end, 0, func(boxType string) (bool, error) {
+		switch boxType {
+		case "moov", "trak":
+			return true, nil
+		case "mvhd":
+			duration, err := readMvhd(r)
+			if err != nil {
+				return false, err
+			}
+			meta.DurationMs = duration
+		case "tkhd":
+			if meta.Width != 0 {
+				return false, nil
+			}
+			width, height, err := readTkhd(r)
+			if err != nil {
+				return false, err
+			}
+			meta.Width, meta.Height = width, height
+		}
+		return false, nil
+	})
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil
+	}
+	return meta, err
+}
+
+// maxBoxDepth bounds how deep walkBoxes descends, well past where moov and
+// trak sit, so a file of boxes nested in each other can't exhaust the stack.
+const maxBoxDepth = 8
+
+// walkBoxes calls visit for every box between start and end. When visit
+// returns true the box is treated as a container and its children walked.
+func walkBoxes(r io.ReadSeeker, start, end int64, depth int, visit func(boxType string) (bool, error)) error {
+	header := make([]byte, 8)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, header); err != nil {
+			return err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerLen := int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			large := make([]byte, 8)
+			if _, err := io.ReadFull(r, large); err != nil {
+				return err
+			}
+			size = int64(binary.BigEndian.Uint64(large))
+			headerLen = 16
+		}
+		if size < headerLen || size > end-offset {
+			return nil
+		}
+
+		descend, err := visit(boxType)
+		if err != nil {
+			return err
+		}
+		if descend && depth < maxBoxDepth {
+			if err := walkBoxes(r, offset+headerLen, offset+size, depth+1, visit); err != nil {
+				return err
+			}
+		}
+		offset += size
+	}
+	return nil
+}
+
+func readMvhd(r io.Reader) (int64, error) {
+	version := make([]byte, 4)
+	if _, err := io.ReadFull(r, version); err != nil {
+		return 0, err
+	}
+
+	var timescale uint32
+	var duration uint64
+	if version[0] == 1 {
+		buf := make([]byte, 28)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return 0, err
+		}
+		timescale = binary.BigEndian.Uint32(buf[16:20])
+		duration = binary.BigEndian.Uint64(buf[20:28])
+	} else {
+		buf := make([]byte, 16)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return 0, err
+		}
+		timescale = binary.BigEndian.Uint32(buf[8:12])
+		duration = uint64(binary.BigEndian.Uint32(buf[12:16]))
+	}
+
+	if timescale == 0 {
+		return 0, nil
+	}
+	return int64(duration * 1000 / uint64(timescale)), nil
+}
+
+func readTkhd(r io.Reader) (int, int, error) {
+	version := make([]byte, 4)
+	if _, err := io.ReadFull(r, version); err != nil {
+		return 0, 0, err
+	}
+
+	// Width and height are the last two 16.16 fixed point fields; what
+	// sits before them depends on whether times are 32 or 64 bit.
+	skip := 76
+	if version[0] == 1 {
+		skip = 88
+	}
+	buf := make([]byte, skip+8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, 0, err
+	}
+	width := int(binary.BigEndian.Uint32(buf[skip:skip+4]) >> 16)
+	height := int(binary.BigEndian.Uint32(buf[skip+4:skip+8]) >> 16)
+	return width, height, nil
+}
+
+// probeWAV derives duration from the fmt chunk's byte rate and the size of
+// the data chunk.
+func probeWAV(r io.ReadSeeker) (Metadata, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return Metadata{}, nil
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return Metadata{}, nil
+	}
+
+	var byteRate uint32
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return Metadata{}, nil
+		}
+		chunkSize := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch string(chunk[:4]) {
+		case "fmt ":
+			if chunkSize < 12 {
+				return Metadata{}, nil
+			}
+			fmtChunk := make([]byte, 12)
+			if _, err := io.ReadFull(r, fmtChunk); err != nil {
+				return Metadata{}, nil
+			}
+			byteRate = binary.LittleEndian.Uint32(fmtChunk[8:12])
+			chunkSize -= 12
+		case "data":
+			if byteRate == 0 {
+				return Metadata{}, nil
+			}
+			return Metadata{DurationMs: chunkSize * 1000 / int64(byteRate)}, nil
+		}
+
+		// Chunks are padded to an even length.
+		if _, err := r.Seek(chunkSize+chunkSize%2, io.SeekCurrent); err != nil {
+			return Metadata{}, nil
+		}
+	}
+}
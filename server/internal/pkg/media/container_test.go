@@ -0,0 +1,167 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func box(boxType string, children ...[]byte) []byte {
+	payload := bytes.Join(children, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(out, boxType...), payload...)
+}
+
+// largeBox uses the 64 bit size form.
+func largeBox(boxType string, payload []byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, 1)
+	out = append(out, boxType...)
+	out = binary.BigEndian.AppendUint64(out, uint64(16+len(payload)))
+	return append(out, payload...)
+}
+
+func sizedBox(size uint32, boxType string, payload []byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, size)
+	return append(append(out, boxType...), payload...)
+}
+
+func mvhd(version byte, timescale uint32, duration uint64) []byte {
+	payload := []byte{version, 0, 0, 0}
+	if version == 1 {
+		payload = append(payload, make([]byte, 16)...)
+		payload = binary.BigEndian.AppendUint32(payload, timescale)
+		payload = binary.BigEndian.AppendUint64(payload, duration)
+	} else {
+		payload = append(payload, make([]byte, 8)...)
+		payload = binary.BigEndian.AppendUint32(payload, timescale)
+		payload = binary.BigEndian.AppendUint32(payload, uint32(duration))
+	}
+	return box("mvhd", append(payload, make([]byte, 80)...))
+}
+
+func tkhd(version byte, width, height uint32) []byte {
+	skip := 76
+	if version == 1 {
+		skip = 88
+	}
+	payload := append([]byte{version, 0, 0, 0}, make([]byte, skip)...)
+	payload = binary.BigEndian.AppendUint32(payload, width<<16)
+	payload = binary.BigEndian.AppendUint32(payload, height<<16)
+	return box("tkhd", payload)
+}
+
+func TestProbeMP4(t *testing.T) {
+	ftyp := box("ftyp", []byte("isom\x00\x00\x02\x00"))
+	mdat := box("mdat", make([]byte, 64))
+	movie := box("moov", mvhd(0, 1000, 12345), box("trak", tkhd(0, 0, 0)), box("trak", tkhd(0, 1280, 720)))
+	full := bytes.Join([][]byte{ftyp, movie, mdat}, nil)
+
+	nested := box("trak", tkhd(0, 640, 480))
+	for range maxBoxDepth + 1 {
+		nested = box("moov", nested)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want Metadata
+	}{
+		{"full", full, Metadata{Width: 1280, Height: 720, DurationMs: 12345}},
+		{"version 1 headers", box("moov", mvhd(1, 90000, 90000*3), box("trak", tkhd(1, 320, 240))),
+			Metadata{Width: 320, Height: 240, DurationMs: 3000}},
+		{"large size box", largeBox("moov", mvhd(0, 600, 1200)), Metadata{DurationMs: 2000}},
+		{"size zero runs to the end", bytes.Join([][]byte{ftyp, sizedBox(0, "moov", mvhd(0, 10, 50))}, nil), Metadata{DurationMs: 5000}},
+		{"zero timescale", box("moov", mvhd(0, 0, 50)), Metadata{}},
+		{"empty", nil, Metadata{}},
+		{"shorter than a header", []byte{0, 0, 0}, Metadata{}},
+		{"truncated mvhd", full[:len(ftyp)+8+8+20], Metadata{}},
+		{"truncated file", full[:len(ftyp)+len(movie)-30], Metadata{}},
+		{"truncated tkhd", box("moov", mvhd(0, 1000, 12345), box("trak", box("tkhd", make([]byte, 20)))), Metadata{DurationMs: 12345}},
+		{"truncated large size", []byte("\x00\x00\x00\x01moov\x00\x00"), Metadata{}},
+		{"box past the end", sizedBox(1<<20, "moov", mvhd(0, 1000, 1000)), Metadata{}},
+		{"box smaller than its header", sizedBox(4, "moov", mvhd(0, 1000, 1000)), Metadata{}},
+		{"large size overflowing", append([]byte("\x00\x00\x00\x01moov\x7f\xff\xff\xff\xff\xff\xff\xff"), mvhd(0, 1000, 1000)...), Metadata{}},
+		{"nested past the depth limit", nested, Metadata{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Probe(bytes.NewReader(tt.data), "video/mp4")
+			if err != nil {
+				t.Fatalf("Probe() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("Probe() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func chunk(id string, payload []byte) []byte {
+	out := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+	out = append(out, payload...)
+	if len(payload)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func fmtChunk(byteRate uint32) []byte {
+	payload := []byte{1, 0, 2, 0}
+	payload = binary.LittleEndian.AppendUint32(payload, 44100)
+	payload = binary.LittleEndian.AppendUint32(payload, byteRate)
+	payload = append(payload, 4, 0, 16, 0)
+	return chunk("fmt ", payload)
+}
+
+func wav(chunks ...[]byte) []byte {
+	body := append([]byte("WAVE"), bytes.Join(chunks, nil)...)
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+func TestProbeWAV(t *testing.T) {
+	const byteRate = 176400
+	data := make([]byte, byteRate/2)
+
+	tests := []struct {
+		name string
+		data []byte
+		want Metadata
+	}{
+		{"pcm", wav(fmtChunk(byteRate), chunk("data", data)), Metadata{DurationMs: 500}},
+		{"odd sized chunk before data", wav(fmtChunk(byteRate), chunk("LIST", []byte("abc")), chunk("data", data)), Metadata{DurationMs: 500}},
+		{"data before fmt", wav(chunk("data", data), fmtChunk(byteRate)), Metadata{}},
+		{"zero byte rate", wav(fmtChunk(0), chunk("data", data)), Metadata{}},
+		{"no data chunk", wav(fmtChunk(byteRate)), Metadata{}},
+		{"empty", nil, Metadata{}},
+		{"not riff", append([]byte("RIFX"), wav(fmtChunk(byteRate))[4:]...), Metadata{}},
+		{"not wave", append(append([]byte("RIFF\x00\x00\x00\x00"), "AVI "...), fmtChunk(byteRate)...), Metadata{}},
+		{"truncated header", []byte("RIFF\x00\x00"), Metadata{}},
+		{"truncated chunk header", append(wav(fmtChunk(byteRate)), "dat"...), Metadata{}},
+		{"truncated fmt", wav(chunk("fmt ", []byte{1, 0, 2, 0}))[:20+4], Metadata{}},
+		{"fmt smaller than its fields", wav(chunk("fmt ", nil), chunk("data", data)), Metadata{}},
+		{"chunk past the end", wav(fmtChunk(byteRate), []byte("LIST\xff\xff\xff\x7f")), Metadata{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan struct{})
+			var got Metadata
+			var err error
+			go func() {
+				defer close(done)
+				got, err = Probe(bytes.NewReader(tt.data), "audio/wav")
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("Probe() did not return")
+			}
+			if err != nil {
+				t.Fatalf("Probe() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("Probe() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
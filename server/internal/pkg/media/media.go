@@ -0,0 +1,99 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"strings"
+
+	_ "image/gif"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// maxPixels caps how large an image we are willing to decode for a
+// thumbnail, so a tiny file claiming huge dimensions can't exhaust memory.
+const maxPixels = 50_000_000
+
+var ErrTooManyPixels = errors.New("image dimensions too large to thumbnail")
+
+// Metadata is what we can cheaply learn about a media file. Fields that
+// could not be derived are left at zero.
+type Metadata struct {
+	Width      int
+	Height     int
+	DurationMs int64
+}
+
+// Probe reads dimensions for images and duration (and, for video, frame
+// size) for the containers we know how to parse. Unknown formats are not
+// an error, they just yield empty metadata.
+func Probe(r io.ReadSeeker, mimeType string) (Metadata, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return Metadata{}, err
+	}
+
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		cfg, _, err := image.DecodeConfig(r)
+		if err != nil {
+			return Metadata{}, nil
+		}
+		return Metadata{Width: cfg.Width, Height: cfg.Height}, nil
+	case isMP4(mimeType):
+		return probeMP4(r)
+	case mimeType == "audio/wav" || mimeType == "audio/x-wav":
+		return probeWAV(r)
+	}
+
+	return Metadata{}, nil
+}
+
+// Thumbnail decodes an image and returns a JPEG scaled to fit within
+// maxDim on its longest side, along with the thumbnail's dimensions.
+// Images already smaller than maxDim are re-encoded at their own size.
+func Thumbnail(r io.ReadSeeker, maxDim int) ([]byte, int, int, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, 0, err
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, 0, 0, ErrTooManyPixels
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, 0, err
+	}
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	width, height := fit(src.Bounds().Dx(), src.Bounds().Dy(), maxDim)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, 0, 0, err
+	}
+	return buf.Bytes(), width, height, nil
+}
+
+func fit(width, height, maxDim int) (int, int) {
+	if width <= maxDim && height <= maxDim {
+		return width, height
+	}
+	if width >= height {
+		return maxDim, max(1, height*maxDim/width)
+	}
+	return max(1, width*maxDim/height), maxDim
+}
@@ -0,0 +1,328 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+type OverflowPolicy string
+
+const (
+	// DropOnOverflow discards the event and keeps the client connected.
+	DropOnOverflow OverflowPolicy = "drop"
+	// DisconnectOnOverflow closes the client so it can reconnect and resync.
+	DisconnectOnOverflow OverflowPolicy = "disconnect"
+	// SpillOnOverflow hands the event to Options.OnSpill for offline storage.
+	SpillOnOverflow OverflowPolicy = "spill"
+)
+
+var (
+	ErrClientClosed  = errors.New("client connection closed")
+	ErrSendQueueFull = errors.New("client send queue full")
+)
+
+func ParseOverflowPolicy(policy string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(policy); p {
+	case DropOnOverflow, DisconnectOnOverflow, SpillOnOverflow:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown slow consumer policy %q", policy)
+}
+
+type DeviceInfo struct {
+	DeviceID    string    `json:"device_id"`
+	UserAgent   string    `json:"user_agent"`
+	ConnectedAt time.Time `json:"connected_at"`
+	LastSeen    time.Time `json:"last_seen"`
+	Away        bool      `json:"away"`
+}
+
+type Options struct {
+	SendQueueSize int
+	WriteTimeout  time.Duration
+	// PongWait is how long the connection may stay silent before reads fail.
+	// PingInterval must be shorter so a healthy peer always answers in time.
+	PongWait     time.Duration
+	PingInterval time.Duration
+	// MaxMessageSize caps an incoming frame in bytes. A bigger one fails the
+	// read and closes the connection with a message too big close frame.
+	MaxMessageSize int64
+	Policy         OverflowPolicy
+	OnSpill        func(c *Client, event Event)
+}
+
+// Client owns a websocket connection. gorilla/websocket supports one
+// concurrent writer only, so every write goes through the send queue and is
+// performed by the goroutine running WritePump.
+type Client struct {
+	UserID      uuid.UUID
+	DeviceID    string
+	SessionID   string
+	UserAgent   string
+	ConnectedAt time.Time
+
+	conn      *websocket.Conn
+	opts      Options
+	send      chan Event
+	done      chan struct{}
+	closeOnce sync.Once
+	closeText string
+	lastSeen  atomic.Int64
+	away      atomic.Bool
+
+	limitsMu sync.Mutex
+	limits   map[string]limit
+
+	cacheMu sync.Mutex
+	cache   map[string]cacheEntry
+}
+
+// limit is when a throttled action last ran and until when it stays
+// throttled.
+type limit struct {
+	last  time.Time
+	until time.Time
+}
+
+type cacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+func NewClient(userID uuid.UUID, deviceID string, conn *websocket.Conn, opts Options) *Client {
+	if opts.SendQueueSize <= 0 {
+		opts.SendQueueSize = 256
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = 10 * time.Second
+	}
+	if opts.PongWait <= 0 {
+		opts.PongWait = 60 * time.Second
+	}
+	if opts.PingInterval <= 0 || opts.PingInterval >= opts.PongWait {
+		opts.PingInterval = opts.PongWait * 9 / 10
+	}
+	if opts.MaxMessageSize <= 0 {
+		opts.MaxMessageSize = 64 << 10
+	}
+
+	c := &Client{
+		UserID:      userID,
+		DeviceID:    deviceID,
+		ConnectedAt: time.Now().UTC(),
+		conn:        conn,
+		opts:        opts,
+		send:        make(chan Event, opts.SendQueueSize),
+		done:        make(chan struct{}),
+	}
+	c.touch()
+
+	conn.SetReadLimit(opts.MaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(opts.PongWait))
+	conn.SetPongHandler(func(string) error {
+		c.touch()
+		return conn.SetReadDeadline(time.Now().Add(opts.PongWait))
+	})
+
+	return c
+}
+
+func (c *Client) Info() DeviceInfo {
+	return DeviceInfo{
+		DeviceID:    c.DeviceID,
+		UserAgent:   c.UserAgent,
+		ConnectedAt: c.ConnectedAt,
+		LastSeen:    c.LastSeen(),
+		Away:        c.Away(),
+	}
+}
+
+// Away is set by the client itself, e.g. when its window loses focus.
+func (c *Client) Away() bool {
+	return c.away.Load()
+}
+
+func (c *Client) SetAway(away bool) {
+	c.away.Store(away)
+}
+
+// Allow reports whether the action identified by key may run now, letting
+// it through at most once per interval for this client. Keys whose interval
+// passed are dropped, so the map only holds the ones currently throttled.
+func (c *Client) Allow(key string, interval time.Duration) bool {
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+
+	now := time.Now()
+	if l, ok := c.limits[key]; ok && now.Sub(l.last) < interval {
+		return false
+	}
+	if c.limits == nil {
+		c.limits = map[string]limit{}
+	}
+	for k, l := range c.limits {
+		if !now.Before(l.until) {
+			delete(c.limits, k)
+		}
+	}
+	c.limits[key] = limit{last: now, until: now.Add(interval)}
+	return true
+}
+
+// Cached returns the value kept under key, calling load for a fresh one
+// once it is older than ttl. Failed loads aren't kept. The cache lives as
+// long as the connection, so a reconnect always starts over.
+func (c *Client) Cached(key string, ttl time.Duration, load func() (any, error)) (any, error) {
+	c.cacheMu.Lock()
+	entry, ok := c.cache[key]
+	c.cacheMu.Unlock()
+	now := time.Now()
+	if ok && now.Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+	if c.cache == nil {
+		c.cache = map[string]cacheEntry{}
+	}
+	for k, entry := range c.cache {
+		if !now.Before(entry.expires) {
+			delete(c.cache, k)
+		}
+	}
+	c.cache[key] = cacheEntry{value: value, expires: now.Add(ttl)}
+	return value, nil
+}
+
+// LastSeen is the last time anything, data or pong, was read from the peer.
+func (c *Client) LastSeen() time.Time {
+	return time.Unix(0, c.lastSeen.Load()).UTC()
+}
+
+func (c *Client) touch() {
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+// Send queues an event without blocking. When the queue is full the
+// configured overflow policy decides what happens to the event.
+func (c *Client) Send(event Event) error {
+	select {
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+
+	select {
+	case c.send <- event:
+		return nil
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+
+	switch c.opts.Policy {
+	case DisconnectOnOverflow:
+		log.Println("disconnecting slow consumer", c.UserID)
+		c.Close()
+	case SpillOnOverflow:
+		if c.opts.OnSpill != nil {
+			c.opts.OnSpill(c, event)
+			break
+		}
+		log.Println("no spill handler configured, dropping event for", c.UserID)
+	case DropOnOverflow:
+		log.Println("send queue full, dropping event for", c.UserID)
+	}
+
+	return ErrSendQueueFull
+}
+
+// SendWait queues an event, blocking while the queue is full. It is meant
+// for backlog replays that must not trip the overflow policy.
+func (c *Client) SendWait(event Event) error {
+	select {
+	case c.send <- event:
+		return nil
+	case <-c.done:
+		return ErrClientClosed
+	}
+}
+
+// WritePump drains the send queue until the client is closed. It must be
+// the only goroutine writing to the connection and it owns closing it.
+func (c *Client) WritePump() {
+	ticker := time.NewTicker(c.opts.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.Close()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Println("ping failed for user", c.UserID, err)
+				return
+			}
+		case event := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout))
+			if err := c.conn.WriteJSON(&event); err != nil {
+				log.Println("write failed for user", c.UserID, err)
+				return
+			}
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout))
+			code := websocket.CloseNormalClosure
+			if c.closeText != "" {
+				code = websocket.ClosePolicyViolation
+			}
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, c.closeText))
+			return
+		}
+	}
+}
+
+func (c *Client) ReadMessage() ([]byte, error) {
+	_, frame, err := c.conn.ReadMessage()
+	if err == nil {
+		c.touch()
+		c.conn.SetReadDeadline(time.Now().Add(c.opts.PongWait))
+	}
+	return frame, err
+}
+
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close signals the write pump to send a close frame and tear down the
+// connection. It is safe to call more than once and from any goroutine.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// CloseWithReason closes the connection as a policy violation, telling the
+// peer why in the close frame.
+func (c *Client) CloseWithReason(text string) {
+	c.closeOnce.Do(func() {
+		c.closeText = text
+		close(c.done)
+	})
+}
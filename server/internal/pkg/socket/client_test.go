@@ -0,0 +1,281 @@
+package socket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// newTestClient upgrades a loopback connection and wraps the server side of
+// it with opts. The returned conn is the peer's end.
+func newTestClient(t *testing.T, opts Options) (*Client, *websocket.Conn) {
+	t.Helper()
+	clients := make(chan *Client, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(rw, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		clients <- NewClient(uuid.New(), "device", conn, opts)
+	}))
+	t.Cleanup(server.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	client := <-clients
+	t.Cleanup(client.Close)
+	return client, peer
+}
+
+func TestReadLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		wantErr error
+	}{
+		{"at the limit", 1024, nil},
+		{"over the limit", 1025, websocket.ErrReadLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, peer := newTestClient(t, Options{MaxMessageSize: 1024})
+			go client.WritePump()
+
+			if err := peer.WriteMessage(websocket.TextMessage, make([]byte, tt.size)); err != nil {
+				t.Fatal(err)
+			}
+			frame, err := client.ReadMessage()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadMessage() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if len(frame) != tt.size {
+					t.Fatalf("read %d bytes, want %d", len(frame), tt.size)
+				}
+				return
+			}
+
+			peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, _, err = peer.ReadMessage()
+			if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+				t.Fatalf("peer got %v, want a message too big close", err)
+			}
+		})
+	}
+}
+
+func TestReadLimitDefault(t *testing.T) {
+	client, peer := newTestClient(t, Options{})
+	if err := peer.WriteMessage(websocket.TextMessage, make([]byte, 64<<10+1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReadMessage(); !errors.Is(err, websocket.ErrReadLimit) {
+		t.Fatalf("ReadMessage() error = %v, want %v", err, websocket.ErrReadLimit)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	client, _ := newTestClient(t, Options{PongWait: 100 * time.Millisecond})
+
+	start := time.Now()
+	_, err := client.ReadMessage()
+	var netErr interface{ Timeout() bool }
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("ReadMessage() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("silent peer dropped after %s", elapsed)
+	}
+}
+
+// TestHeartbeatKeepsAlive checks that a peer answering pings outlives the
+// pong wait, and that the pongs count as activity.
+func TestHeartbeatKeepsAlive(t *testing.T) {
+	client, peer := newTestClient(t, Options{PongWait: 200 * time.Millisecond, PingInterval: 50 * time.Millisecond})
+	go client.WritePump()
+	// the peer only answers pings while it reads
+	go func() {
+		for {
+			if _, _, err := peer.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := client.ReadMessage()
+		read <- err
+	}()
+
+	before := client.LastSeen()
+	time.Sleep(600 * time.Millisecond)
+	if !client.LastSeen().After(before) {
+		t.Fatal("pongs did not update last seen")
+	}
+	if err := peer.WriteMessage(websocket.TextMessage, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-read; err != nil {
+		t.Fatalf("connection dropped despite answering pings: %v", err)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	client := &Client{}
+	if !client.Allow("typing", time.Hour) {
+		t.Fatal("first call throttled")
+	}
+	if client.Allow("typing", time.Hour) {
+		t.Fatal("second call inside the interval let through")
+	}
+	if !client.Allow("presence", time.Hour) {
+		t.Fatal("keys throttle each other")
+	}
+	if !client.Allow("typing", 0) {
+		t.Fatal("call past the interval throttled")
+	}
+}
+
+// TestAllowForgets checks keys are let go once their interval passed, so
+// throttling many keys doesn't pile up state.
+func TestAllowForgets(t *testing.T) {
+	client := &Client{}
+	for i := range 100 {
+		client.Allow("typing:"+strconv.Itoa(i), 0)
+	}
+	if len(client.limits) != 1 {
+		t.Fatalf("%d keys kept, want only the last", len(client.limits))
+	}
+}
+
+func TestCached(t *testing.T) {
+	client := &Client{}
+	loads := 0
+	load := func() (any, error) {
+		loads++
+		return loads, nil
+	}
+	failing := func() (any, error) { return nil, errors.New("lookup failed") }
+
+	tests := []struct {
+		name      string
+		key       string
+		ttl       time.Duration
+		load      func() (any, error)
+		want      any
+		wantErr   bool
+		wantLoads int
+	}{
+		{"first load", "a", time.Hour, load, 1, false, 1},
+		{"kept", "a", time.Hour, load, 1, false, 1},
+		{"other key", "b", 0, load, 2, false, 2},
+		{"expired", "b", time.Hour, load, 3, false, 3},
+		{"failure", "c", time.Hour, failing, nil, true, 3},
+		{"failure not kept", "c", time.Hour, load, 4, false, 4},
+	}
+	for _, tt := range tests {
+		got, err := client.Cached(tt.key, tt.ttl, tt.load)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: Cached() error = %v", tt.name, err)
+		}
+		if got != tt.want || loads != tt.wantLoads {
+			t.Fatalf("%s: Cached() = %v after %d loads, want %v after %d", tt.name, got, loads, tt.want, tt.wantLoads)
+		}
+	}
+}
+
+func TestSendPolicies(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      OverflowPolicy
+		wantClosed  bool
+		wantSpilled bool
+	}{
+		{"drop", DropOnOverflow, false, false},
+		{"disconnect", DisconnectOnOverflow, true, false},
+		{"spill", SpillOnOverflow, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var spilled []Event
+			client, _ := newTestClient(t, Options{
+				SendQueueSize: 1,
+				Policy:        tt.policy,
+				OnSpill:       func(_ *Client, event Event) { spilled = append(spilled, event) },
+			})
+
+			// nothing drains the queue, the second event overflows it
+			if err := client.Send(Event{Type: "first"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.Send(Event{Type: "second"}); !errors.Is(err, ErrSendQueueFull) {
+				t.Fatalf("Send() error = %v, want %v", err, ErrSendQueueFull)
+			}
+
+			closed := false
+			select {
+			case <-client.Done():
+				closed = true
+			default:
+			}
+			if closed != tt.wantClosed {
+				t.Fatalf("closed = %v, want %v", closed, tt.wantClosed)
+			}
+			if (len(spilled) == 1 && spilled[0].Type == "second") != tt.wantSpilled {
+				t.Fatalf("spilled %v, want spilled = %v", spilled, tt.wantSpilled)
+			}
+		})
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, policy := range []string{"drop", "disconnect", "spill"} {
+		if got, err := ParseOverflowPolicy(policy); err != nil || string(got) != policy {
+			t.Errorf("ParseOverflowPolicy(%q) = %q, %v", policy, got, err)
+		}
+	}
+	for _, policy := range []string{"", "Drop", "block"} {
+		if _, err := ParseOverflowPolicy(policy); err == nil {
+			t.Errorf("ParseOverflowPolicy(%q) accepted", policy)
+		}
+	}
+}
+
+func TestWritePumpDelivers(t *testing.T) {
+	client, peer := newTestClient(t, Options{})
+	go client.WritePump()
+
+	for i := range 5 {
+		if err := client.Send(Event{Type: "message", ID: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := range 5 {
+		var event Event
+		if err := peer.ReadJSON(&event); err != nil {
+			t.Fatal(err)
+		}
+		if event.ID != strconv.Itoa(i) {
+			t.Fatalf("event %d has id %s, want the order sent", i, event.ID)
+		}
+	}
+
+	client.Close()
+	if err := client.Send(Event{Type: "message"}); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("Send() after Close error = %v, want %v", err, ErrClientClosed)
+	}
+}
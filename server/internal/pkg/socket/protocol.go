@@ -0,0 +1,68 @@
+package socket
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Subprotocol is the one the server speaks. A browser can offer its access
+// token as a second subprotocol, bearer.<token>, next to it; the server
+// only ever selects Subprotocol so the token isn't echoed back.
+const (
+	Subprotocol       = "schat"
+	BearerProtocolTag = "bearer."
+)
+
+const (
+	EventConnected       = "connected"
+	EventDisconnected    = "disconnected"
+	EventPrivateMessage  = "private_message"
+	EventGroupMessage    = "group_message"
+	EventGroupCreate     = "group_create"
+	EventGroupMembership = "group_membership"
+	EventReceipt         = "receipt"
+	EventMessageEdit     = "message_edit"
+	EventMessageDelete   = "message_delete"
+	EventGroupMute       = "group_mute"
+	EventReactionAdd     = "reaction_add"
+	EventReactionRemove  = "reaction_remove"
+	EventTyping          = "typing"
+	EventPresence        = "presence"
+	EventSessionRevoked  = "session_revoked" // between instances, closes the session's sockets
+	EventResync          = "resync"          // events were missed, catch up through the sync endpoints
+	EventError           = "error"
+)
+
+// Envelope is the frame every client sends over the socket. ID is chosen by
+// the client and echoed back on the reply so requests can be correlated.
+type Envelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Event is the frame the server writes. Replies carry the ID of the envelope
+// they answer, pushed notifications leave it empty. Silent events should
+// update the client's state without alerting the user.
+type Event struct {
+	Type         string `json:"type"`
+	ID           string `json:"id,omitempty"`
+	StatusCode   int    `json:"status_code"`
+	ErrorMessage string `json:"error_msg,omitempty"`
+	Silent       bool   `json:"silent,omitempty"`
+	Payload      any    `json:"payload,omitempty"`
+}
+
+// ProtocolToken is the access token offered in Sec-WebSocket-Protocol, if
+// any.
+func ProtocolToken(r *http.Request) string {
+	for _, protocol := range websocket.Subprotocols(r) {
+		if token, ok := strings.CutPrefix(protocol, BearerProtocolTag); ok {
+			return token
+		}
+	}
+	return ""
+}
@@ -0,0 +1,33 @@
+package socket
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestProtocolToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocols string
+		want      string
+	}{
+		{"none", "", ""},
+		{"plain", Subprotocol, ""},
+		{"bearer", Subprotocol + ", " + BearerProtocolTag + "abc.def.ghi", "abc.def.ghi"},
+		{"bearer first", BearerProtocolTag + "abc.def.ghi, " + Subprotocol, "abc.def.ghi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/ws", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.protocols != "" {
+				r.Header.Set("Sec-WebSocket-Protocol", tt.protocols)
+			}
+			if got := ProtocolToken(r); got != tt.want {
+				t.Fatalf("ProtocolToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,23 +2,41 @@ package store
 
 import (
 	"errors"
+	"log"
+	"sort"
 	"sync"
+	"time"
+
+	"shiplabs/schat/internal/pkg/config"
+	"shiplabs/schat/internal/pkg/socket"
 
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 )
 
 var WebsocketStore *wsStore
 
+// wsStore tracks every live client of a user, keyed by device ID, so a
+// phone and a laptop can be connected at the same time.
 type wsStore struct {
-	data map[uuid.UUID]*websocket.Conn
-	mu   sync.Mutex
+	data  map[uuid.UUID]map[string]*socket.Client
+	mu    sync.RWMutex
+	hooks []DisconnectHook
 }
 
+// DisconnectHook is called once for every client that leaves the store,
+// whether it closed cleanly, was kicked or was reaped as stale.
+type DisconnectHook func(client *socket.Client)
+
 type ConnectionStoreInterface interface {
-	GetConn(userID uuid.UUID) (*websocket.Conn, error)
-	SaveConn(userID uuid.UUID, conn *websocket.Conn)
-	DeleteConn(userID uuid.UUID)
+	GetClients(userID uuid.UUID) []*socket.Client
+	GetClient(userID uuid.UUID, deviceID string) (*socket.Client, error)
+	SaveClient(client *socket.Client)
+	RemoveClient(client *socket.Client)
+	ListDevices(userID uuid.UUID) []socket.DeviceInfo
+	DisconnectDevice(userID uuid.UUID, deviceID string) error
+	DisconnectUser(userID uuid.UUID)
+	OnDisconnect(hook DisconnectHook)
+	StartReaper(interval, staleAfter time.Duration) (stop func())
 }
 
 var (
@@ -27,38 +45,157 @@ var (
 
 func NewWsStore() ConnectionStoreInterface {
 	return &wsStore{
-		data: map[uuid.UUID]*websocket.Conn{},
-		mu:   sync.Mutex{},
+		data: map[uuid.UUID]map[string]*socket.Client{},
+		mu:   sync.RWMutex{},
 	}
 }
 
-func (s *wsStore) GetConn(userID uuid.UUID) (*websocket.Conn, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	c, ok := s.data[userID]
+func (s *wsStore) GetClients(userID uuid.UUID) []*socket.Client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	clients := make([]*socket.Client, 0, len(s.data[userID]))
+	for _, c := range s.data[userID] {
+		clients = append(clients, c)
+	}
+
+	return clients
+}
+
+func (s *wsStore) GetClient(userID uuid.UUID, deviceID string) (*socket.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.data[userID][deviceID]
 	if ok {
 		return c, nil
 	}
 
-	return c, ErrConnNotFound
+	return nil, ErrConnNotFound
 }
 
-func (s *wsStore) SaveConn(userID uuid.UUID, conn *websocket.Conn) {
+// SaveClient registers the client under its device ID. A previous
+// connection from the same device is closed and replaced.
+func (s *wsStore) SaveClient(client *socket.Client) {
 	s.mu.Lock()
-	s.data[userID] = conn
+	defer s.mu.Unlock()
+	devices, ok := s.data[client.UserID]
+	if !ok {
+		devices = map[string]*socket.Client{}
+		s.data[client.UserID] = devices
+	}
+	if old, ok := devices[client.DeviceID]; ok && old != client {
+		old.Close()
+	}
+	devices[client.DeviceID] = client
+}
+
+// RemoveClient closes the client and forgets it, unless the device has
+// already reconnected with a newer client.
+func (s *wsStore) RemoveClient(client *socket.Client) {
+	client.Close()
+
+	s.mu.Lock()
+	devices := s.data[client.UserID]
+	current, removed := devices[client.DeviceID]
+	removed = removed && current == client
+	if removed {
+		delete(devices, client.DeviceID)
+	}
+	if len(devices) == 0 {
+		delete(s.data, client.UserID)
+	}
+	hooks := s.hooks
 	s.mu.Unlock()
+
+	if removed {
+		for _, hook := range hooks {
+			hook(client)
+		}
+	}
+}
+
+func (s *wsStore) ListDevices(userID uuid.UUID) []socket.DeviceInfo {
+	clients := s.GetClients(userID)
+	devices := make([]socket.DeviceInfo, 0, len(clients))
+	for _, c := range clients {
+		devices = append(devices, c.Info())
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ConnectedAt.Before(devices[j].ConnectedAt)
+	})
+
+	return devices
+}
+
+func (s *wsStore) DisconnectDevice(userID uuid.UUID, deviceID string) error {
+	client, err := s.GetClient(userID, deviceID)
+	if err != nil {
+		return err
+	}
+	s.RemoveClient(client)
+
+	return nil
 }
 
-func (s *wsStore) DeleteConn(userID uuid.UUID) {
+func (s *wsStore) DisconnectUser(userID uuid.UUID) {
+	for _, client := range s.GetClients(userID) {
+		s.RemoveClient(client)
+	}
+}
+
+func (s *wsStore) OnDisconnect(hook DisconnectHook) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	conn, ok := s.data[userID]
-	if ok {
-		conn.Close()
+	s.hooks = append(s.hooks, hook)
+}
+
+// StartReaper periodically removes clients nothing has been read from for
+// staleAfter. Read deadlines catch most dead peers, the reaper is the
+// safety net for half-open connections that never fail a read.
+func (s *wsStore) StartReaper(interval, staleAfter time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				s.reap(staleAfter)
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+func (s *wsStore) reap(staleAfter time.Duration) {
+	cutoff := time.Now().Add(-staleAfter)
+
+	var stale []*socket.Client
+	s.mu.RLock()
+	for _, devices := range s.data {
+		for _, c := range devices {
+			if c.LastSeen().Before(cutoff) {
+				stale = append(stale, c)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, c := range stale {
+		log.Println("reaping stale connection for user", c.UserID, "device", c.DeviceID)
+		s.RemoveClient(c)
 	}
-	delete(s.data, userID)
 }
 
 func InitStore() {
 	WebsocketStore = NewWsStore().(*wsStore)
+
+	wsConfig := config.Configs.WS
+	WebsocketStore.StartReaper(wsConfig.ReaperInterval, wsConfig.PongWait+wsConfig.WriteTimeout)
 }
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"shiplabs/schat/internal/pkg/socket"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// newTestClient wraps the server side of a loopback connection.
+func newTestClient(t *testing.T, userID uuid.UUID, deviceID string) *socket.Client {
+	t.Helper()
+	clients := make(chan *socket.Client, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(rw, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		clients <- socket.NewClient(userID, deviceID, conn, socket.Options{})
+	}))
+	t.Cleanup(server.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	return <-clients
+}
+
+func closed(client *socket.Client) bool {
+	select {
+	case <-client.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// disconnects records the clients the store's hooks were called for.
+type disconnects struct {
+	mu      sync.Mutex
+	clients []*socket.Client
+}
+
+func (d *disconnects) hook(client *socket.Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clients = append(d.clients, client)
+}
+
+func (d *disconnects) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.clients)
+}
+
+func TestStoreMultiDevice(t *testing.T) {
+	s := NewWsStore()
+	userID := uuid.New()
+	phone := newTestClient(t, userID, "phone")
+	laptop := newTestClient(t, userID, "laptop")
+	s.SaveClient(phone)
+	s.SaveClient(laptop)
+
+	if got := len(s.GetClients(userID)); got != 2 {
+		t.Fatalf("GetClients() returned %d clients, want 2", got)
+	}
+	if got, err := s.GetClient(userID, "laptop"); err != nil || got != laptop {
+		t.Fatalf("GetClient(laptop) = %v, %v", got, err)
+	}
+	devices := s.ListDevices(userID)
+	if len(devices) != 2 || devices[0].DeviceID != "phone" {
+		t.Fatalf("ListDevices() = %+v, want phone then laptop", devices)
+	}
+	if _, err := s.GetClient(uuid.New(), "phone"); !errors.Is(err, ErrConnNotFound) {
+		t.Fatalf("GetClient() of another user error = %v, want %v", err, ErrConnNotFound)
+	}
+}
+
+func TestStoreReconnectReplaces(t *testing.T) {
+	s := NewWsStore()
+	var hooked disconnects
+	s.OnDisconnect(hooked.hook)
+	userID := uuid.New()
+	old := newTestClient(t, userID, "phone")
+	current := newTestClient(t, userID, "phone")
+
+	s.SaveClient(old)
+	s.SaveClient(current)
+	if !closed(old) {
+		t.Fatal("replaced connection left open")
+	}
+	if clients := s.GetClients(userID); len(clients) != 1 || clients[0] != current {
+		t.Fatalf("GetClients() = %v, want only the new connection", clients)
+	}
+
+	// the old connection's read loop ending must not drop the new one
+	s.RemoveClient(old)
+	if got, err := s.GetClient(userID, "phone"); err != nil || got != current {
+		t.Fatalf("GetClient() after removing the old connection = %v, %v", got, err)
+	}
+	if hooked.count() != 0 {
+		t.Fatalf("disconnect hooks ran %d times for a replaced connection", hooked.count())
+	}
+
+	s.RemoveClient(current)
+	s.RemoveClient(current)
+	if hooked.count() != 1 {
+		t.Fatalf("disconnect hooks ran %d times, want once", hooked.count())
+	}
+	if len(s.GetClients(userID)) != 0 {
+		t.Fatal("removed connection still listed")
+	}
+}
+
+func TestStoreDisconnect(t *testing.T) {
+	s := NewWsStore()
+	var hooked disconnects
+	s.OnDisconnect(hooked.hook)
+	userID := uuid.New()
+	phone := newTestClient(t, userID, "phone")
+	laptop := newTestClient(t, userID, "laptop")
+	s.SaveClient(phone)
+	s.SaveClient(laptop)
+
+	if err := s.DisconnectDevice(userID, "tablet"); !errors.Is(err, ErrConnNotFound) {
+		t.Fatalf("DisconnectDevice(tablet) error = %v, want %v", err, ErrConnNotFound)
+	}
+	if err := s.DisconnectDevice(userID, "phone"); err != nil {
+		t.Fatal(err)
+	}
+	if !closed(phone) || closed(laptop) {
+		t.Fatal("DisconnectDevice() closed the wrong connections")
+	}
+
+	s.DisconnectUser(userID)
+	if !closed(laptop) || len(s.GetClients(userID)) != 0 {
+		t.Fatal("DisconnectUser() left a connection behind")
+	}
+	if hooked.count() != 2 {
+		t.Fatalf("disconnect hooks ran %d times, want 2", hooked.count())
+	}
+}
+
+func TestStoreReap(t *testing.T) {
+	s := NewWsStore().(*wsStore)
+	userID := uuid.New()
+	client := newTestClient(t, userID, "phone")
+	s.SaveClient(client)
+
+	s.reap(time.Hour)
+	if closed(client) {
+		t.Fatal("live connection reaped")
+	}
+	time.Sleep(10 * time.Millisecond)
+	s.reap(time.Millisecond)
+	if !closed(client) || len(s.GetClients(userID)) != 0 {
+		t.Fatal("stale connection not reaped")
+	}
+}
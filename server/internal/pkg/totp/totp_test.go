@@ -0,0 +1,130 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 appendix B, "12345678901234567890"
+// in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, trimmed to the last six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr bool
+	}{
+		{"lowercase", strings.ToLower(rfcSecret), false},
+		{"empty", "", true},
+		{"not base32", "not-base32!", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Code(tt.secret, 1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Code() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	at := time.Unix(1111111109, 0)
+	current := Step(at)
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		skew     int
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", code(current), 0, current, true},
+		{"previous step within skew", code(current - 1), 1, current - 1, true},
+		{"next step within skew", code(current + 1), 1, current + 1, true},
+		{"previous step without skew", code(current - 1), 0, 0, false},
+		{"beyond skew", code(current - 2), 1, 0, false},
+		{"wrong code", "000000", 1, 0, false},
+		{"short code", code(current)[:5], 1, 0, false},
+		{"long code", code(current) + "0", 1, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, at, tt.skew)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Fatalf("Validate() = (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+// TestValidateReplay checks a code reports the same step however late in
+// its skew window it is presented, which is what callers compare against
+// the last used step to refuse a replay.
+func TestValidateReplay(t *testing.T) {
+	at := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, Step(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, ok := Validate(rfcSecret, code, at, 1)
+	if !ok {
+		t.Fatal("code rejected on first use")
+	}
+	replayed, ok := Validate(rfcSecret, code, at.Add(Period*time.Second), 1)
+	if !ok {
+		t.Fatal("code rejected inside the skew window")
+	}
+	if replayed != first {
+		t.Fatalf("replayed step = %d, want %d", replayed, first)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Schat", "ada@example.com", rfcSecret)
+	for _, want := range []string{
+		"otpauth://totp/Schat:ada@example.com?",
+		"secret=" + rfcSecret,
+		"issuer=Schat",
+		"digits=6",
+		"period=30",
+	} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("URI() = %s, missing %s", uri, want)
+		}
+	}
+}
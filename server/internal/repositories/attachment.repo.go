@@ -0,0 +1,33 @@
+package repos
+
+import (
+	"shiplabs/schat/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type attachmentRepo struct {
+	DB gorm.DB
+}
+
+type AttachmentRepoInterface interface {
+	Create(attachment *models.Attachment) error
+	FindByID(id uuid.UUID) (models.Attachment, error)
+}
+
+func NewAttachmentRepo(db gorm.DB) AttachmentRepoInterface {
+	return &attachmentRepo{
+		DB: db,
+	}
+}
+
+func (a *attachmentRepo) Create(attachment *models.Attachment) error {
+	return a.DB.Create(attachment).Error
+}
+
+func (a *attachmentRepo) FindByID(id uuid.UUID) (models.Attachment, error) {
+	var attachment models.Attachment
+	err := a.DB.Where("id=?", id).First(&attachment).Error
+	return attachment, err
+}
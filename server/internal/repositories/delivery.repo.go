@@ -0,0 +1,132 @@
+package repos
+
+import (
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/pkg/shared"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type deliveryRepo struct {
+	DB gorm.DB
+}
+
+type DeliveryRepoInterface interface {
+	BeginDBTx() *gorm.DB
+	CreateDeliveries(txn *gorm.DB, deliveries []models.MessageDelivery) error
+	GetUndelivered(recipientID uuid.UUID, limit int) ([]models.MessageDelivery, error)
+	FindDelivery(messageID, recipientID uuid.UUID) (models.MessageDelivery, error)
+	GetMessageDeliveries(messageID uuid.UUID) ([]models.MessageDelivery, error)
+	MarkDelivered(recipientID uuid.UUID, messageIDs []uuid.UUID) ([]models.MessageDelivery, error)
+	MarkUpTo(txn *gorm.DB, upTo models.MessageDelivery, status models.ReceiptStatus) ([]models.MessageDelivery, error)
+	CountUnread(recipientID uuid.UUID) (map[uuid.UUID]int64, error)
+}
+
+func NewDeliveryRepo(db gorm.DB) DeliveryRepoInterface {
+	return &deliveryRepo{
+		DB: db,
+	}
+}
+
+func (d *deliveryRepo) BeginDBTx() *gorm.DB {
+	return d.DB.Begin()
+}
+
+func (d *deliveryRepo) CreateDeliveries(txn *gorm.DB, deliveries []models.MessageDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if txn == nil {
+		return d.DB.Create(&deliveries).Error
+	}
+	return txn.Create(&deliveries).Error
+}
+
+func (d *deliveryRepo) GetUndelivered(recipientID uuid.UUID, limit int) ([]models.MessageDelivery, error) {
+	var deliveries []models.MessageDelivery
+	err := d.DB.Where("recipient_id=? AND delivered_at IS NULL", recipientID).
+		Order("created_at ASC, id ASC").
+		Limit(limit).
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (d *deliveryRepo) FindDelivery(messageID, recipientID uuid.UUID) (models.MessageDelivery, error) {
+	var delivery models.MessageDelivery
+	err := d.DB.Where("message_id=? AND recipient_id=?", messageID, recipientID).First(&delivery).Error
+	return delivery, err
+}
+
+func (d *deliveryRepo) GetMessageDeliveries(messageID uuid.UUID) ([]models.MessageDelivery, error) {
+	var deliveries []models.MessageDelivery
+	err := d.DB.Where("message_id=?", messageID).Order("recipient_id").Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (d *deliveryRepo) MarkDelivered(recipientID uuid.UUID, messageIDs []uuid.UUID) ([]models.MessageDelivery, error) {
+	var updated []models.MessageDelivery
+	if len(messageIDs) == 0 {
+		return updated, nil
+	}
+	err := d.DB.Model(&updated).
+		Clauses(clause.Returning{}).
+		Where("recipient_id=? AND message_id IN ? AND delivered_at IS NULL", recipientID, messageIDs).
+		Update("delivered_at", shared.TimeNow()).Error
+	return updated, err
+}
+
+// MarkUpTo acknowledges every receipt of the recipient in the same
+// conversation that is not newer than upTo. Reading implies delivery.
+func (d *deliveryRepo) MarkUpTo(txn *gorm.DB, upTo models.MessageDelivery, status models.ReceiptStatus) ([]models.MessageDelivery, error) {
+	var updated []models.MessageDelivery
+	now := shared.TimeNow()
+	if txn == nil {
+		txn = &d.DB
+	}
+
+	query := txn.Model(&updated).
+		Clauses(clause.Returning{}).
+		Where("recipient_id=? AND conversation_id=? AND created_at <= ?", upTo.RecipientID, upTo.ConversationID, upTo.CreatedAt)
+
+	var err error
+	if status == models.Read {
+		err = query.Where("read_at IS NULL").Updates(map[string]any{
+			"read_at":      now,
+			"delivered_at": gorm.Expr("COALESCE(delivered_at, ?)", now),
+		}).Error
+	} else {
+		err = query.Where("delivered_at IS NULL").Update("delivered_at", now).Error
+	}
+
+	return updated, err
+}
+
+// CountUnread returns the number of unread messages per conversation. Only
+// messages the recipient still sees in the conversation count: not deleted
+// for everyone, not hidden by them, and not thread replies, which are read
+// inside their thread.
+func (d *deliveryRepo) CountUnread(recipientID uuid.UUID) (map[uuid.UUID]int64, error) {
+	var rows []struct {
+		ConversationID uuid.UUID
+		Unread         int64
+	}
+	err := d.DB.Model(&models.MessageDelivery{}).
+		Select("conversation_id, COUNT(*) AS unread").
+		Where("recipient_id=? AND read_at IS NULL", recipientID).
+		Where("message_id NOT IN (SELECT message_id FROM hidden_messages WHERE user_id = ? AND deleted_at IS NULL)", recipientID).
+		Where(`(conversation_type = ? AND message_id IN (
+				SELECT id FROM private_messages WHERE deleted_at IS NULL AND deleted_for_all_at IS NULL
+			)) OR (conversation_type = ? AND message_id IN (
+				SELECT id FROM group_messages WHERE deleted_at IS NULL AND deleted_for_all_at IS NULL AND thread_root_id IS NULL
+			))`, models.PrivateConversation, models.GroupConversation).
+		Group("conversation_id").
+		Scan(&rows).Error
+
+	counts := map[uuid.UUID]int64{}
+	for _, row := range rows {
+		counts[row.ConversationID] = row.Unread
+	}
+	return counts, err
+}
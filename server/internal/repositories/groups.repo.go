@@ -13,13 +13,16 @@ type groupRepo struct {
 
 type GroupRepoInterface interface {
 	BeginDBTx() *gorm.DB
-	CreateGroup(group *models.Group) error
+	CreateGroup(txn *gorm.DB, group *models.Group) error
+	NextSeq(txn *gorm.DB, groupID uuid.UUID) (int64, error)
 	GetUserGroups(userID uuid.UUID) ([]models.Group, error)
 	FindByID(groupID uuid.UUID) (models.Group, error)
 	GetGroupMember(groupID, userID uuid.UUID) (models.GroupMember, error)
 	CreateGroupMembership(tx *gorm.DB, membership *[]models.GroupMember) error
-	RevokeMembership(groupID, userID uuid.UUID) error
+	RevokeMembership(txn *gorm.DB, groupID, userID uuid.UUID) error
 	GetGroupMembers(groupID uuid.UUID) ([]models.GroupMember, error)
+	SetMuted(txn *gorm.DB, groupID, userID uuid.UUID, muted bool) error
+	GetCoMemberIDs(userID uuid.UUID) ([]uuid.UUID, error)
 }
 
 func NewGroupRepo(db gorm.DB) GroupRepoInterface {
@@ -32,34 +35,27 @@ func (g *groupRepo) BeginDBTx() *gorm.DB {
 	return g.DB.Begin()
 }
 
-func (g *groupRepo) CreateGroup(group *models.Group) error {
-	return g.DB.Create(group).Error
+func (g *groupRepo) CreateGroup(txn *gorm.DB, group *models.Group) error {
+	if txn == nil {
+		return g.DB.Create(group).Error
+	}
+	return txn.Create(group).Error
 }
 
-func (g *groupRepo) GetUserGroups(userID uuid.UUID) ([]models.Group, error) {
-	var memberships []models.GroupMember
-	var groups []models.Group
-	err := g.DB.Joins("Groups").Where("user_id = ?", userID).Find(&memberships).Error
-	if err != nil {
-		return groups, err
-	}
-	for _, m := range memberships {
-		groups = append(groups, m.Group)
+func (g *groupRepo) NextSeq(txn *gorm.DB, groupID uuid.UUID) (int64, error) {
+	if txn == nil {
+		txn = &g.DB
 	}
-
-	return groups, nil
+	return nextSeq(txn, "groups", groupID)
 }
 
-// func (g *groupRepo) GetUserGroups(userID uuid.UUID) ([]models.Group, error) {
-//     var groups []models.Group
-//     err := g.DB.Joins("JOIN group_members ON group_members.group_id = groups.id").
-//         Where("group_members.user_id = ?", userID).
-//         Find(&groups).Error
-//     if err != nil {
-//         return nil, err
-//     }
-//     return groups, nil
-// }
+func (g *groupRepo) GetUserGroups(userID uuid.UUID) ([]models.Group, error) {
+	var groups []models.Group
+	err := g.DB.Joins("JOIN group_members ON group_members.group_id = groups.id AND group_members.deleted_at IS NULL").
+		Where("group_members.user_id = ?", userID).
+		Find(&groups).Error
+	return groups, err
+}
 
 func (g *groupRepo) FindByID(groupID uuid.UUID) (models.Group, error) {
 	var group models.Group
@@ -81,8 +77,11 @@ func (g *groupRepo) CreateGroupMembership(tx *gorm.DB, membership *[]models.Grou
 	return g.DB.Create(membership).Error
 }
 
-func (g *groupRepo) RevokeMembership(groupID, userID uuid.UUID) error {
-	return g.DB.Unscoped().Where("user_id=? AND group_id=?", userID, groupID).Delete(&models.GroupMember{}).Error
+func (g *groupRepo) RevokeMembership(txn *gorm.DB, groupID, userID uuid.UUID) error {
+	if txn == nil {
+		txn = &g.DB
+	}
+	return txn.Unscoped().Where("user_id=? AND group_id=?", userID, groupID).Delete(&models.GroupMember{}).Error
 }
 
 func (g *groupRepo) GetGroupMembers(groupID uuid.UUID) ([]models.GroupMember, error) {
@@ -90,3 +89,23 @@ func (g *groupRepo) GetGroupMembers(groupID uuid.UUID) ([]models.GroupMember, er
 	err := g.DB.Where("group_id=?", groupID).Find(&members).Error
 	return members, err
 }
+
+func (g *groupRepo) SetMuted(txn *gorm.DB, groupID, userID uuid.UUID, muted bool) error {
+	if txn == nil {
+		txn = &g.DB
+	}
+	return txn.Model(&models.GroupMember{}).
+		Where("user_id=? AND group_id=?", userID, groupID).
+		Update("muted", muted).Error
+}
+
+// GetCoMemberIDs lists everyone sharing at least one group with the user.
+func (g *groupRepo) GetCoMemberIDs(userID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := g.DB.Model(&models.GroupMember{}).
+		Distinct("user_id").
+		Where("group_id IN (SELECT group_id FROM group_members WHERE user_id = ? AND deleted_at IS NULL)", userID).
+		Where("user_id <> ?", userID).
+		Pluck("user_id", &ids).Error
+	return ids, err
+}
@@ -2,19 +2,40 @@ package repos
 
 import (
 	"shiplabs/schat/internal/models"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PrivateMessageRepoInterface interface {
 	Create(txn *gorm.DB, message *models.PrivateMessage) error
-	GetChatMessages(chatID uuid.UUID) ([]models.PrivateMessage, error)
+	FindByClientID(senderID uuid.UUID, clientMessageID string) (models.PrivateMessage, error)
+	GetChatMessages(chatID uuid.UUID, page MessagePage) ([]models.PrivateMessage, error)
+	GetChatMessagesSince(chatID uuid.UUID, page SeqPage) ([]models.PrivateMessage, error)
+	FindByIDs(ids []uuid.UUID) ([]models.PrivateMessage, error)
+	GetLastMessages(chatIDs []uuid.UUID, viewerID uuid.UUID) ([]models.PrivateMessage, error)
+	LockForUpdate(txn *gorm.DB, id uuid.UUID) (models.BaseMessage, error)
+	UpdateContent(txn *gorm.DB, id uuid.UUID, content string, editedAt time.Time) error
+	MarkDeletedForAll(txn *gorm.DB, id uuid.UUID, deletedAt time.Time) error
+	ChatIDsWithAttachment(attachmentID uuid.UUID) ([]uuid.UUID, error)
 }
 
 type GroupMessageRepoInterface interface {
-	Create(message *models.GroupMessage) error
-	GetGroupMessages(groupID uuid.UUID) ([]models.GroupMessage, error)
+	BeginDBTx() *gorm.DB
+	Create(txn *gorm.DB, message *models.GroupMessage) error
+	FindByClientID(senderID uuid.UUID, clientMessageID string) (models.GroupMessage, error)
+	IncrementReplyCount(txn *gorm.DB, rootID uuid.UUID) error
+	GetThreadMessages(rootID uuid.UUID, page MessagePage) ([]models.GroupMessage, error)
+	GetGroupMessages(groupID uuid.UUID, page MessagePage) ([]models.GroupMessage, error)
+	GetGroupMessagesSince(groupID uuid.UUID, page SeqPage) ([]models.GroupMessage, error)
+	FindByIDs(ids []uuid.UUID) ([]models.GroupMessage, error)
+	GetLastMessages(groupIDs []uuid.UUID, viewerID uuid.UUID) ([]models.GroupMessage, error)
+	LockForUpdate(txn *gorm.DB, id uuid.UUID) (models.BaseMessage, error)
+	UpdateContent(txn *gorm.DB, id uuid.UUID, content string, editedAt time.Time) error
+	MarkDeletedForAll(txn *gorm.DB, id uuid.UUID, deletedAt time.Time) error
+	GroupIDsWithAttachment(attachmentID uuid.UUID) ([]uuid.UUID, error)
 }
 
 type privateMessageRepo struct {
@@ -38,24 +59,260 @@ func (p *privateMessageRepo) Create(txn *gorm.DB, message *models.PrivateMessage
 	return txn.Create(message).Error
 }
 
-func (p *privateMessageRepo) GetChatMessages(chatID uuid.UUID) ([]models.PrivateMessage, error) {
+func (p *privateMessageRepo) FindByClientID(senderID uuid.UUID, clientMessageID string) (models.PrivateMessage, error) {
+	var message models.PrivateMessage
+	err := p.DB.Preload("Attachment").Where("sender_id=? AND client_message_id=?", senderID, clientMessageID).First(&message).Error
+	return message, err
+}
+
+func (p *privateMessageRepo) GetChatMessages(chatID uuid.UUID, page MessagePage) ([]models.PrivateMessage, error) {
+	var messages []models.PrivateMessage
+	query, descending := applyPage(p.DB.Preload("Attachment").Where("chat_id=?", chatID), page)
+	if err := query.Find(&messages).Error; err != nil {
+		return messages, err
+	}
+	if descending {
+		reverse(messages)
+	}
+	return messages, attachReactions(&p.DB, messages, page.ViewerID)
+}
+
+func (p *privateMessageRepo) GetChatMessagesSince(chatID uuid.UUID, page SeqPage) ([]models.PrivateMessage, error) {
+	var messages []models.PrivateMessage
+	err := applySeqPage(p.DB.Preload("Attachment").Where("chat_id=?", chatID), page).Find(&messages).Error
+	if err != nil {
+		return messages, err
+	}
+	return messages, attachReactions(&p.DB, messages, page.ViewerID)
+}
+
+func (p *privateMessageRepo) FindByIDs(ids []uuid.UUID) ([]models.PrivateMessage, error) {
+	var messages []models.PrivateMessage
+	if len(ids) == 0 {
+		return messages, nil
+	}
+	err := p.DB.Preload("Attachment").Where("id IN ?", ids).Find(&messages).Error
+	return messages, err
+}
+
+// GetLastMessages returns the newest message of each chat the viewer
+// hasn't deleted for themselves.
+func (p *privateMessageRepo) GetLastMessages(chatIDs []uuid.UUID, viewerID uuid.UUID) ([]models.PrivateMessage, error) {
 	var messages []models.PrivateMessage
-	err := p.DB.Where("chat_id=?", chatID).Find(&messages).Error
+	if len(chatIDs) == 0 {
+		return messages, nil
+	}
+	err := excludeHidden(p.DB.Preload("Attachment"), viewerID).Select("DISTINCT ON (chat_id) *").
+		Where("chat_id IN ?", chatIDs).
+		Order("chat_id, created_at DESC, id DESC").
+		Find(&messages).Error
 	return messages, err
 }
 
+func (p *privateMessageRepo) LockForUpdate(txn *gorm.DB, id uuid.UUID) (models.BaseMessage, error) {
+	if txn == nil {
+		txn = &p.DB
+	}
+	var message models.PrivateMessage
+	err := lockMessage(txn, &message, id)
+	return message.BaseMessage, err
+}
+
+func (p *privateMessageRepo) UpdateContent(txn *gorm.DB, id uuid.UUID, content string, editedAt time.Time) error {
+	if txn == nil {
+		txn = &p.DB
+	}
+	return updateContent(txn, &models.PrivateMessage{}, id, content, editedAt)
+}
+
+func (p *privateMessageRepo) MarkDeletedForAll(txn *gorm.DB, id uuid.UUID, deletedAt time.Time) error {
+	if txn == nil {
+		txn = &p.DB
+	}
+	return markDeletedForAll(txn, &models.PrivateMessage{}, id, deletedAt)
+}
+
+func (p *privateMessageRepo) ChatIDsWithAttachment(attachmentID uuid.UUID) ([]uuid.UUID, error) {
+	return conversationsWithAttachment(&p.DB, &models.PrivateMessage{}, "chat_id", attachmentID)
+}
+
 func NewGroupMessageRepo(db gorm.DB) GroupMessageRepoInterface {
 	return &groupMessageRepo{
 		DB: db,
 	}
 }
 
-func (g *groupMessageRepo) Create(message *models.GroupMessage) error {
-	return g.DB.Create(&message).Error
+func (g *groupMessageRepo) BeginDBTx() *gorm.DB {
+	return g.DB.Begin()
+}
+
+func (g *groupMessageRepo) Create(txn *gorm.DB, message *models.GroupMessage) error {
+	if txn == nil {
+		return g.DB.Create(message).Error
+	}
+	return txn.Create(message).Error
+}
+
+func (g *groupMessageRepo) FindByClientID(senderID uuid.UUID, clientMessageID string) (models.GroupMessage, error) {
+	var message models.GroupMessage
+	err := g.DB.Preload("Attachment").Where("sender_id=? AND client_message_id=?", senderID, clientMessageID).First(&message).Error
+	return message, err
+}
+
+func (g *groupMessageRepo) IncrementReplyCount(txn *gorm.DB, rootID uuid.UUID) error {
+	if txn == nil {
+		txn = &g.DB
+	}
+	return txn.Model(&models.GroupMessage{}).
+		Where("id=?", rootID).
+		Update("reply_count", gorm.Expr("reply_count + 1")).Error
+}
+
+func (g *groupMessageRepo) GetThreadMessages(rootID uuid.UUID, page MessagePage) ([]models.GroupMessage, error) {
+	var messages []models.GroupMessage
+	query, descending := applyPage(g.DB.Preload("Attachment").Where("thread_root_id=?", rootID), page)
+	if err := query.Find(&messages).Error; err != nil {
+		return messages, err
+	}
+	if descending {
+		reverse(messages)
+	}
+	return messages, attachReactions(&g.DB, messages, page.ViewerID)
 }
 
-func (g *groupMessageRepo) GetGroupMessages(groupID uuid.UUID) ([]models.GroupMessage, error) {
+func (g *groupMessageRepo) GetGroupMessages(groupID uuid.UUID, page MessagePage) ([]models.GroupMessage, error) {
 	var messages []models.GroupMessage
-	err := g.DB.Where("group_id=?", groupID).Find(&messages).Error
+	query, descending := applyPage(g.DB.Preload("Attachment").Where("group_id=? AND thread_root_id IS NULL", groupID), page)
+	if err := query.Find(&messages).Error; err != nil {
+		return messages, err
+	}
+	if descending {
+		reverse(messages)
+	}
+	return messages, attachReactions(&g.DB, messages, page.ViewerID)
+}
+
+// GetGroupMessagesSince includes thread replies, they are numbered along
+// with the rest of the group.
+func (g *groupMessageRepo) GetGroupMessagesSince(groupID uuid.UUID, page SeqPage) ([]models.GroupMessage, error) {
+	var messages []models.GroupMessage
+	err := applySeqPage(g.DB.Preload("Attachment").Where("group_id=?", groupID), page).Find(&messages).Error
+	if err != nil {
+		return messages, err
+	}
+	return messages, attachReactions(&g.DB, messages, page.ViewerID)
+}
+
+func (g *groupMessageRepo) FindByIDs(ids []uuid.UUID) ([]models.GroupMessage, error) {
+	var messages []models.GroupMessage
+	if len(ids) == 0 {
+		return messages, nil
+	}
+	err := g.DB.Preload("Attachment").Where("id IN ?", ids).Find(&messages).Error
+	return messages, err
+}
+
+// GetLastMessages returns the newest message of each group the viewer
+// hasn't deleted for themselves.
+func (g *groupMessageRepo) GetLastMessages(groupIDs []uuid.UUID, viewerID uuid.UUID) ([]models.GroupMessage, error) {
+	var messages []models.GroupMessage
+	if len(groupIDs) == 0 {
+		return messages, nil
+	}
+	err := excludeHidden(g.DB.Preload("Attachment"), viewerID).Select("DISTINCT ON (group_id) *").
+		Where("group_id IN ? AND thread_root_id IS NULL", groupIDs).
+		Order("group_id, created_at DESC, id DESC").
+		Find(&messages).Error
 	return messages, err
 }
+
+func (g *groupMessageRepo) LockForUpdate(txn *gorm.DB, id uuid.UUID) (models.BaseMessage, error) {
+	if txn == nil {
+		txn = &g.DB
+	}
+	var message models.GroupMessage
+	err := lockMessage(txn, &message, id)
+	return message.BaseMessage, err
+}
+
+func (g *groupMessageRepo) UpdateContent(txn *gorm.DB, id uuid.UUID, content string, editedAt time.Time) error {
+	if txn == nil {
+		txn = &g.DB
+	}
+	return updateContent(txn, &models.GroupMessage{}, id, content, editedAt)
+}
+
+func (g *groupMessageRepo) MarkDeletedForAll(txn *gorm.DB, id uuid.UUID, deletedAt time.Time) error {
+	if txn == nil {
+		txn = &g.DB
+	}
+	return markDeletedForAll(txn, &models.GroupMessage{}, id, deletedAt)
+}
+
+func (g *groupMessageRepo) GroupIDsWithAttachment(attachmentID uuid.UUID) ([]uuid.UUID, error) {
+	return conversationsWithAttachment(&g.DB, &models.GroupMessage{}, "group_id", attachmentID)
+}
+
+// lockMessage reads the message and holds its row until the transaction
+// ends, so concurrent changes to it are applied one after the other.
+func lockMessage(db *gorm.DB, message any, id uuid.UUID) error {
+	return db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id=?", id).First(message).Error
+}
+
+func updateContent(db *gorm.DB, model any, id uuid.UUID, content string, editedAt time.Time) error {
+	return db.Model(model).Where("id=?", id).Updates(map[string]any{
+		"content":   content,
+		"edited_at": editedAt,
+	}).Error
+}
+
+// markDeletedForAll tombstones the message, its content and attachment are
+// dropped so they can't leak through history, search or downloads.
+func markDeletedForAll(db *gorm.DB, model any, id uuid.UUID, deletedAt time.Time) error {
+	return db.Model(model).Where("id=?", id).Updates(map[string]any{
+		"content":            "",
+		"attachment_id":      nil,
+		"deleted_for_all_at": deletedAt,
+	}).Error
+}
+
+// conversationsWithAttachment lists the chats or groups (per column) in
+// which the attachment has been posted.
+func conversationsWithAttachment(db *gorm.DB, model any, column string, attachmentID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := db.Model(model).Where("attachment_id=?", attachmentID).Distinct().Pluck(column, &ids).Error
+	return ids, err
+}
+
+type reactable interface {
+	models.PrivateMessage | models.GroupMessage
+}
+
+// attachReactions fills in the aggregated reactions of each message as seen
+// by the viewer.
+func attachReactions[T reactable](db *gorm.DB, messages []T, viewerID uuid.UUID) error {
+	ids := make([]uuid.UUID, 0, len(messages))
+	for i := range messages {
+		ids = append(ids, baseOf(&messages[i]).ID)
+	}
+
+	counts, err := reactionCounts(db, ids, viewerID)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		base := baseOf(&messages[i])
+		base.Reactions = counts[base.ID]
+	}
+	return nil
+}
+
+func baseOf[T reactable](msg *T) *models.BaseMessage {
+	switch m := any(msg).(type) {
+	case *models.PrivateMessage:
+		return &m.BaseMessage
+	case *models.GroupMessage:
+		return &m.BaseMessage
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package repos
+
+import (
+	"shiplabs/schat/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type messageActionRepo struct {
+	DB gorm.DB
+}
+
+type MessageActionRepoInterface interface {
+	BeginDBTx() *gorm.DB
+	CreateEdit(txn *gorm.DB, edit *models.MessageEdit) error
+	GetEdits(messageID uuid.UUID) ([]models.MessageEdit, error)
+	HideMessage(txn *gorm.DB, userID, messageID uuid.UUID) error
+}
+
+func NewMessageActionRepo(db gorm.DB) MessageActionRepoInterface {
+	return &messageActionRepo{
+		DB: db,
+	}
+}
+
+func (m *messageActionRepo) BeginDBTx() *gorm.DB {
+	return m.DB.Begin()
+}
+
+func (m *messageActionRepo) CreateEdit(txn *gorm.DB, edit *models.MessageEdit) error {
+	if txn == nil {
+		return m.DB.Create(edit).Error
+	}
+	return txn.Create(edit).Error
+}
+
+func (m *messageActionRepo) GetEdits(messageID uuid.UUID) ([]models.MessageEdit, error) {
+	var edits []models.MessageEdit
+	err := m.DB.Where("message_id=?", messageID).Order("created_at ASC").Find(&edits).Error
+	return edits, err
+}
+
+func (m *messageActionRepo) HideMessage(txn *gorm.DB, userID, messageID uuid.UUID) error {
+	if txn == nil {
+		txn = &m.DB
+	}
+	return txn.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "message_id"}, {Name: "user_id"}},
+		DoNothing: true,
+	}).Create(&models.HiddenMessage{
+		MessageID: messageID,
+		UserID:    userID,
+	}).Error
+}
@@ -0,0 +1,79 @@
+package repos
+
+import (
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/pkg/shared"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type outboxRepo struct {
+	DB gorm.DB
+}
+
+type OutboxRepoInterface interface {
+	BeginDBTx() *gorm.DB
+	Append(txn *gorm.DB, events []models.OutboxEvent) error
+	Claim(limit int, lease time.Duration) ([]models.OutboxEvent, error)
+	MarkDispatched(id uuid.UUID) error
+	MarkFailed(id uuid.UUID, reason string) error
+	PurgeDispatched(before time.Time) error
+}
+
+func NewOutboxRepo(db gorm.DB) OutboxRepoInterface {
+	return &outboxRepo{
+		DB: db,
+	}
+}
+
+func (o *outboxRepo) BeginDBTx() *gorm.DB {
+	return o.DB.Begin()
+}
+
+func (o *outboxRepo) Append(txn *gorm.DB, events []models.OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if txn == nil {
+		return o.DB.Create(&events).Error
+	}
+	return txn.Create(&events).Error
+}
+
+// Claim leases the oldest pending events. Leased events are invisible to
+// other dispatchers until the lease runs out, so an instance dying mid
+// dispatch only delays its events, it doesn't lose them.
+func (o *outboxRepo) Claim(limit int, lease time.Duration) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	now := shared.TimeNow()
+	err := o.DB.Raw(`UPDATE outbox_events SET available_at = ?, attempts = attempts + 1, updated_at = ?
+		WHERE id IN (
+			SELECT id FROM outbox_events
+			WHERE dispatched_at IS NULL AND available_at <= ? AND deleted_at IS NULL
+			ORDER BY created_at, id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, now.Add(lease), now, now, limit).
+		Scan(&events).Error
+	return events, err
+}
+
+func (o *outboxRepo) MarkDispatched(id uuid.UUID) error {
+	return o.DB.Model(&models.OutboxEvent{}).Where("id=?", id).Update("dispatched_at", shared.TimeNow()).Error
+}
+
+// MarkFailed retires an event that can never be dispatched, keeping the
+// reason around for inspection until it is purged.
+func (o *outboxRepo) MarkFailed(id uuid.UUID, reason string) error {
+	return o.DB.Model(&models.OutboxEvent{}).Where("id=?", id).Updates(map[string]any{
+		"last_error":    reason,
+		"dispatched_at": shared.TimeNow(),
+	}).Error
+}
+
+func (o *outboxRepo) PurgeDispatched(before time.Time) error {
+	return o.DB.Unscoped().Where("dispatched_at < ?", before).Delete(&models.OutboxEvent{}).Error
+}
@@ -0,0 +1,95 @@
+package repos
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 100
+)
+
+// Cursor is a position in a conversation. Messages are ordered by
+// (created_at, id) so two messages sharing a timestamp still have a stable
+// order. ID is nil when the client paginates by timestamp alone.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// MessagePage selects at most Limit messages strictly before or after a
+// cursor, or the latest messages when neither is set. Messages the viewer
+// deleted for themselves are left out.
+type MessagePage struct {
+	Before   *Cursor
+	After    *Cursor
+	Limit    int
+	ViewerID uuid.UUID
+}
+
+// SeqPage selects at most Limit messages numbered above Since, lowest
+// first.
+type SeqPage struct {
+	Since    int64
+	Limit    int
+	ViewerID uuid.UUID
+}
+
+// applyPage scopes the query to the page. When it reports descending the
+// rows come back newest first and must be reversed into chronological order.
+func applyPage(query *gorm.DB, page MessagePage) (q *gorm.DB, descending bool) {
+	limit := page.Limit
+	if limit <= 0 || limit > MaxPageSize {
+		limit = DefaultPageSize
+	}
+	query = excludeHidden(query, page.ViewerID)
+
+	if page.After != nil {
+		if page.After.ID == uuid.Nil {
+			query = query.Where("created_at > ?", page.After.CreatedAt)
+		} else {
+			query = query.Where("(created_at, id) > (?, ?)", page.After.CreatedAt, page.After.ID)
+		}
+		return query.Order("created_at ASC, id ASC").Limit(limit), false
+	}
+
+	if page.Before != nil {
+		if page.Before.ID == uuid.Nil {
+			query = query.Where("created_at < ?", page.Before.CreatedAt)
+		} else {
+			query = query.Where("(created_at, id) < (?, ?)", page.Before.CreatedAt, page.Before.ID)
+		}
+	}
+
+	return query.Order("created_at DESC, id DESC").Limit(limit), true
+}
+
+// applySeqPage leaves bounding Limit to the caller, which may ask for an
+// extra row to detect more pages.
+func applySeqPage(query *gorm.DB, page SeqPage) *gorm.DB {
+	limit := page.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	return excludeHidden(query, page.ViewerID).
+		Where("seq > ?", page.Since).
+		Order("seq ASC").
+		Limit(limit)
+}
+
+// excludeHidden leaves out the messages the viewer deleted for themselves.
+func excludeHidden(query *gorm.DB, viewerID uuid.UUID) *gorm.DB {
+	if viewerID == uuid.Nil {
+		return query
+	}
+	return query.Where("id NOT IN (SELECT message_id FROM hidden_messages WHERE user_id = ? AND deleted_at IS NULL)", viewerID)
+}
+
+func reverse[T any](items []T) {
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+}
@@ -1,10 +1,12 @@
 package repos
 
 import (
+	"fmt"
 	"shiplabs/schat/internal/models"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type privateChatRepo struct {
@@ -13,9 +15,12 @@ type privateChatRepo struct {
 
 type PrivateChatRepoInterface interface {
 	BeginDBTx() *gorm.DB
-	CreatePrivateChat(txn *gorm.DB, chat *models.PrivateChat) error
+	FindOrCreateChat(txn *gorm.DB, mem1, mem2 uuid.UUID) (models.PrivateChat, error)
+	NextSeq(txn *gorm.DB, chatID uuid.UUID) (int64, error)
 	FindChat(mem1, mem2 uuid.UUID) (models.PrivateChat, error)
+	FindByID(chatID uuid.UUID) (models.PrivateChat, error)
 	GetUserPrivateChats(userID uuid.UUID) ([]models.PrivateChat, error)
+	GetPartnerIDs(userID uuid.UUID) ([]uuid.UUID, error)
 }
 
 func NewPrivateChatRepo(db gorm.DB) PrivateChatRepoInterface {
@@ -28,21 +33,72 @@ func (p *privateChatRepo) BeginDBTx() *gorm.DB {
 	return p.DB.Begin()
 }
 
-func (p *privateChatRepo) CreatePrivateChat(txn *gorm.DB, chat *models.PrivateChat) error {
+// FindOrCreateChat returns the chat between the two members, creating it
+// when there is none. The unique index on the member pair makes a
+// concurrent create wait for the other transaction and then fall back to
+// reading its row.
+func (p *privateChatRepo) FindOrCreateChat(txn *gorm.DB, mem1, mem2 uuid.UUID) (models.PrivateChat, error) {
 	if txn == nil {
-		return p.DB.Create(chat).Error
+		txn = &p.DB
 	}
-	return txn.Create(chat).Error
+	chat := models.PrivateChat{FirstMemberID: mem1, SecondMemberID: mem2}
+	result := txn.Clauses(clause.OnConflict{DoNothing: true}).Create(&chat)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return chat, result.Error
+	}
+	return findChat(txn, mem1, mem2)
+}
+
+func (p *privateChatRepo) NextSeq(txn *gorm.DB, chatID uuid.UUID) (int64, error) {
+	if txn == nil {
+		txn = &p.DB
+	}
+	return nextSeq(txn, "private_chats", chatID)
+}
+
+// nextSeq bumps the conversation's message counter. The row stays locked
+// until the transaction ends, so concurrent sends to the same conversation
+// commit in the order of their numbers.
+func nextSeq(db *gorm.DB, table string, id uuid.UUID) (int64, error) {
+	var seq int64
+	err := db.Raw(fmt.Sprintf("UPDATE %s SET last_seq = last_seq + 1 WHERE id = ? RETURNING last_seq", table), id).
+		Scan(&seq).Error
+	if err == nil && seq == 0 {
+		err = gorm.ErrRecordNotFound
+	}
+	return seq, err
 }
 
 func (p *privateChatRepo) FindChat(mem1, mem2 uuid.UUID) (models.PrivateChat, error) {
+	return findChat(&p.DB, mem1, mem2)
+}
+
+func findChat(db *gorm.DB, mem1, mem2 uuid.UUID) (models.PrivateChat, error) {
+	var chat models.PrivateChat
+	err := db.Where("(first_member_id = ? AND second_member_id = ?) OR (first_member_id = ? AND second_member_id = ?)", mem1, mem2, mem2, mem1).First(&chat).Error
+	return chat, err
+}
+
+func (p *privateChatRepo) FindByID(chatID uuid.UUID) (models.PrivateChat, error) {
 	var chat models.PrivateChat
-	err := p.DB.Where("(first_member_id = ? AND second_member_id = ?) OR (first_member_id = ? AND second_member_id = ?)", mem1, mem2, mem2, mem1).First(&chat).Error
+	err := p.DB.Where("id=?", chatID).First(&chat).Error
 	return chat, err
 }
 
 func (p *privateChatRepo) GetUserPrivateChats(userID uuid.UUID) ([]models.PrivateChat, error) {
 	var chats []models.PrivateChat
-	err := p.DB.Where("first_member_id=? OR second_member_id=?", userID, userID).Find(&chats).Error
+	err := p.DB.Preload("FirstMember").Preload("SecondMember").
+		Where("first_member_id=? OR second_member_id=?", userID, userID).
+		Find(&chats).Error
 	return chats, err
 }
+
+// GetPartnerIDs lists everyone the user has a private chat with.
+func (p *privateChatRepo) GetPartnerIDs(userID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := p.DB.Model(&models.PrivateChat{}).
+		Select("CASE WHEN first_member_id = ? THEN second_member_id ELSE first_member_id END", userID).
+		Where("first_member_id=? OR second_member_id=?", userID, userID).
+		Scan(&ids).Error
+	return ids, err
+}
@@ -0,0 +1,104 @@
+package repos
+
+import (
+	"shiplabs/schat/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type reactionRepo struct {
+	DB gorm.DB
+}
+
+type ReactionRepoInterface interface {
+	BeginDBTx() *gorm.DB
+	AddReaction(txn *gorm.DB, reaction *models.MessageReaction) error
+	RemoveReaction(txn *gorm.DB, messageID, userID uuid.UUID, emoji string) error
+	CountOtherEmojis(txn *gorm.DB, messageID, userID uuid.UUID, emoji string) (int64, error)
+	GetReactionCounts(txn *gorm.DB, messageIDs []uuid.UUID, viewerID uuid.UUID) (map[uuid.UUID][]models.ReactionCount, error)
+}
+
+func NewReactionRepo(db gorm.DB) ReactionRepoInterface {
+	return &reactionRepo{
+		DB: db,
+	}
+}
+
+func (r *reactionRepo) BeginDBTx() *gorm.DB {
+	return r.DB.Begin()
+}
+
+func (r *reactionRepo) AddReaction(txn *gorm.DB, reaction *models.MessageReaction) error {
+	if txn == nil {
+		txn = &r.DB
+	}
+	return txn.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "message_id"}, {Name: "user_id"}, {Name: "emoji"}},
+		DoNothing: true,
+	}).Create(reaction).Error
+}
+
+func (r *reactionRepo) RemoveReaction(txn *gorm.DB, messageID, userID uuid.UUID, emoji string) error {
+	if txn == nil {
+		txn = &r.DB
+	}
+	return txn.Unscoped().
+		Where("message_id=? AND user_id=? AND emoji=?", messageID, userID, emoji).
+		Delete(&models.MessageReaction{}).Error
+}
+
+// CountOtherEmojis counts the emojis the user reacted to the message with,
+// leaving emoji out.
+func (r *reactionRepo) CountOtherEmojis(txn *gorm.DB, messageID, userID uuid.UUID, emoji string) (int64, error) {
+	if txn == nil {
+		txn = &r.DB
+	}
+	var count int64
+	err := txn.Model(&models.MessageReaction{}).
+		Where("message_id=? AND user_id=? AND emoji<>?", messageID, userID, emoji).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *reactionRepo) GetReactionCounts(txn *gorm.DB, messageIDs []uuid.UUID, viewerID uuid.UUID) (map[uuid.UUID][]models.ReactionCount, error) {
+	if txn == nil {
+		txn = &r.DB
+	}
+	return reactionCounts(txn, messageIDs, viewerID)
+}
+
+// reactionCounts aggregates reactions per message and emoji, ordered by the
+// first time each emoji was used on the message.
+func reactionCounts(db *gorm.DB, messageIDs []uuid.UUID, viewerID uuid.UUID) (map[uuid.UUID][]models.ReactionCount, error) {
+	counts := map[uuid.UUID][]models.ReactionCount{}
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		MessageID uuid.UUID
+		Emoji     string
+		Count     int64
+		Reacted   bool
+	}
+	err := db.Model(&models.MessageReaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, BOOL_OR(user_id = ?) AS reacted", viewerID).
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").
+		Order("MIN(created_at)").
+		Scan(&rows).Error
+	if err != nil {
+		return counts, err
+	}
+
+	for _, row := range rows {
+		counts[row.MessageID] = append(counts[row.MessageID], models.ReactionCount{
+			Emoji:   row.Emoji,
+			Count:   row.Count,
+			Reacted: row.Reacted,
+		})
+	}
+	return counts, nil
+}
@@ -0,0 +1,157 @@
+package repos
+
+import (
+	"html"
+	"strings"
+	"time"
+
+	"shiplabs/schat/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// searchLanguage must match the text search configuration used by the
+// content indexes created in db.Connect, otherwise they won't be used.
+const searchLanguage = "english"
+
+// ts_headline marks hits with these private use characters, stripped from
+// the content beforehand, so the snippet can be HTML escaped before they
+// are turned into <mark> tags.
+const (
+	highlightStart = "\uE000"
+	highlightStop  = "\uE001"
+)
+
+var highlighter = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+// SearchFilter narrows a search to the conversations UserID belongs to and
+// then by whichever optional filters are set.
+type SearchFilter struct {
+	UserID           uuid.UUID
+	Query            string
+	SenderID         *uuid.UUID
+	ConversationType models.ConversationType
+	ConversationID   *uuid.UUID
+	Type             models.ValidMsgType
+	From             *time.Time
+	To               *time.Time
+	Limit            int
+	Offset           int
+}
+
+// SearchHit is a matching message. Snippet is the HTML escaped content
+// with matched terms wrapped in <mark></mark>.
+type SearchHit struct {
+	MessageID        uuid.UUID               `json:"message_id"`
+	ConversationType models.ConversationType `json:"conversation_type"`
+	ConversationID   uuid.UUID               `json:"conversation_id"`
+	ThreadRootID     *uuid.UUID              `json:"thread_root_id,omitempty"`
+	SenderID         uuid.UUID               `json:"sender_id"`
+	Type             models.ValidMsgType     `json:"type"`
+	Snippet          string                  `json:"snippet"`
+	Rank             float64                 `json:"rank"`
+	CreatedAt        time.Time               `json:"created_at"`
+}
+
+type searchRepo struct {
+	DB gorm.DB
+}
+
+type SearchRepoInterface interface {
+	SearchMessages(filter SearchFilter) ([]SearchHit, error)
+}
+
+func NewSearchRepo(db gorm.DB) SearchRepoInterface {
+	return &searchRepo{
+		DB: db,
+	}
+}
+
+// SearchMessages ranks matching private and group messages together. The
+// headline is only computed for the rows on the requested page.
+func (s *searchRepo) SearchMessages(filter SearchFilter) ([]SearchHit, error) {
+	args := map[string]any{
+		"user":   filter.UserID,
+		"query":  filter.Query,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	}
+	tsQuery := "websearch_to_tsquery('" + searchLanguage + "', @query)"
+	tsVector := "to_tsvector('" + searchLanguage + "', m.content)"
+
+	common := []string{
+		tsVector + " @@ " + tsQuery,
+		"m.deleted_at IS NULL",
+		"m.deleted_for_all_at IS NULL",
+		"m.id NOT IN (SELECT message_id FROM hidden_messages WHERE user_id = @user AND deleted_at IS NULL)",
+	}
+	if filter.SenderID != nil {
+		common = append(common, "m.sender_id = @sender")
+		args["sender"] = *filter.SenderID
+	}
+	if filter.Type != "" {
+		common = append(common, "m.type = @type")
+		args["type"] = filter.Type
+	}
+	if filter.From != nil {
+		common = append(common, "m.created_at >= @from")
+		args["from"] = *filter.From
+	}
+	if filter.To != nil {
+		common = append(common, "m.created_at < @to")
+		args["to"] = *filter.To
+	}
+	if filter.ConversationID != nil {
+		args["conversation"] = *filter.ConversationID
+	}
+
+	var branches []string
+	if filter.ConversationType != models.GroupConversation {
+		where := append([]string{"(c.first_member_id = @user OR c.second_member_id = @user)"}, common...)
+		if filter.ConversationID != nil {
+			where = append(where, "m.chat_id = @conversation")
+		}
+		branches = append(branches, `SELECT m.id AS message_id, 'private' AS conversation_type, m.chat_id AS conversation_id,
+			NULL::uuid AS thread_root_id, m.sender_id, m.type, m.content, m.created_at,
+			ts_rank(`+tsVector+`, `+tsQuery+`) AS rank
+		FROM private_messages m
+		JOIN private_chats c ON c.id = m.chat_id AND c.deleted_at IS NULL
+		WHERE `+strings.Join(where, " AND "))
+	}
+	if filter.ConversationType != models.PrivateConversation {
+		where := append([]string(nil), common...)
+		if filter.ConversationID != nil {
+			where = append(where, "m.group_id = @conversation")
+		}
+		branches = append(branches, `SELECT m.id AS message_id, 'group' AS conversation_type, m.group_id AS conversation_id,
+			m.thread_root_id, m.sender_id, m.type, m.content, m.created_at,
+			ts_rank(`+tsVector+`, `+tsQuery+`) AS rank
+		FROM group_messages m
+		JOIN group_members gm ON gm.group_id = m.group_id AND gm.user_id = @user AND gm.deleted_at IS NULL
+		WHERE `+strings.Join(where, " AND "))
+	}
+
+	sql := `SELECT hits.message_id, hits.conversation_type, hits.conversation_id, hits.thread_root_id,
+		hits.sender_id, hits.type, hits.rank, hits.created_at,
+		ts_headline('` + searchLanguage + `', replace(replace(hits.content, @highlight_start, ''), @highlight_stop, ''), ` + tsQuery + `,
+			@highlight_options) AS snippet
+	FROM (` + strings.Join(branches, " UNION ALL ") + `
+		ORDER BY rank DESC, created_at DESC, message_id
+		LIMIT @limit OFFSET @offset
+	) hits
+	ORDER BY hits.rank DESC, hits.created_at DESC, hits.message_id`
+
+	args["highlight_start"] = highlightStart
+	args["highlight_stop"] = highlightStop
+	args["highlight_options"] = `StartSel="` + highlightStart + `", StopSel="` + highlightStop + `", MaxFragments=2, MaxWords=30, MinWords=10`
+
+	var hits []SearchHit
+	if err := s.DB.Raw(sql, args).Scan(&hits).Error; err != nil {
+		return hits, err
+	}
+	for i := range hits {
+		hits[i].Snippet = highlighter.Replace(html.EscapeString(hits[i].Snippet))
+	}
+	return hits, nil
+}
@@ -0,0 +1,140 @@
+package repos
+
+import (
+	"shiplabs/schat/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type sessionRepo struct {
+	DB gorm.DB
+}
+
+type SessionRepoInterface interface {
+	BeginDBTx() *gorm.DB
+	CreateSession(txn *gorm.DB, session *models.Session) error
+	FindByID(id uuid.UUID) (models.Session, error)
+	ExtendSession(txn *gorm.DB, id uuid.UUID, expiresAt time.Time) error
+	RevokeSession(txn *gorm.DB, id uuid.UUID, reason string, at time.Time) (bool, error)
+	RevokeUserSessions(txn *gorm.DB, userID uuid.UUID, reason string, at time.Time) ([]uuid.UUID, error)
+	CreateRefreshToken(txn *gorm.DB, token *models.RefreshToken) error
+	FindRefreshToken(tokenHash string) (models.RefreshToken, error)
+	RotateRefreshToken(txn *gorm.DB, id uuid.UUID, at time.Time) (bool, error)
+	CreateConnectTicket(ticket *models.ConnectTicket) error
+	RedeemConnectTicket(tokenHash string, at time.Time) (models.ConnectTicket, bool, error)
+	PurgeConnectTickets(before time.Time) error
+}
+
+func NewSessionRepo(db gorm.DB) SessionRepoInterface {
+	return &sessionRepo{
+		DB: db,
+	}
+}
+
+func (s *sessionRepo) BeginDBTx() *gorm.DB {
+	return s.DB.Begin()
+}
+
+func (s *sessionRepo) CreateSession(txn *gorm.DB, session *models.Session) error {
+	if txn == nil {
+		return s.DB.Create(session).Error
+	}
+	return txn.Create(session).Error
+}
+
+func (s *sessionRepo) FindByID(id uuid.UUID) (models.Session, error) {
+	var session models.Session
+	err := s.DB.Where("id=?", id).First(&session).Error
+	return session, err
+}
+
+func (s *sessionRepo) ExtendSession(txn *gorm.DB, id uuid.UUID, expiresAt time.Time) error {
+	if txn == nil {
+		txn = &s.DB
+	}
+	return txn.Model(&models.Session{}).Where("id=?", id).Update("expires_at", expiresAt).Error
+}
+
+// RevokeSession reports whether this call revoked the session, false when
+// it already was.
+func (s *sessionRepo) RevokeSession(txn *gorm.DB, id uuid.UUID, reason string, at time.Time) (bool, error) {
+	if txn == nil {
+		txn = &s.DB
+	}
+	result := txn.Model(&models.Session{}).
+		Where("id=? AND revoked_at IS NULL", id).
+		Updates(map[string]any{
+			"revoked_at":    at,
+			"revoke_reason": reason,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// RevokeUserSessions revokes every live session of the user and returns
+// their IDs.
+func (s *sessionRepo) RevokeUserSessions(txn *gorm.DB, userID uuid.UUID, reason string, at time.Time) ([]uuid.UUID, error) {
+	if txn == nil {
+		txn = &s.DB
+	}
+	var sessions []models.Session
+	err := txn.Model(&sessions).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}}}).
+		Where("user_id=? AND revoked_at IS NULL", userID).
+		Updates(map[string]any{
+			"revoked_at":    at,
+			"revoke_reason": reason,
+		}).Error
+
+	ids := make([]uuid.UUID, 0, len(sessions))
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+	return ids, err
+}
+
+func (s *sessionRepo) CreateRefreshToken(txn *gorm.DB, token *models.RefreshToken) error {
+	if txn == nil {
+		return s.DB.Create(token).Error
+	}
+	return txn.Create(token).Error
+}
+
+func (s *sessionRepo) FindRefreshToken(tokenHash string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := s.DB.Where("token_hash=?", tokenHash).First(&token).Error
+	return token, err
+}
+
+// RotateRefreshToken marks the token used. Only one of several concurrent
+// refreshes with the same token gets true back.
+func (s *sessionRepo) RotateRefreshToken(txn *gorm.DB, id uuid.UUID, at time.Time) (bool, error) {
+	if txn == nil {
+		txn = &s.DB
+	}
+	result := txn.Model(&models.RefreshToken{}).
+		Where("id=? AND rotated_at IS NULL", id).
+		Update("rotated_at", at)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (s *sessionRepo) CreateConnectTicket(ticket *models.ConnectTicket) error {
+	return s.DB.Create(ticket).Error
+}
+
+// RedeemConnectTicket marks an unexpired ticket used and returns it. Only
+// the first of several redemptions of the same ticket gets true back.
+func (s *sessionRepo) RedeemConnectTicket(tokenHash string, at time.Time) (models.ConnectTicket, bool, error) {
+	var ticket models.ConnectTicket
+	result := s.DB.Model(&ticket).
+		Clauses(clause.Returning{}).
+		Where("token_hash=? AND used_at IS NULL AND expires_at > ?", tokenHash, at).
+		Update("used_at", at)
+	return ticket, result.RowsAffected > 0, result.Error
+}
+
+func (s *sessionRepo) PurgeConnectTickets(before time.Time) error {
+	return s.DB.Unscoped().Where("expires_at < ?", before).Delete(&models.ConnectTicket{}).Error
+}
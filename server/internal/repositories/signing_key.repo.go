@@ -0,0 +1,68 @@
+package repos
+
+import (
+	"shiplabs/schat/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type signingKeyRepo struct {
+	DB gorm.DB
+}
+
+type SigningKeyRepoInterface interface {
+	Publish(key *models.SigningKey) error
+	FindByKeyID(keyID string) (models.SigningKey, error)
+	GetVerifiable(retiredAfter time.Time) ([]models.SigningKey, error)
+}
+
+func NewSigningKeyRepo(db gorm.DB) SigningKeyRepoInterface {
+	return &signingKeyRepo{
+		DB: db,
+	}
+}
+
+// Publish records the key, once, and retires the keys published before it.
+// Instances all sign with the key file they share, so an older key is one
+// being rotated out. An instance still running it doesn't retire newer
+// ones, a key put back in use is active again.
+func (s *signingKeyRepo) Publish(key *models.SigningKey) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key_id"}},
+			DoNothing: true,
+		}).Create(key).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("key_id=?", key.KeyID).First(key).Error; err != nil {
+			return err
+		}
+		if key.RetiredAt != nil {
+			if err := tx.Model(key).Update("retired_at", nil).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&models.SigningKey{}).
+			Where("key_id <> ? AND retired_at IS NULL AND created_at < ?", key.KeyID, key.CreatedAt).
+			Update("retired_at", key.CreatedAt).Error
+	})
+}
+
+func (s *signingKeyRepo) FindByKeyID(keyID string) (models.SigningKey, error) {
+	var key models.SigningKey
+	err := s.DB.Where("key_id=?", keyID).First(&key).Error
+	return key, err
+}
+
+// GetVerifiable lists the keys in use or retired after the given time.
+func (s *signingKeyRepo) GetVerifiable(retiredAfter time.Time) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	err := s.DB.Where("retired_at IS NULL OR retired_at > ?", retiredAfter).
+		Order("created_at DESC").
+		Find(&keys).Error
+	return keys, err
+}
@@ -0,0 +1,152 @@
+package repos
+
+import (
+	"shiplabs/schat/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type twoFactorRepo struct {
+	DB gorm.DB
+}
+
+type TwoFactorRepoInterface interface {
+	BeginDBTx() *gorm.DB
+	FindByUserID(userID uuid.UUID) (models.TwoFactor, error)
+	SaveEnrollment(twoFactor *models.TwoFactor) (bool, error)
+	Confirm(txn *gorm.DB, userID uuid.UUID, step int64, at time.Time) (bool, error)
+	UseStep(txn *gorm.DB, userID uuid.UUID, step int64) (bool, error)
+	Delete(txn *gorm.DB, userID uuid.UUID) error
+	ReplaceRecoveryCodes(txn *gorm.DB, userID uuid.UUID, codeHashes []string) error
+	UseRecoveryCode(txn *gorm.DB, userID uuid.UUID, codeHash string, at time.Time) (bool, error)
+	CountRecoveryCodes(userID uuid.UUID) (int64, error)
+	RecordFailure(userID uuid.UUID) (int, error)
+	Lock(userID uuid.UUID, until time.Time) error
+	ResetFailures(txn *gorm.DB, userID uuid.UUID) error
+}
+
+func NewTwoFactorRepo(db gorm.DB) TwoFactorRepoInterface {
+	return &twoFactorRepo{
+		DB: db,
+	}
+}
+
+func (t *twoFactorRepo) BeginDBTx() *gorm.DB {
+	return t.DB.Begin()
+}
+
+func (t *twoFactorRepo) FindByUserID(userID uuid.UUID) (models.TwoFactor, error) {
+	var twoFactor models.TwoFactor
+	err := t.DB.Where("user_id=?", userID).First(&twoFactor).Error
+	return twoFactor, err
+}
+
+// SaveEnrollment starts over an unconfirmed enrollment with a new secret.
+// A confirmed one is left alone and false returned.
+func (t *twoFactorRepo) SaveEnrollment(twoFactor *models.TwoFactor) (bool, error) {
+	result := t.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"secret", "last_step", "updated_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "two_factors.confirmed_at IS NULL"},
+		}},
+	}).Create(twoFactor)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Confirm puts an enrollment in effect, using up the step of the code it
+// was confirmed with.
+func (t *twoFactorRepo) Confirm(txn *gorm.DB, userID uuid.UUID, step int64, at time.Time) (bool, error) {
+	if txn == nil {
+		txn = &t.DB
+	}
+	result := txn.Model(&models.TwoFactor{}).
+		Where("user_id=? AND confirmed_at IS NULL AND last_step < ?", userID, step).
+		Updates(map[string]any{
+			"confirmed_at": at,
+			"last_step":    step,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// UseStep records a step a code was accepted for. It fails for the step
+// of a code already used, or an earlier one.
+func (t *twoFactorRepo) UseStep(txn *gorm.DB, userID uuid.UUID, step int64) (bool, error) {
+	if txn == nil {
+		txn = &t.DB
+	}
+	result := txn.Model(&models.TwoFactor{}).
+		Where("user_id=? AND last_step < ?", userID, step).
+		Update("last_step", step)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (t *twoFactorRepo) Delete(txn *gorm.DB, userID uuid.UUID) error {
+	if txn == nil {
+		txn = &t.DB
+	}
+	if err := txn.Unscoped().Where("user_id=?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	return txn.Unscoped().Where("user_id=?", userID).Delete(&models.TwoFactor{}).Error
+}
+
+func (t *twoFactorRepo) ReplaceRecoveryCodes(txn *gorm.DB, userID uuid.UUID, codeHashes []string) error {
+	if txn == nil {
+		txn = &t.DB
+	}
+	if err := txn.Unscoped().Where("user_id=?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+
+	codes := make([]models.RecoveryCode, 0, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes = append(codes, models.RecoveryCode{UserID: userID, CodeHash: hash})
+	}
+	return txn.Create(&codes).Error
+}
+
+func (t *twoFactorRepo) UseRecoveryCode(txn *gorm.DB, userID uuid.UUID, codeHash string, at time.Time) (bool, error) {
+	if txn == nil {
+		txn = &t.DB
+	}
+	result := txn.Model(&models.RecoveryCode{}).
+		Where("user_id=? AND code_hash=? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", at)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (t *twoFactorRepo) CountRecoveryCodes(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := t.DB.Model(&models.RecoveryCode{}).Where("user_id=? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// RecordFailure counts a wrong code and returns the failures so far.
+func (t *twoFactorRepo) RecordFailure(userID uuid.UUID) (int, error) {
+	var twoFactor models.TwoFactor
+	err := t.DB.Model(&twoFactor).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "failed_attempts"}}}).
+		Where("user_id=?", userID).
+		Update("failed_attempts", gorm.Expr("failed_attempts + 1")).Error
+	return twoFactor.FailedAttempts, err
+}
+
+func (t *twoFactorRepo) Lock(userID uuid.UUID, until time.Time) error {
+	return t.DB.Model(&models.TwoFactor{}).Where("user_id=?", userID).Update("locked_until", until).Error
+}
+
+func (t *twoFactorRepo) ResetFailures(txn *gorm.DB, userID uuid.UUID) error {
+	if txn == nil {
+		txn = &t.DB
+	}
+	return txn.Model(&models.TwoFactor{}).
+		Where("user_id=? AND (failed_attempts > 0 OR locked_until IS NOT NULL)", userID).
+		Updates(map[string]any{
+			"failed_attempts": 0,
+			"locked_until":    nil,
+		}).Error
+}
@@ -2,6 +2,7 @@ package repos
 
 import (
 	"shiplabs/schat/internal/models"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -11,6 +12,10 @@ type UserRepoInterface interface {
 	Create(user *models.User) error
 	FindByEmail(email string) (models.User, error)
 	FindByID(id uuid.UUID) (models.User, error)
+	FindByIDs(ids []uuid.UUID) ([]models.User, error)
+	UpdateLastSeen(id uuid.UUID, at time.Time) error
+	MarkEmailVerified(txn *gorm.DB, id uuid.UUID, email string, at time.Time) error
+	UpdatePassword(txn *gorm.DB, id uuid.UUID, password string) error
 }
 
 type UserRepo struct {
@@ -38,3 +43,34 @@ func (u *UserRepo) FindByID(id uuid.UUID) (models.User, error) {
 	err := u.DB.Where("id=?", id).First(&user).Error
 	return user, err
 }
+
+func (u *UserRepo) FindByIDs(ids []uuid.UUID) ([]models.User, error) {
+	var users []models.User
+	if len(ids) == 0 {
+		return users, nil
+	}
+	err := u.DB.Where("id IN ?", ids).Find(&users).Error
+	return users, err
+}
+
+func (u *UserRepo) UpdateLastSeen(id uuid.UUID, at time.Time) error {
+	return u.DB.Model(&models.User{}).Where("id=?", id).Update("last_seen_at", at).Error
+}
+
+// MarkEmailVerified only verifies the address the token was sent to, not
+// one the user changed to since.
+func (u *UserRepo) MarkEmailVerified(txn *gorm.DB, id uuid.UUID, email string, at time.Time) error {
+	if txn == nil {
+		txn = &u.DB
+	}
+	return txn.Model(&models.User{}).
+		Where("id=? AND email=? AND email_verified_at IS NULL", id, email).
+		Update("email_verified_at", at).Error
+}
+
+func (u *UserRepo) UpdatePassword(txn *gorm.DB, id uuid.UUID, password string) error {
+	if txn == nil {
+		txn = &u.DB
+	}
+	return txn.Model(&models.User{}).Where("id=?", id).Update("password", password).Error
+}
@@ -0,0 +1,76 @@
+package repos
+
+import (
+	"shiplabs/schat/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type userTokenRepo struct {
+	DB gorm.DB
+}
+
+type UserTokenRepoInterface interface {
+	BeginDBTx() *gorm.DB
+	CreateToken(txn *gorm.DB, token *models.UserToken) error
+	ConsumeToken(txn *gorm.DB, purpose models.TokenPurpose, tokenHash string, at time.Time) (models.UserToken, bool, error)
+	InvalidateTokens(txn *gorm.DB, userID uuid.UUID, purpose models.TokenPurpose, at time.Time) error
+	AttemptToken(purpose models.TokenPurpose, tokenHash string, maxAttempts int, at time.Time) (models.UserToken, bool, error)
+}
+
+func NewUserTokenRepo(db gorm.DB) UserTokenRepoInterface {
+	return &userTokenRepo{
+		DB: db,
+	}
+}
+
+func (u *userTokenRepo) BeginDBTx() *gorm.DB {
+	return u.DB.Begin()
+}
+
+func (u *userTokenRepo) CreateToken(txn *gorm.DB, token *models.UserToken) error {
+	if txn == nil {
+		txn = &u.DB
+	}
+	return txn.Create(token).Error
+}
+
+// ConsumeToken marks an unexpired token used and returns it. Only the
+// first of several concurrent uses of the same token gets true back.
+func (u *userTokenRepo) ConsumeToken(txn *gorm.DB, purpose models.TokenPurpose, tokenHash string, at time.Time) (models.UserToken, bool, error) {
+	if txn == nil {
+		txn = &u.DB
+	}
+	var token models.UserToken
+	result := txn.Model(&token).
+		Clauses(clause.Returning{}).
+		Where("purpose=? AND token_hash=? AND used_at IS NULL AND expires_at > ?", purpose, tokenHash, at).
+		Update("used_at", at)
+	return token, result.RowsAffected > 0, result.Error
+}
+
+// InvalidateTokens uses up the user's outstanding tokens, so only the
+// latest one sent works.
+func (u *userTokenRepo) InvalidateTokens(txn *gorm.DB, userID uuid.UUID, purpose models.TokenPurpose, at time.Time) error {
+	if txn == nil {
+		txn = &u.DB
+	}
+	return txn.Model(&models.UserToken{}).
+		Where("user_id=? AND purpose=? AND used_at IS NULL", userID, purpose).
+		Update("used_at", at).Error
+}
+
+// AttemptToken counts an attempt at an unused, unexpired token and returns
+// it, false once the token is out of attempts. The attempt is counted even
+// if the caller's check then fails.
+func (u *userTokenRepo) AttemptToken(purpose models.TokenPurpose, tokenHash string, maxAttempts int, at time.Time) (models.UserToken, bool, error) {
+	var token models.UserToken
+	result := u.DB.Model(&token).
+		Clauses(clause.Returning{}).
+		Where("purpose=? AND token_hash=? AND used_at IS NULL AND expires_at > ? AND attempts < ?", purpose, tokenHash, at, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	return token, result.RowsAffected > 0, result.Error
+}
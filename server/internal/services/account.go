@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/internal/pkg/config"
+	"shiplabs/schat/internal/pkg/mailer"
+	"shiplabs/schat/pkg/shared"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	userTokenSize     = 32
+	minPasswordLength = 8
+	// bcrypt only takes the first 72 bytes into account
+	maxPasswordLength = 72
+	mailTimeout       = 10 * time.Second
+)
+
+const RevokedByPasswordReset = "password reset"
+
+var (
+	ErrInvalidEmail         = errors.New("invalid email address")
+	ErrEmailNotVerified     = errors.New("email address not verified")
+	ErrEmailAlreadyVerified = errors.New("email address already verified")
+	ErrInvalidUserToken     = errors.New("invalid, expired or already used token")
+	ErrWeakPassword         = fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	ErrPasswordTooLong      = fmt.Errorf("password must be at most %d bytes", maxPasswordLength)
+)
+
+func validEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+// checkPassword is the length long enough to guess and short enough for
+// bcrypt, which counts bytes rather than characters.
+func checkPassword(password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return ErrWeakPassword
+	}
+	if len(password) > maxPasswordLength {
+		return ErrPasswordTooLong
+	}
+	return nil
+}
+
+func (a *AuthService) VerifyEmail(token string) error {
+	return inTx(a.UserTokenRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		now := shared.TimeNow()
+		used, ok, err := a.UserTokenRepo.ConsumeToken(tx, models.VerifyEmail, shared.HashToken(token), now)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidUserToken
+		}
+		return a.UserRepo.MarkEmailVerified(tx, used.UserID, used.Email, now)
+	})
+}
+
+func (a *AuthService) ResendVerification(userID uuid.UUID) error {
+	user, err := a.UserRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+	return a.sendVerification(user)
+}
+
+// RequestPasswordReset mails a reset link if the address belongs to a
+// user. Whether it does isn't revealed, to the caller or in the timing of
+// the response, so the mail goes out in the background.
+func (a *AuthService) RequestPasswordReset(email string) error {
+	user, err := a.UserRepo.FindByEmail(email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	go func() {
+		if err := a.sendPasswordReset(user); err != nil {
+			log.Println("failed to send password reset email to user", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+// ResetPassword sets a new password and logs every session out, whoever
+// knew the old password is locked out. Receiving the link also proves the
+// email address.
+func (a *AuthService) ResetPassword(token, password string) error {
+	if err := checkPassword(password); err != nil {
+		return err
+	}
+	hashed := shared.HashData(password)
+
+	return inTx(a.UserTokenRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		now := shared.TimeNow()
+		used, ok, err := a.UserTokenRepo.ConsumeToken(tx, models.ResetPassword, shared.HashToken(token), now)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidUserToken
+		}
+
+		if err := a.UserRepo.UpdatePassword(tx, used.UserID, hashed); err != nil {
+			return err
+		}
+		if err := a.UserRepo.MarkEmailVerified(tx, used.UserID, used.Email, now); err != nil {
+			return err
+		}
+		if err := a.UserTokenRepo.InvalidateTokens(tx, used.UserID, models.ResetPassword, now); err != nil {
+			return err
+		}
+		return a.revokeAll(tx, used.UserID, RevokedByPasswordReset, now)
+	})
+}
+
+// revokeAll ends every session of the user within tx and queues the
+// notices closing their connections.
+func (a *AuthService) revokeAll(tx *gorm.DB, userID uuid.UUID, reason string, at time.Time) error {
+	sessionIDs, err := a.SessionRepo.RevokeUserSessions(tx, userID, reason, at)
+	if err != nil {
+		return err
+	}
+	for _, sessionID := range sessionIDs {
+		err := notify(tx, a.OutboxRepo, NoticeSessionRevoked, SessionRevocation{
+			SessionID: sessionID,
+			Reason:    reason,
+		}, []Recipient{{UserID: userID}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *AuthService) sendVerification(user models.User) error {
+	token, err := a.issueUserToken(user, models.VerifyEmail, config.Configs.Auth.VerifyTTL)
+	if err != nil {
+		return err
+	}
+
+	return a.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your schat email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nconfirm this is your email address by opening the link below.\n\n%s\n\nThe link expires in %s.\n",
+			user.Name, emailLink("/verify-email", token), config.Configs.Auth.VerifyTTL,
+		),
+	})
+}
+
+func (a *AuthService) sendPasswordReset(user models.User) error {
+	token, err := a.issueUserToken(user, models.ResetPassword, config.Configs.Auth.ResetTTL)
+	if err != nil {
+		return err
+	}
+
+	return a.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your schat password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nopen the link below to choose a new password.\n\n%s\n\nThe link expires in %s. If you didn't ask for it you can ignore this email.\n",
+			user.Name, emailLink("/reset-password", token), config.Configs.Auth.ResetTTL,
+		),
+	})
+}
+
+// issueUserToken replaces the user's outstanding tokens for the purpose
+// with a new one.
+func (a *AuthService) issueUserToken(user models.User, purpose models.TokenPurpose, ttl time.Duration) (string, error) {
+	token := shared.RandomToken(userTokenSize)
+	err := inTx(a.UserTokenRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		now := shared.TimeNow()
+		if err := a.UserTokenRepo.InvalidateTokens(tx, user.ID, purpose, now); err != nil {
+			return err
+		}
+		return a.UserTokenRepo.CreateToken(tx, &models.UserToken{
+			UserID:    user.ID,
+			Purpose:   purpose,
+			Email:     user.Email,
+			TokenHash: shared.HashToken(token),
+			ExpiresAt: now.Add(ttl),
+		})
+	})
+	return token, err
+}
+
+func (a *AuthService) sendMail(msg mailer.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+	return a.Mailer.Send(ctx, msg)
+}
+
+func emailLink(path, token string) string {
+	base := strings.TrimRight(config.Configs.Mail.LinkBaseURL, "/")
+	return base + path + "?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"errors"
+	"net/url"
+	"shiplabs/schat/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		want     error
+	}{
+		{"long enough", "correct horse", nil},
+		{"too short", "1234567", ErrWeakPassword},
+		{"short in bytes counts characters", strings.Repeat("é", 8), nil},
+		{"at the bcrypt limit", strings.Repeat("a", 72), nil},
+		{"past the bcrypt limit", strings.Repeat("a", 73), ErrPasswordTooLong},
+		{"past the limit in bytes only", strings.Repeat("日", 25), ErrPasswordTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkPassword(tt.password); !errors.Is(err, tt.want) {
+				t.Fatalf("checkPassword() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestPasswordTooLong checks an overlong password is turned away before it
+// reaches bcrypt, which fails on it.
+func TestPasswordTooLong(t *testing.T) {
+	service, _, _ := newTestAuthService(t)
+	password := strings.Repeat("a", 73)
+
+	if _, err := service.SignUp("Ada", "ada@example.com", password); !errors.Is(err, ErrPasswordTooLong) {
+		t.Fatalf("SignUp() error = %v, want %v", err, ErrPasswordTooLong)
+	}
+	if err := service.ResetPassword("token", password); !errors.Is(err, ErrPasswordTooLong) {
+		t.Fatalf("ResetPassword() error = %v, want %v", err, ErrPasswordTooLong)
+	}
+}
+
+// mailedToken takes the token out of the link in a mail.
+func mailedToken(t *testing.T, mail fakeMailer) string {
+	t.Helper()
+	select {
+	case msg := <-mail:
+		_, rest, ok := strings.Cut(msg.Body, "?token=")
+		if !ok {
+			t.Fatalf("no link in %q", msg.Body)
+		}
+		token, err := url.QueryUnescape(strings.Fields(rest)[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	case <-time.After(5 * time.Second):
+		t.Fatal("mail never sent")
+	}
+	return ""
+}
+
+func TestResetPassword(t *testing.T) {
+	service, sessions, mail := newTestAuthService(t)
+	users := service.UserRepo.(*fakeUsers)
+	outbox := service.OutboxRepo.(*fakeOutbox)
+	user := models.User{Name: "Ada", Email: "ada@example.com", Password: "old hash"}
+	if err := users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := service.startSession(user.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := service.RequestPasswordReset("nobody@example.com"); err != nil {
+		t.Fatalf("RequestPasswordReset() for an unknown address error = %v", err)
+	}
+	if err := service.RequestPasswordReset(user.Email); err != nil {
+		t.Fatalf("RequestPasswordReset() error = %v", err)
+	}
+	token := mailedToken(t, mail)
+
+	if err := service.ResetPassword(token, "short"); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("ResetPassword() error = %v, want %v", err, ErrWeakPassword)
+	}
+	if err := service.ResetPassword(token, "battery staple"); err != nil {
+		t.Fatalf("ResetPassword() error = %v", err)
+	}
+	if err := service.ResetPassword(token, "battery staple"); !errors.Is(err, ErrInvalidUserToken) {
+		t.Fatalf("ResetPassword() with a used token error = %v, want %v", err, ErrInvalidUserToken)
+	}
+
+	updated := users.users[user.ID]
+	if updated.Password == user.Password || updated.EmailVerifiedAt == nil {
+		t.Fatalf("user = %+v, want a new password and the email verified", updated)
+	}
+	for id, session := range sessions.sessions {
+		if session.RevokedAt == nil || session.RevokeReason != RevokedByPasswordReset {
+			t.Fatalf("session %s = %+v, want it revoked by the reset", id, session)
+		}
+	}
+	if len(outbox.events) != len(sessions.sessions) {
+		t.Fatalf("%d notices queued, want one per session", len(outbox.events))
+	}
+	select {
+	case msg := <-mail:
+		t.Fatalf("unexpected mail to %s", msg.To)
+	default:
+	}
+}
@@ -0,0 +1,407 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/internal/pkg/blob"
+	"shiplabs/schat/internal/pkg/config"
+	"shiplabs/schat/internal/pkg/media"
+	repos "shiplabs/schat/internal/repositories"
+	"shiplabs/schat/pkg/shared"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/google/uuid"
+)
+
+// sniffLen is how much of an upload is inspected to detect its real type.
+const sniffLen = 3072
+
+type UploadDto struct {
+	Type     models.ValidMsgType
+	FileName string
+	Size     int64
+	File     io.ReadSeeker
+}
+
+type AttachmentVariant string
+
+const (
+	VariantOriginal  AttachmentVariant = "original"
+	VariantThumbnail AttachmentVariant = "thumbnail"
+)
+
+// DownloadQuery selects which rendition of an attachment to fetch. The
+// expiry and signature are only used by signed links.
+type DownloadQuery struct {
+	Variant   AttachmentVariant `form:"variant"`
+	Expires   int64             `form:"expires"`
+	Signature string            `form:"signature"`
+}
+
+type AttachmentFile struct {
+	Content  io.ReadSeekCloser
+	Name     string
+	MimeType string
+	ModTime  time.Time
+}
+
+type SignedURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type attachmentService struct {
+	attachmentRepo  repos.AttachmentRepoInterface
+	privateMsgRepo  repos.PrivateMessageRepoInterface
+	groupMsgRepo    repos.GroupMessageRepoInterface
+	privateChatRepo repos.PrivateChatRepoInterface
+	groupRepo       repos.GroupRepoInterface
+	storage         blob.Storage
+}
+
+type AttachmentServiceInterface interface {
+	Upload(ctx context.Context, ownerID uuid.UUID, data UploadDto) (models.Attachment, error)
+	MaxUploadSize() int64
+	Open(ctx context.Context, userID, attachmentID uuid.UUID, variant AttachmentVariant) (AttachmentFile, error)
+	SignURL(userID, attachmentID uuid.UUID, variant AttachmentVariant) (SignedURL, error)
+	OpenSigned(ctx context.Context, attachmentID uuid.UUID, query DownloadQuery) (AttachmentFile, error)
+}
+
+func NewAttachmentService(
+	attachmentRepo repos.AttachmentRepoInterface,
+	privateMsgRepo repos.PrivateMessageRepoInterface,
+	groupMsgRepo repos.GroupMessageRepoInterface,
+	privateChatRepo repos.PrivateChatRepoInterface,
+	groupRepo repos.GroupRepoInterface,
+	storage blob.Storage,
+) AttachmentServiceInterface {
+	return &attachmentService{
+		attachmentRepo:  attachmentRepo,
+		privateMsgRepo:  privateMsgRepo,
+		groupMsgRepo:    groupMsgRepo,
+		privateChatRepo: privateChatRepo,
+		groupRepo:       groupRepo,
+		storage:         storage,
+	}
+}
+
+var (
+	ErrUnsupportedMedia = errors.New("attachment type must be image, video or audio")
+	ErrFileTooLarge     = errors.New("file exceeds the size limit for its type")
+	ErrMimeMismatch     = errors.New("file content does not match the declared type")
+	ErrInvalidVariant   = errors.New("variant must be original or thumbnail")
+	ErrNoThumbnail      = errors.New("attachment has no thumbnail")
+	ErrInvalidSignature = errors.New("invalid download signature")
+	ErrLinkExpired      = errors.New("download link has expired")
+)
+
+func sizeLimit(msgType models.ValidMsgType) int64 {
+	storageConfig := config.Configs.Storage
+	switch msgType {
+	case models.IMAGE:
+		return storageConfig.MaxImageSize
+	case models.VIDEO:
+		return storageConfig.MaxVideoSize
+	case models.AUDIO:
+		return storageConfig.MaxAudioSize
+	default:
+		return 0
+	}
+}
+
+func (a *attachmentService) MaxUploadSize() int64 {
+	return max(sizeLimit(models.IMAGE), sizeLimit(models.VIDEO), sizeLimit(models.AUDIO))
+}
+
+// Upload stores a media file after checking its size against the limit of
+// the declared type and sniffing its content to make sure it is that type.
+// Whatever metadata can be read is recorded, and images get a thumbnail.
+func (a *attachmentService) Upload(ctx context.Context, ownerID uuid.UUID, data UploadDto) (models.Attachment, error) {
+	limit := sizeLimit(data.Type)
+	if limit == 0 {
+		return models.Attachment{}, ErrUnsupportedMedia
+	}
+	if data.Size > limit {
+		return models.Attachment{}, ErrFileTooLarge
+	}
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(data.File, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return models.Attachment{}, err
+	}
+	head = head[:n]
+
+	detected := mimetype.Detect(head)
+	mimeType, ok := mediaType(detected, data.Type)
+	if !ok {
+		return models.Attachment{}, ErrMimeMismatch
+	}
+
+	attachment := models.Attachment{
+		ID:       uuid.New(),
+		OwnerID:  ownerID,
+		Type:     data.Type,
+		MimeType: mimeType,
+		Size:     data.Size,
+		FileName: path.Base(data.FileName),
+	}
+	attachment.StorageKey = path.Join("attachments", ownerID.String(), attachment.ID.String()+detected.Extension())
+
+	meta, err := media.Probe(data.File, attachment.MimeType)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	attachment.Width, attachment.Height, attachment.DurationMs = meta.Width, meta.Height, meta.DurationMs
+
+	if _, err := data.File.Seek(0, io.SeekStart); err != nil {
+		return models.Attachment{}, err
+	}
+	if err := a.storage.Put(ctx, attachment.StorageKey, data.File, data.Size, attachment.MimeType); err != nil {
+		return models.Attachment{}, err
+	}
+
+	if data.Type == models.IMAGE {
+		a.storeThumbnail(ctx, &attachment, data.File)
+	}
+
+	if err := a.attachmentRepo.Create(&attachment); err != nil {
+		a.storage.Delete(ctx, attachment.StorageKey)
+		if attachment.HasThumbnail() {
+			a.storage.Delete(ctx, attachment.ThumbnailKey)
+		}
+		return models.Attachment{}, err
+	}
+
+	return attachment, nil
+}
+
+// storeThumbnail is best effort: formats we can't decode (or images too
+// large to decode safely) are still accepted, just without a preview.
+func (a *attachmentService) storeThumbnail(ctx context.Context, attachment *models.Attachment, file io.ReadSeeker) {
+	thumb, width, height, err := media.Thumbnail(file, config.Configs.Storage.ThumbnailMax)
+	if err != nil {
+		return
+	}
+
+	key := path.Join("thumbnails", attachment.OwnerID.String(), attachment.ID.String()+".jpg")
+	if err := a.storage.Put(ctx, key, bytes.NewReader(thumb), int64(len(thumb)), "image/jpeg"); err != nil {
+		fmt.Println("Error storing thumbnail: ", err)
+		return
+	}
+	attachment.ThumbnailKey = key
+	attachment.ThumbnailWidth, attachment.ThumbnailHeight = width, height
+}
+
+// mediaTypes are the formats accepted for each attachment type. Browsers
+// render all of them without running anything embedded in them, which is
+// what makes serving them inline safe; image/svg+xml for one is left out.
+var mediaTypes = map[models.ValidMsgType][]string{
+	models.IMAGE: {"image/jpeg", "image/png", "image/gif", "image/webp"},
+	models.VIDEO: {"video/mp4", "video/webm", "video/quicktime", "video/ogg"},
+	models.AUDIO: {"audio/mpeg", "audio/mp4", "audio/x-m4a", "audio/aac", "audio/ogg", "audio/wav", "audio/flac", "audio/webm"},
+}
+
+// mediaType walks up the detected MIME hierarchy, so e.g. a container
+// detected with a more specific subtype still counts, and returns the
+// accepted type it matched.
+func mediaType(detected *mimetype.MIME, msgType models.ValidMsgType) (string, bool) {
+	for m := detected; m != nil; m = m.Parent() {
+		for _, accepted := range mediaTypes[msgType] {
+			if m.Is(accepted) {
+				return accepted, true
+			}
+		}
+	}
+	return "", false
+}
+
+// InlineType reports whether a stored type is one of the accepted media
+// types. Anything else, e.g. uploaded before the list was narrowed, is
+// only ever served as a download.
+func InlineType(mimeType string) bool {
+	for _, accepted := range mediaTypes {
+		for _, t := range accepted {
+			if strings.EqualFold(mimeType, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Open returns the requested rendition if the user may see the attachment.
+// Attachments the user has no access to are reported as not found.
+func (a *attachmentService) Open(ctx context.Context, userID, attachmentID uuid.UUID, variant AttachmentVariant) (AttachmentFile, error) {
+	attachment, err := a.attachmentRepo.FindByID(attachmentID)
+	if err != nil {
+		return AttachmentFile{}, ErrAttachmentNotFound
+	}
+
+	allowed, err := a.canAccess(attachment, userID)
+	if err != nil {
+		return AttachmentFile{}, err
+	}
+	if !allowed {
+		return AttachmentFile{}, ErrAttachmentNotFound
+	}
+
+	return a.open(ctx, attachment, variant)
+}
+
+// SignURL issues a short lived link that can be fetched without an
+// Authorization header, e.g. from an <img> or <video> tag.
+func (a *attachmentService) SignURL(userID, attachmentID uuid.UUID, variant AttachmentVariant) (SignedURL, error) {
+	variant, err := normaliseVariant(variant)
+	if err != nil {
+		return SignedURL{}, err
+	}
+
+	attachment, err := a.attachmentRepo.FindByID(attachmentID)
+	if err != nil {
+		return SignedURL{}, ErrAttachmentNotFound
+	}
+
+	allowed, err := a.canAccess(attachment, userID)
+	if err != nil {
+		return SignedURL{}, err
+	}
+	if !allowed {
+		return SignedURL{}, ErrAttachmentNotFound
+	}
+	if variant == VariantThumbnail && !attachment.HasThumbnail() {
+		return SignedURL{}, ErrNoThumbnail
+	}
+
+	expiresAt := shared.TimeNow().Add(config.Configs.Storage.URLExpiry).Truncate(time.Second)
+	signature := shared.SignData(signingKey(), signedPayload(attachment.ID, variant, expiresAt.Unix()))
+
+	query := url.Values{}
+	query.Set("variant", string(variant))
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("signature", signature)
+
+	return SignedURL{
+		URL:       "/api/v1/files/" + attachment.ID.String() + "?" + query.Encode(),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// OpenSigned serves a signed link. The signature stands in for the
+// membership check, which was done when the link was issued.
+func (a *attachmentService) OpenSigned(ctx context.Context, attachmentID uuid.UUID, query DownloadQuery) (AttachmentFile, error) {
+	variant, err := normaliseVariant(query.Variant)
+	if err != nil {
+		return AttachmentFile{}, err
+	}
+	if !shared.VerifySignature(signingKey(), signedPayload(attachmentID, variant, query.Expires), query.Signature) {
+		return AttachmentFile{}, ErrInvalidSignature
+	}
+	if shared.TimeNow().Unix() > query.Expires {
+		return AttachmentFile{}, ErrLinkExpired
+	}
+
+	attachment, err := a.attachmentRepo.FindByID(attachmentID)
+	if err != nil {
+		return AttachmentFile{}, ErrAttachmentNotFound
+	}
+
+	return a.open(ctx, attachment, variant)
+}
+
+func (a *attachmentService) open(ctx context.Context, attachment models.Attachment, variant AttachmentVariant) (AttachmentFile, error) {
+	variant, err := normaliseVariant(variant)
+	if err != nil {
+		return AttachmentFile{}, err
+	}
+
+	file := AttachmentFile{
+		Name:     attachment.FileName,
+		MimeType: attachment.MimeType,
+		ModTime:  attachment.CreatedAt,
+	}
+	key := attachment.StorageKey
+	if variant == VariantThumbnail {
+		if !attachment.HasThumbnail() {
+			return AttachmentFile{}, ErrNoThumbnail
+		}
+		key = attachment.ThumbnailKey
+		file.MimeType = "image/jpeg"
+		file.Name = strings.TrimSuffix(attachment.FileName, path.Ext(attachment.FileName)) + "_thumb.jpg"
+	}
+
+	content, _, err := a.storage.Get(ctx, key)
+	if errors.Is(err, blob.ErrObjectNotFound) {
+		return AttachmentFile{}, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return AttachmentFile{}, err
+	}
+	file.Content = content
+	return file, nil
+}
+
+// canAccess lets the uploader through, and otherwise anyone who is a
+// member of a chat or group the attachment was posted in.
+func (a *attachmentService) canAccess(attachment models.Attachment, userID uuid.UUID) (bool, error) {
+	if attachment.OwnerID == userID {
+		return true, nil
+	}
+
+	chatIDs, err := a.privateMsgRepo.ChatIDsWithAttachment(attachment.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, chatID := range chatIDs {
+		chat, err := a.privateChatRepo.FindByID(chatID)
+		if err != nil {
+			continue
+		}
+		if chat.FirstMemberID == userID || chat.SecondMemberID == userID {
+			return true, nil
+		}
+	}
+
+	groupIDs, err := a.groupMsgRepo.GroupIDsWithAttachment(attachment.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, groupID := range groupIDs {
+		if _, err := a.groupRepo.GetGroupMember(groupID, userID); err == nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func normaliseVariant(variant AttachmentVariant) (AttachmentVariant, error) {
+	switch variant {
+	case "", VariantOriginal:
+		return VariantOriginal, nil
+	case VariantThumbnail:
+		return VariantThumbnail, nil
+	default:
+		return "", ErrInvalidVariant
+	}
+}
+
+func signingKey() string {
+	if key := config.Configs.Storage.URLSigningKey; key != "" {
+		return key
+	}
+	return config.Configs.APP_SECRET
+}
+
+func signedPayload(attachmentID uuid.UUID, variant AttachmentVariant, expires int64) string {
+	return attachmentID.String() + ":" + string(variant) + ":" + strconv.FormatInt(expires, 10)
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/internal/pkg/blob"
+	"shiplabs/schat/internal/pkg/config"
+	"shiplabs/schat/pkg/shared"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestOpenSignedRejects covers the links turned away before the attachment
+// is looked up, so the service needs no repositories.
+func TestOpenSignedRejects(t *testing.T) {
+	config.Configs = &config.Config{Storage: config.Storage{URLSigningKey: "signing key"}}
+	attachmentID := uuid.New()
+	sign := func(variant AttachmentVariant, expires int64) string {
+		return shared.SignData(signingKey(), signedPayload(attachmentID, variant, expires))
+	}
+	past := shared.TimeNow().Add(-time.Minute).Unix()
+	future := shared.TimeNow().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name  string
+		query DownloadQuery
+		want  error
+	}{
+		{"expired", DownloadQuery{Expires: past, Signature: sign(VariantOriginal, past)}, ErrLinkExpired},
+		{"expiry pushed back", DownloadQuery{Expires: future, Signature: sign(VariantOriginal, past)}, ErrInvalidSignature},
+		{"variant swapped", DownloadQuery{Variant: VariantThumbnail, Expires: future, Signature: sign(VariantOriginal, future)}, ErrInvalidSignature},
+		{"unknown variant", DownloadQuery{Variant: "raw", Expires: future, Signature: sign(VariantOriginal, future)}, ErrInvalidVariant},
+		{"missing signature", DownloadQuery{Expires: future}, ErrInvalidSignature},
+		{"signed with another key", DownloadQuery{
+			Expires:   future,
+			Signature: shared.SignData("other key", attachmentID.String()+":original:"+strconv.FormatInt(future, 10)),
+		}, ErrInvalidSignature},
+	}
+	service := &attachmentService{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.OpenSigned(context.Background(), attachmentID, tt.query)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("OpenSigned() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpload(t *testing.T) {
+	var picture bytes.Buffer
+	if err := png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 640, 480))); err != nil {
+		t.Fatal(err)
+	}
+	script := []byte("<script>alert(1)</script>")
+
+	tests := []struct {
+		name     string
+		msgType  models.ValidMsgType
+		content  []byte
+		size     int64
+		wantErr  error
+		wantMime string
+	}{
+		{"image", models.IMAGE, picture.Bytes(), 0, nil, "image/png"},
+		{"text is no media", models.TEXT, picture.Bytes(), 0, ErrUnsupportedMedia, ""},
+		{"over the image limit", models.IMAGE, picture.Bytes(), 1 << 20, ErrFileTooLarge, ""},
+		{"image declared as audio", models.AUDIO, picture.Bytes(), 0, ErrMimeMismatch, ""},
+		{"html declared as an image", models.IMAGE, script, 0, ErrMimeMismatch, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Configs = &config.Config{Storage: config.Storage{
+				MaxImageSize: 64 << 10,
+				MaxVideoSize: 64 << 10,
+				MaxAudioSize: 64 << 10,
+				ThumbnailMax: 32,
+			}}
+			storage, err := blob.NewLocalStorage(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			attachments := &fakeAttachments{}
+			service := &attachmentService{attachmentRepo: attachments, storage: storage}
+
+			size := tt.size
+			if size == 0 {
+				size = int64(len(tt.content))
+			}
+			got, err := service.Upload(context.Background(), uuid.New(), UploadDto{
+				Type:     tt.msgType,
+				FileName: "../../holiday.png",
+				Size:     size,
+				File:     bytes.NewReader(tt.content),
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Upload() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(attachments.attachments) != 0 {
+					t.Fatal("rejected upload was recorded")
+				}
+				return
+			}
+
+			if got.MimeType != tt.wantMime || got.FileName != "holiday.png" {
+				t.Fatalf("Upload() = %s %q, want %s %q", got.MimeType, got.FileName, tt.wantMime, "holiday.png")
+			}
+			if got.Width != 640 || got.Height != 480 || !got.HasThumbnail() {
+				t.Fatalf("Upload() = %dx%d thumbnail %t, want 640x480 with a thumbnail", got.Width, got.Height, got.HasThumbnail())
+			}
+			stored, info, err := storage.Get(context.Background(), got.StorageKey)
+			if err != nil {
+				t.Fatalf("stored file: %v", err)
+			}
+			stored.Close()
+			if info.Size != size {
+				t.Fatalf("stored %d bytes, want %d", info.Size, size)
+			}
+		})
+	}
+}
@@ -2,49 +2,175 @@ package services
 
 import (
 	"errors"
+	"log"
 	"shiplabs/schat/internal/models"
 	"shiplabs/schat/internal/pkg/config"
+	"shiplabs/schat/internal/pkg/keyring"
+	"shiplabs/schat/internal/pkg/mailer"
 	repos "shiplabs/schat/internal/repositories"
 	"shiplabs/schat/pkg/shared"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
+const refreshTokenSize = 32
+
+const (
+	RevokedByLogout = "logout"
+	RevokedByReuse  = "refresh token reuse"
+)
+
+// AuthTokens Token is the short lived access token sent as a bearer token,
+// RefreshToken is traded for a new pair once it expires.
+type AuthTokens struct {
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	SessionID        uuid.UUID `json:"session_id"`
+}
+
+// AccessClaims ties an access token to the session it was issued for.
+type AccessClaims struct {
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// SessionRevocation tells the user's connections of a revoked session to
+// close.
+type SessionRevocation struct {
+	SessionID uuid.UUID `json:"session_id"`
+	Reason    string    `json:"reason"`
+}
+
+// LoginChallenge is handed out instead of tokens to users with two-factor
+// on, Token is traded for them together with a code.
+type LoginChallenge struct {
+	Token     string    `json:"challenge_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// LoginResult carries the tokens, or the challenge to complete first.
+type LoginResult struct {
+	*AuthTokens
+	Challenge *LoginChallenge `json:"challenge,omitempty"`
+}
+
+// SignUpResult carries the tokens, unless the email address has to be
+// verified before the first login.
+type SignUpResult struct {
+	*AuthTokens
+	PendingVerification bool `json:"pending_verification,omitempty"`
+}
+
 type AuthServiceInterface interface {
-	Login(email, password string) (string, error)
-	SignUp(name, email, password string) (string, error)
+	Login(email, password string) (LoginResult, error)
+	CompleteLogin(challengeToken, code string) (AuthTokens, error)
+	SignUp(name, email, password string) (SignUpResult, error)
+	Refresh(refreshToken string) (AuthTokens, error)
+	Logout(userID, sessionID uuid.UUID) error
+	Authenticate(accessToken string) (userID, sessionID uuid.UUID, err error)
+	JWKS() (keyring.JWKSet, error)
+	IssueConnectTicket(userID, sessionID uuid.UUID) (ConnectTicket, error)
+	RedeemConnectTicket(ticket string) (userID, sessionID uuid.UUID, err error)
+	VerifyEmail(token string) error
+	ResendVerification(userID uuid.UUID) error
+	RequestPasswordReset(email string) error
+	ResetPassword(token, password string) error
+	TwoFactorStatus(userID uuid.UUID) (TwoFactorStatus, error)
+	EnrollTwoFactor(userID uuid.UUID) (TwoFactorEnrollment, error)
+	ConfirmTwoFactor(userID uuid.UUID, code string) (RecoveryCodes, error)
+	RegenerateRecoveryCodes(userID uuid.UUID, code string) (RecoveryCodes, error)
+	DisableTwoFactor(userID uuid.UUID, code string) error
 }
 
 type AuthService struct {
-	UserRepo repos.UserRepoInterface
+	UserRepo       repos.UserRepoInterface
+	SessionRepo    repos.SessionRepoInterface
+	OutboxRepo     repos.OutboxRepoInterface
+	SigningKeyRepo repos.SigningKeyRepoInterface
+	UserTokenRepo  repos.UserTokenRepoInterface
+	TwoFactorRepo  repos.TwoFactorRepoInterface
+	Signer         *keyring.Signer
+	Mailer         mailer.Mailer
 }
 
 const (
 	ErrInvalidCredentials = "invalid credentials"
 )
 
-func NewAuthService(userRepo repos.UserRepoInterface) AuthServiceInterface {
+var (
+	ErrInvalidToken        = errors.New("invalid or expired token")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token already used, session revoked")
+	ErrSessionRevoked      = errors.New("session revoked")
+)
+
+func NewAuthService(
+	userRepo repos.UserRepoInterface,
+	sessionRepo repos.SessionRepoInterface,
+	outboxRepo repos.OutboxRepoInterface,
+	signingKeyRepo repos.SigningKeyRepoInterface,
+	userTokenRepo repos.UserTokenRepoInterface,
+	twoFactorRepo repos.TwoFactorRepoInterface,
+	signer *keyring.Signer,
+	mail mailer.Mailer,
+) AuthServiceInterface {
 	return &AuthService{
-		UserRepo: userRepo,
+		UserRepo:       userRepo,
+		SessionRepo:    sessionRepo,
+		OutboxRepo:     outboxRepo,
+		SigningKeyRepo: signingKeyRepo,
+		UserTokenRepo:  userTokenRepo,
+		TwoFactorRepo:  twoFactorRepo,
+		Signer:         signer,
+		Mailer:         mail,
 	}
 }
 
-func (a *AuthService) Login(email, password string) (string, error) {
+// Login checks the password. Users with two-factor on get a challenge to
+// complete with CompleteLogin rather than tokens.
+func (a *AuthService) Login(email, password string) (LoginResult, error) {
 	user, err := a.UserRepo.FindByEmail(email)
 	if err != nil {
-		return "", err
+		return LoginResult{}, err
 	}
 
 	if !shared.VerifyDataHash(password, user.Password) {
-		return "", errors.New(ErrInvalidCredentials)
+		return LoginResult{}, errors.New(ErrInvalidCredentials)
+	}
+	if config.Configs.Auth.RequireVerifiedEmail && user.EmailVerifiedAt == nil {
+		return LoginResult{}, ErrEmailNotVerified
 	}
 
-	return a.signJWT(user.ID)
+	twoFactor, err := a.TwoFactorRepo.FindByUserID(user.ID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return LoginResult{}, err
+	}
+	if err == nil && twoFactor.ConfirmedAt != nil {
+		return a.issueLoginChallenge(user)
+	}
+
+	tokens, err := a.startSession(user.ID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	return LoginResult{AuthTokens: &tokens}, nil
 }
 
-func (a *AuthService) SignUp(name, email, password string) (string, error) {
+// SignUp creates the account and logs it in, unless verified emails are
+// required. Then the user logs in once the address is verified.
+func (a *AuthService) SignUp(name, email, password string) (SignUpResult, error) {
+	if !validEmail(email) {
+		return SignUpResult{}, ErrInvalidEmail
+	}
+	if err := checkPassword(password); err != nil {
+		return SignUpResult{}, err
+	}
+
 	user := &models.User{
 		Email:    email,
 		Name:     name,
@@ -52,18 +178,211 @@ func (a *AuthService) SignUp(name, email, password string) (string, error) {
 	}
 
 	if err := a.UserRepo.Create(user); err != nil {
-		return "", err
+		return SignUpResult{}, err
 	}
+	// in the background, a slow relay mustn't hold up sign up and the user
+	// can ask for another mail if this one fails
+	go func() {
+		if err := a.sendVerification(*user); err != nil {
+			log.Println("failed to send verification email to user", user.ID, err)
+		}
+	}()
 
-	return a.signJWT(user.ID)
+	if config.Configs.Auth.RequireVerifiedEmail {
+		return SignUpResult{PendingVerification: true}, nil
+	}
+	tokens, err := a.startSession(user.ID)
+	if err != nil {
+		return SignUpResult{}, err
+	}
+	return SignUpResult{AuthTokens: &tokens}, nil
 }
 
-func (a *AuthService) signJWT(userId uuid.UUID) (string, error) {
-	claims := jwt.RegisteredClaims{
-		Subject:   userId.String(),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(700000)),
+func (a *AuthService) startSession(userID uuid.UUID) (AuthTokens, error) {
+	session := models.Session{
+		UserID:    userID,
+		ExpiresAt: shared.TimeNow().Add(config.Configs.Auth.RefreshTTL),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	return token.SignedString([]byte(config.Configs.APP_SECRET))
+	var refresh string
+	err := inTx(a.SessionRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		if err := a.SessionRepo.CreateSession(tx, &session); err != nil {
+			return err
+		}
+		var err error
+		refresh, err = a.issueRefreshToken(tx, session.ID, session.ExpiresAt)
+		return err
+	})
+	if err != nil {
+		return AuthTokens{}, err
+	}
+
+	return a.issueTokens(userID, session, refresh)
+}
+
+// Refresh trades a refresh token for a new pair. A refresh token works only
+// once, presenting it again means it leaked so the whole session is revoked.
+func (a *AuthService) Refresh(refreshToken string) (AuthTokens, error) {
+	token, err := a.SessionRepo.FindRefreshToken(shared.HashToken(refreshToken))
+	if err != nil {
+		return AuthTokens{}, ErrInvalidRefreshToken
+	}
+	session, err := a.SessionRepo.FindByID(token.SessionID)
+	if err != nil {
+		return AuthTokens{}, ErrInvalidRefreshToken
+	}
+	if session.RevokedAt != nil {
+		return AuthTokens{}, ErrSessionRevoked
+	}
+	if token.RotatedAt != nil {
+		return AuthTokens{}, a.revokeReused(session)
+	}
+	now := shared.TimeNow()
+	if now.After(token.ExpiresAt) || now.After(session.ExpiresAt) {
+		return AuthTokens{}, ErrInvalidRefreshToken
+	}
+
+	session.ExpiresAt = now.Add(config.Configs.Auth.RefreshTTL)
+	var refresh string
+	reused := false
+	err = inTx(a.SessionRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		rotated, err := a.SessionRepo.RotateRefreshToken(tx, token.ID, now)
+		if err != nil {
+			return err
+		}
+		if !rotated {
+			// another refresh with the same token got there first
+			reused = true
+			return ErrRefreshTokenReused
+		}
+		if err := a.SessionRepo.ExtendSession(tx, session.ID, session.ExpiresAt); err != nil {
+			return err
+		}
+		refresh, err = a.issueRefreshToken(tx, session.ID, session.ExpiresAt)
+		return err
+	})
+	if reused {
+		return AuthTokens{}, a.revokeReused(session)
+	}
+	if err != nil {
+		return AuthTokens{}, err
+	}
+
+	return a.issueTokens(session.UserID, session, refresh)
+}
+
+func (a *AuthService) revokeReused(session models.Session) error {
+	if err := a.revoke(session.UserID, session.ID, RevokedByReuse); err != nil {
+		log.Println("failed to revoke session", session.ID, err)
+	}
+	return ErrRefreshTokenReused
+}
+
+func (a *AuthService) Logout(userID, sessionID uuid.UUID) error {
+	return a.revoke(userID, sessionID, RevokedByLogout)
+}
+
+// revoke ends the session and queues the notice closing its connections.
+func (a *AuthService) revoke(userID, sessionID uuid.UUID, reason string) error {
+	return inTx(a.SessionRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		revoked, err := a.SessionRepo.RevokeSession(tx, sessionID, reason, shared.TimeNow())
+		if err != nil || !revoked {
+			return err
+		}
+		return notify(tx, a.OutboxRepo, NoticeSessionRevoked, SessionRevocation{
+			SessionID: sessionID,
+			Reason:    reason,
+		}, []Recipient{{UserID: userID}})
+	})
+}
+
+// Authenticate resolves an access token to its user and session. Besides
+// the signature the token must be unexpired, issued by and for schat, and
+// belong to a session that is neither revoked nor expired.
+func (a *AuthService) Authenticate(accessToken string) (uuid.UUID, uuid.UUID, error) {
+	authConfig := config.Configs.Auth
+	claims := AccessClaims{}
+	_, err := jwt.ParseWithClaims(accessToken, &claims, a.verificationKey,
+		jwt.WithValidMethods(keyring.Algorithms()),
+		jwt.WithIssuer(authConfig.Issuer),
+		jwt.WithAudience(authConfig.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, ErrInvalidToken
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, ErrInvalidToken
+	}
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, ErrInvalidToken
+	}
+
+	if err := a.checkSession(userID, sessionID); err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+
+	return userID, sessionID, nil
+}
+
+// checkSession makes sure the session is the user's and still live.
+func (a *AuthService) checkSession(userID, sessionID uuid.UUID) error {
+	session, err := a.SessionRepo.FindByID(sessionID)
+	if err != nil || session.UserID != userID {
+		return ErrInvalidToken
+	}
+	if session.RevokedAt != nil || shared.TimeNow().After(session.ExpiresAt) {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+func (a *AuthService) issueRefreshToken(tx *gorm.DB, sessionID uuid.UUID, expiresAt time.Time) (string, error) {
+	refresh := shared.RandomToken(refreshTokenSize)
+	err := a.SessionRepo.CreateRefreshToken(tx, &models.RefreshToken{
+		SessionID: sessionID,
+		TokenHash: shared.HashToken(refresh),
+		ExpiresAt: expiresAt,
+	})
+	return refresh, err
+}
+
+func (a *AuthService) issueTokens(userID uuid.UUID, session models.Session, refresh string) (AuthTokens, error) {
+	expiresAt := shared.TimeNow().Add(config.Configs.Auth.AccessTTL)
+	token, err := a.signJWT(userID, session.ID, expiresAt)
+	if err != nil {
+		return AuthTokens{}, err
+	}
+
+	return AuthTokens{
+		Token:            token,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refresh,
+		RefreshExpiresAt: session.ExpiresAt,
+		SessionID:        session.ID,
+	}, nil
+}
+
+func (a *AuthService) signJWT(userId, sessionID uuid.UUID, expiresAt time.Time) (string, error) {
+	if err := a.publishSigningKey(); err != nil {
+		return "", err
+	}
+
+	authConfig := config.Configs.Auth
+	claims := AccessClaims{
+		SessionID: sessionID.String(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    authConfig.Issuer,
+			Audience:  jwt.ClaimStrings{authConfig.Audience},
+			Subject:   userId.String(),
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(shared.TimeNow()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	return a.Signer.Sign(claims)
 }
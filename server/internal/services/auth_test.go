@@ -0,0 +1,169 @@
+package services
+
+import (
+	"errors"
+	"shiplabs/schat/internal/pkg/config"
+	"shiplabs/schat/internal/pkg/keyring"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestAuthService(t *testing.T) (*AuthService, *fakeSessions, fakeMailer) {
+	t.Helper()
+	config.Configs = &config.Config{Auth: config.Auth{
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: time.Hour,
+		VerifyTTL:  time.Hour,
+		ResetTTL:   time.Hour,
+		Issuer:     "schat",
+		Audience:   "schat",
+	}}
+	signer, err := keyring.GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newTestDB(t)
+	sessions := newFakeSessions(db)
+	mail := make(fakeMailer, 1)
+	return &AuthService{
+		UserRepo:       &fakeUsers{},
+		SessionRepo:    sessions,
+		OutboxRepo:     &fakeOutbox{testDB: db},
+		SigningKeyRepo: fakeSigningKeys{},
+		UserTokenRepo:  &fakeUserTokens{testDB: db},
+		Signer:         signer,
+		Mailer:         mail,
+	}, sessions, mail
+}
+
+func TestSignUp(t *testing.T) {
+	tests := []struct {
+		name           string
+		requireVerify  bool
+		wantTokens     bool
+		wantPending    bool
+		wantedSessions int
+	}{
+		{"logged in right away", false, true, false, 1},
+		{"verified email required", true, false, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sessions, mail := newTestAuthService(t)
+			config.Configs.Auth.RequireVerifiedEmail = tt.requireVerify
+
+			got, err := service.SignUp("Ada", "ada@example.com", "correct horse")
+			if err != nil {
+				t.Fatalf("SignUp() error = %v", err)
+			}
+			if (got.AuthTokens != nil) != tt.wantTokens {
+				t.Fatalf("SignUp() tokens = %+v, want tokens %t", got.AuthTokens, tt.wantTokens)
+			}
+			if got.PendingVerification != tt.wantPending {
+				t.Fatalf("PendingVerification = %t, want %t", got.PendingVerification, tt.wantPending)
+			}
+			if len(sessions.sessions) != tt.wantedSessions {
+				t.Fatalf("%d sessions started, want %d", len(sessions.sessions), tt.wantedSessions)
+			}
+
+			select {
+			case msg := <-mail:
+				if msg.To != "ada@example.com" {
+					t.Fatalf("verification mailed to %s", msg.To)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("verification mail never sent")
+			}
+		})
+	}
+}
+
+func TestSignUpInvalidEmail(t *testing.T) {
+	service, _, _ := newTestAuthService(t)
+	if _, err := service.SignUp("Ada", "not an email", "correct horse"); !errors.Is(err, ErrInvalidEmail) {
+		t.Fatalf("SignUp() error = %v, want %v", err, ErrInvalidEmail)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	rotateConcurrently := func(f *fakeSessions, id uuid.UUID) {
+		f.beforeRotate = nil
+		f.RotateRefreshToken(nil, id, time.Now())
+	}
+	expire := func(f *fakeSessions) {
+		for hash, token := range f.refresh {
+			token.ExpiresAt = time.Now().Add(-time.Minute)
+			f.refresh[hash] = token
+		}
+	}
+
+	tests := []struct {
+		name         string
+		refreshTwice bool
+		beforeRotate func(*fakeSessions, uuid.UUID)
+		prepare      func(*fakeSessions)
+		token        string
+		wantErr      error
+		wantRevoked  bool
+	}{
+		{"rotates", false, nil, nil, "", nil, false},
+		{"rotated token used again", true, nil, nil, "", ErrRefreshTokenReused, true},
+		{"lost the race to rotate", false, rotateConcurrently, nil, "", ErrRefreshTokenReused, true},
+		{"expired", false, nil, expire, "", ErrInvalidRefreshToken, false},
+		{"unknown", false, nil, nil, "not a refresh token", ErrInvalidRefreshToken, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sessions, _ := newTestAuthService(t)
+			userID := uuid.New()
+			issued, err := service.startSession(userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			token := issued.RefreshToken
+			if tt.token != "" {
+				token = tt.token
+			}
+			if tt.prepare != nil {
+				tt.prepare(sessions)
+			}
+			if tt.refreshTwice {
+				if _, err := service.Refresh(token); err != nil {
+					t.Fatalf("first Refresh() error = %v", err)
+				}
+			}
+			sessions.beforeRotate = tt.beforeRotate
+
+			got, err := service.Refresh(token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Refresh() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (got.RefreshToken == "" || got.RefreshToken == token || got.SessionID != issued.SessionID) {
+				t.Fatalf("Refresh() = %+v, want a new refresh token for session %s", got, issued.SessionID)
+			}
+
+			session := sessions.sessions[issued.SessionID]
+			if (session.RevokedAt != nil) != tt.wantRevoked {
+				t.Fatalf("session revoked = %t, want %t", session.RevokedAt != nil, tt.wantRevoked)
+			}
+			outbox := service.OutboxRepo.(*fakeOutbox)
+			if !tt.wantRevoked {
+				if len(outbox.events) != 0 {
+					t.Fatalf("%d notices queued, want none", len(outbox.events))
+				}
+				return
+			}
+			if session.RevokeReason != RevokedByReuse {
+				t.Fatalf("RevokeReason = %q, want %q", session.RevokeReason, RevokedByReuse)
+			}
+			if len(outbox.events) != 1 || outbox.events[0].Kind != NoticeSessionRevoked {
+				t.Fatalf("notices = %+v, want one %s", outbox.events, NoticeSessionRevoked)
+			}
+			if _, err := service.Refresh(issued.RefreshToken); !errors.Is(err, ErrSessionRevoked) {
+				t.Fatalf("Refresh() after the revocation error = %v, want %v", err, ErrSessionRevoked)
+			}
+		})
+	}
+}
@@ -5,13 +5,25 @@ import (
 	"log"
 	"shiplabs/schat/internal/models"
 	repos "shiplabs/schat/internal/repositories"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
+const maxClientMessageIDLength = 64
+
+// MessageDto media messages reference an uploaded attachment, Content is
+// then an optional caption. ClientMessageID is picked by the sending client,
+// sends repeating one already stored for the sender aren't stored again.
 type MessageDto struct {
-	Type    models.ValidMsgType `json:"type"`
-	Content string              `json:"content"`
+	Origin
+	ClientMessageID string              `json:"client_message_id"`
+	Type            models.ValidMsgType `json:"type"`
+	Content         string              `json:"content"`
+	AttachmentID    string              `json:"attachment_id"`
+	ReplyToID       string              `json:"reply_to_id"`
 }
 
 type PrivateMessageDto struct {
@@ -19,9 +31,25 @@ type PrivateMessageDto struct {
 	ReceiverID string `json:"receiver_id"`
 }
 
+// GroupMessageDto replies land in the parent's thread when Thread is set,
+// otherwise they are posted to the group quoting the parent.
 type GroupMessageDto struct {
 	MessageDto
 	GroupID string `json:"group_id"`
+	Thread  bool   `json:"thread"`
+}
+
+// MessageAck answers a send so the client can swap its optimistic message
+// for the stored one. Duplicate is set when the send repeated a client
+// message ID already stored, the original message is acknowledged and
+// nothing is delivered again.
+type MessageAck struct {
+	ClientMessageID string    `json:"client_message_id,omitempty"`
+	MessageID       uuid.UUID `json:"message_id"`
+	Seq             int64     `json:"seq"`
+	CreatedAt       time.Time `json:"created_at"`
+	Duplicate       bool      `json:"duplicate"`
+	Message         any       `json:"message"`
 }
 
 type chatService struct {
@@ -30,11 +58,27 @@ type chatService struct {
 	groupRepo          repos.GroupRepoInterface
 	groupMsgRepo       repos.GroupMessageRepoInterface
 	privateMessageRepo repos.PrivateMessageRepoInterface
+	deliveryRepo       repos.DeliveryRepoInterface
+	messageActionRepo  repos.MessageActionRepoInterface
+	reactionRepo       repos.ReactionRepoInterface
+	attachmentRepo     repos.AttachmentRepoInterface
+	outboxRepo         repos.OutboxRepoInterface
 }
 
 type ChatServiceInterface interface {
-	SendPrivateMsg(userID uuid.UUID, data PrivateMessageDto) error
-	SendMsgToGroup(userID uuid.UUID, data GroupMessageDto) error
+	SendPrivateMsg(userID uuid.UUID, data PrivateMessageDto) (MessageAck, error)
+	SendMsgToGroup(userID uuid.UUID, data GroupMessageDto) (MessageAck, error)
+	GetPrivateChatHistory(userID, chatID uuid.UUID, query HistoryQuery) (PrivateHistory, error)
+	GetGroupHistory(userID, groupID uuid.UUID, query HistoryQuery) (GroupHistory, error)
+	GetThreadHistory(userID, groupID, rootID uuid.UUID, query HistoryQuery) (GroupHistory, error)
+	SyncPrivateChat(userID, chatID uuid.UUID, query SyncQuery) (PrivateSync, error)
+	SyncGroup(userID, groupID uuid.UUID, query SyncQuery) (GroupSync, error)
+	GetConversations(userID uuid.UUID) ([]Conversation, error)
+	GetParticipants(conversationType models.ConversationType, conversationID uuid.UUID) ([]uuid.UUID, error)
+	EditMessage(userID uuid.UUID, data EditMessageDto) (MessageChange, error)
+	DeleteMessage(userID uuid.UUID, data DeleteMessageDto) (MessageChange, error)
+	GetEditHistory(userID uuid.UUID, ref MessageRef) ([]models.MessageEdit, error)
+	React(userID uuid.UUID, data ReactionDto, action ReactionAction) (ReactionChange, error)
 }
 
 func NewChatService(
@@ -43,6 +87,11 @@ func NewChatService(
 	groupRepo repos.GroupRepoInterface,
 	groupMsgRepo repos.GroupMessageRepoInterface,
 	privateMessageRepo repos.PrivateMessageRepoInterface,
+	deliveryRepo repos.DeliveryRepoInterface,
+	messageActionRepo repos.MessageActionRepoInterface,
+	reactionRepo repos.ReactionRepoInterface,
+	attachmentRepo repos.AttachmentRepoInterface,
+	outboxRepo repos.OutboxRepoInterface,
 ) ChatServiceInterface {
 	return &chatService{
 		userRepo:           userRepo,
@@ -50,6 +99,11 @@ func NewChatService(
 		groupRepo:          groupRepo,
 		groupMsgRepo:       groupMsgRepo,
 		privateMessageRepo: privateMessageRepo,
+		deliveryRepo:       deliveryRepo,
+		messageActionRepo:  messageActionRepo,
+		reactionRepo:       reactionRepo,
+		attachmentRepo:     attachmentRepo,
+		outboxRepo:         outboxRepo,
 	}
 }
 
@@ -57,72 +111,320 @@ var (
 	ErrUserNotFound = errors.New("user not found")
 	ErrCreatingChat = errors.New("error creating chat")
 	ErrChat404      = errors.New("chat not found")
+	ErrInvalidID    = errors.New("invalid id")
+	ErrNotMember    = errors.New("user not group member")
+	ErrSelfChat     = errors.New("cannot start a private chat with yourself")
+
+	ErrThreadNeedsParent = errors.New("thread replies need a reply_to_id")
+
+	ErrInvalidMsgType       = errors.New("invalid message type")
+	ErrEmptyMessage         = errors.New("message content is empty")
+	ErrUnexpectedAttachment = errors.New("text messages cannot carry an attachment")
+	ErrAttachmentRequired   = errors.New("media messages need an attachment_id")
+	ErrAttachmentNotFound   = errors.New("attachment not found")
+	ErrAttachmentType       = errors.New("attachment does not match the message type")
+	ErrInvalidClientMsgID   = errors.New("client_message_id is too long")
 )
 
-func (c *chatService) SendPrivateMsg(userID uuid.UUID, data PrivateMessageDto) error {
-	receiverUUID := uuid.MustParse(data.ReceiverID)
+func (c *chatService) SendPrivateMsg(userID uuid.UUID, data PrivateMessageDto) (MessageAck, error) {
+	receiverUUID, err := uuid.Parse(data.ReceiverID)
+	if err != nil {
+		return MessageAck{}, ErrInvalidID
+	}
+	if receiverUUID == userID {
+		return MessageAck{}, ErrSelfChat
+	}
+	if existing, found := c.findPrivateRetry(userID, data.ClientMessageID); found {
+		return newMessageAck(existing.BaseMessage, existing.Seq, existing, true), nil
+	}
+
+	base, err := c.newBaseMessage(userID, data.MessageDto)
+	if err != nil {
+		return MessageAck{}, err
+	}
+	msg := models.PrivateMessage{BaseMessage: base}
+
 	chat, err := c.privateChatRepo.FindChat(receiverUUID, userID)
-	if err == nil {
-		pchat := models.PrivateMessage{
-			BaseMessage: models.BaseMessage{
-				Type:     data.Type,
-				SenderID: userID,
-				Content:  data.Content,
-			},
-			ChatID: chat.ID,
+	newChat := err != nil
+	if !newChat {
+		msg.ChatID = chat.ID
+		if data.ReplyToID != "" {
+			parent, err := c.findPrivateParent(chat.ID, data.ReplyToID)
+			if err != nil {
+				return MessageAck{}, err
+			}
+			msg.ReplyToID = &parent.ID
+			msg.ReplyTo = parent.Preview()
+		}
+	} else {
+		// a brand new chat has nothing to reply to
+		if data.ReplyToID != "" {
+			return MessageAck{}, ErrMessageNotFound
+		}
+		if _, err := c.userRepo.FindByID(receiverUUID); err != nil {
+			return MessageAck{}, ErrUserNotFound
 		}
-		return c.privateMessageRepo.Create(nil, &pchat)
 	}
 
-	_, err = c.userRepo.FindByID(receiverUUID)
+	// the chat, its first message, the delivery record and the notice all
+	// commit together or not at all
+	err = inTx(c.privateChatRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		if newChat {
+			// a concurrent first message may have created the chat since
+			// FindChat, in which case this one joins it
+			privateChat, err := c.privateChatRepo.FindOrCreateChat(tx, userID, receiverUUID)
+			if err != nil {
+				log.Println(err)
+				return ErrCreatingChat
+			}
+			msg.ChatID = privateChat.ID
+		}
+		seq, err := c.privateChatRepo.NextSeq(tx, msg.ChatID)
+		if err != nil {
+			return err
+		}
+		msg.Seq = seq
+		if err := c.privateMessageRepo.Create(tx, &msg); err != nil {
+			return err
+		}
+		return c.trackPrivateDelivery(tx, msg, receiverUUID, data.Origin)
+	})
 	if err != nil {
-		return ErrUserNotFound
+		// a retry racing the original send trips the unique index
+		if existing, found := c.findPrivateRetry(userID, data.ClientMessageID); found {
+			return newMessageAck(existing.BaseMessage, existing.Seq, existing, true), nil
+		}
+		return MessageAck{}, err
 	}
 
-	privateChat := &models.PrivateChat{
-		FirstMemberID:  userID,
-		SecondMemberID: receiverUUID,
+	return newMessageAck(msg.BaseMessage, msg.Seq, msg, false), nil
+}
+
+func (c *chatService) SendMsgToGroup(userID uuid.UUID, data GroupMessageDto) (MessageAck, error) {
+	groupUUID, err := uuid.Parse(data.GroupID)
+	if err != nil {
+		return MessageAck{}, ErrInvalidID
+	}
+	if existing, found := c.findGroupRetry(userID, data.ClientMessageID); found {
+		return newMessageAck(existing.BaseMessage, existing.Seq, existing, true), nil
+	}
+	if _, err := c.groupRepo.FindByID(groupUUID); err != nil {
+		return MessageAck{}, err
+	}
+	if _, err := c.groupRepo.GetGroupMember(groupUUID, userID); err != nil {
+		return MessageAck{}, ErrNotMember
+	}
+
+	base, err := c.newBaseMessage(userID, data.MessageDto)
+	if err != nil {
+		return MessageAck{}, err
 	}
 
-	// tx := c.privateChatRepo.BeginDBTx()
-	if err := c.privateChatRepo.CreatePrivateChat(nil, privateChat); err != nil {
-		// tx.Rollback()
-		return ErrCreatingChat
+	msg := models.GroupMessage{
+		BaseMessage: base,
+		GroupID:     groupUUID,
 	}
 
-	privateMessage := &models.PrivateMessage{
-		ChatID: privateChat.ID,
-		BaseMessage: models.BaseMessage{
-			Type:     data.Type,
-			SenderID: userID,
-			Content:  data.Content,
-		},
+	if data.ReplyToID == "" {
+		if data.Thread {
+			return MessageAck{}, ErrThreadNeedsParent
+		}
+	} else {
+		parent, err := c.findGroupParent(groupUUID, data.ReplyToID)
+		if err != nil {
+			return MessageAck{}, err
+		}
+		msg.ReplyToID = &parent.ID
+		msg.ReplyTo = parent.Preview()
+
+		// replies inside a thread all hang off the thread's root message
+		if data.Thread {
+			rootID := parent.ID
+			if parent.ThreadRootID != nil {
+				rootID = *parent.ThreadRootID
+			}
+			msg.ThreadRootID = &rootID
+		}
 	}
-	if err := c.privateMessageRepo.Create(nil, privateMessage); err != nil {
-		log.Println(err)
-		// tx.Rollback()
-		return ErrCreatingChat
+
+	err = inTx(c.groupMsgRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		seq, err := c.groupRepo.NextSeq(tx, groupUUID)
+		if err != nil {
+			return err
+		}
+		msg.Seq = seq
+		if err := c.groupMsgRepo.Create(tx, &msg); err != nil {
+			return err
+		}
+		if msg.ThreadRootID != nil {
+			if err := c.groupMsgRepo.IncrementReplyCount(tx, *msg.ThreadRootID); err != nil {
+				return err
+			}
+		}
+		return c.trackGroupDelivery(tx, msg, data.Origin)
+	})
+	if err != nil {
+		if existing, found := c.findGroupRetry(userID, data.ClientMessageID); found {
+			return newMessageAck(existing.BaseMessage, existing.Seq, existing, true), nil
+		}
+		return MessageAck{}, err
 	}
 
-	// tx.Commit()
+	return newMessageAck(msg.BaseMessage, msg.Seq, msg, false), nil
+}
 
-	return nil
+// findPrivateRetry looks up the message a send with this client message ID
+// already stored.
+func (c *chatService) findPrivateRetry(senderID uuid.UUID, clientMessageID string) (models.PrivateMessage, bool) {
+	if clientMessageID == "" {
+		return models.PrivateMessage{}, false
+	}
+	msg, err := c.privateMessageRepo.FindByClientID(senderID, clientMessageID)
+	return msg, err == nil
 }
 
-func (c *chatService) SendMsgToGroup(userID uuid.UUID, data GroupMessageDto) error {
-	groupUUID := uuid.MustParse(data.GroupID)
-	_, err := c.groupRepo.FindByID(groupUUID)
+func (c *chatService) findGroupRetry(senderID uuid.UUID, clientMessageID string) (models.GroupMessage, bool) {
+	if clientMessageID == "" {
+		return models.GroupMessage{}, false
+	}
+	msg, err := c.groupMsgRepo.FindByClientID(senderID, clientMessageID)
+	return msg, err == nil
+}
+
+func newMessageAck(base models.BaseMessage, seq int64, msg any, duplicate bool) MessageAck {
+	ack := MessageAck{
+		MessageID: base.ID,
+		Seq:       seq,
+		CreatedAt: base.CreatedAt,
+		Duplicate: duplicate,
+		Message:   msg,
+	}
+	if base.ClientMessageID != nil {
+		ack.ClientMessageID = *base.ClientMessageID
+	}
+	return ack
+}
+
+// newBaseMessage validates the content of an outgoing message and resolves
+// the attachment media messages point at.
+func (c *chatService) newBaseMessage(senderID uuid.UUID, data MessageDto) (models.BaseMessage, error) {
+	base := models.BaseMessage{
+		Type:     data.Type,
+		SenderID: senderID,
+		Content:  data.Content,
+	}
+	if data.ClientMessageID != "" {
+		if len(data.ClientMessageID) > maxClientMessageIDLength {
+			return base, ErrInvalidClientMsgID
+		}
+		base.ClientMessageID = &data.ClientMessageID
+	}
+
+	switch data.Type {
+	case models.TEXT:
+		if data.AttachmentID != "" {
+			return base, ErrUnexpectedAttachment
+		}
+		if strings.TrimSpace(data.Content) == "" {
+			return base, ErrEmptyMessage
+		}
+		return base, nil
+	case models.IMAGE, models.VIDEO, models.AUDIO:
+	default:
+		return base, ErrInvalidMsgType
+	}
+
+	attachmentID, err := uuid.Parse(data.AttachmentID)
+	if err != nil {
+		return base, ErrAttachmentRequired
+	}
+	attachment, err := c.attachmentRepo.FindByID(attachmentID)
+	if err != nil || attachment.OwnerID != senderID {
+		return base, ErrAttachmentNotFound
+	}
+	if attachment.Type != data.Type {
+		return base, ErrAttachmentType
+	}
+
+	base.AttachmentID = &attachment.ID
+	base.Attachment = &attachment
+	return base, nil
+}
+
+func (c *chatService) findPrivateParent(chatID uuid.UUID, rawID string) (models.PrivateMessage, error) {
+	parentID, err := uuid.Parse(rawID)
+	if err != nil {
+		return models.PrivateMessage{}, ErrInvalidID
+	}
+	msgs, err := c.privateMessageRepo.FindByIDs([]uuid.UUID{parentID})
+	if err != nil || len(msgs) == 0 || msgs[0].ChatID != chatID {
+		return models.PrivateMessage{}, ErrMessageNotFound
+	}
+	return msgs[0], nil
+}
+
+func (c *chatService) findGroupParent(groupID uuid.UUID, rawID string) (models.GroupMessage, error) {
+	parentID, err := uuid.Parse(rawID)
+	if err != nil {
+		return models.GroupMessage{}, ErrInvalidID
+	}
+	msgs, err := c.groupMsgRepo.FindByIDs([]uuid.UUID{parentID})
+	if err != nil || len(msgs) == 0 || msgs[0].GroupID != groupID {
+		return models.GroupMessage{}, ErrMessageNotFound
+	}
+	return msgs[0], nil
+}
+
+// trackPrivateDelivery records the pending delivery to the receiver and
+// queues the message for them and for the sender's other devices.
+func (c *chatService) trackPrivateDelivery(tx *gorm.DB, msg models.PrivateMessage, receiverID uuid.UUID, origin Origin) error {
+	err := c.deliveryRepo.CreateDeliveries(tx, []models.MessageDelivery{{
+		MessageID:        msg.ID,
+		RecipientID:      receiverID,
+		ConversationType: models.PrivateConversation,
+		ConversationID:   msg.ChatID,
+		SenderID:         msg.SenderID,
+	}})
+	if err != nil {
+		return err
+	}
+
+	return notifyMessage(tx, c.outboxRepo, NoticePrivateMessage, msg.ID, msg, []Recipient{
+		{UserID: receiverID, Track: true},
+		{UserID: msg.SenderID, SkipDevice: origin.DeviceID},
+	})
+}
+
+// trackGroupDelivery does the same for every other member. Muting the group
+// silences the notice, except for replies to the member's own message.
+func (c *chatService) trackGroupDelivery(tx *gorm.DB, msg models.GroupMessage, origin Origin) error {
+	members, err := c.groupRepo.GetGroupMembers(msg.GroupID)
 	if err != nil {
 		return err
 	}
 
-	msg := &models.GroupMessage{
-		BaseMessage: models.BaseMessage{
-			Type:     data.Type,
-			SenderID: userID,
-		},
-		GroupID: groupUUID,
+	var deliveries []models.MessageDelivery
+	recipients := []Recipient{{UserID: msg.SenderID, SkipDevice: origin.DeviceID}}
+	for _, member := range members {
+		if member.UserID == msg.SenderID {
+			continue
+		}
+		deliveries = append(deliveries, models.MessageDelivery{
+			MessageID:        msg.ID,
+			RecipientID:      member.UserID,
+			ConversationType: models.GroupConversation,
+			ConversationID:   msg.GroupID,
+			SenderID:         msg.SenderID,
+		})
+		recipients = append(recipients, Recipient{
+			UserID: member.UserID,
+			Silent: member.Muted && (msg.ReplyTo == nil || msg.ReplyTo.SenderID != member.UserID),
+			Track:  true,
+		})
 	}
 
-	return c.groupMsgRepo.Create(msg)
+	if err := c.deliveryRepo.CreateDeliveries(tx, deliveries); err != nil {
+		return err
+	}
+	return notifyMessage(tx, c.outboxRepo, NoticeGroupMessage, msg.ID, msg, recipients)
 }
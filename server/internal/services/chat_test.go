@@ -0,0 +1,205 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"shiplabs/schat/internal/models"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSendGroupReply(t *testing.T) {
+	sender, author, bystander := uuid.New(), uuid.New(), uuid.New()
+	group := models.Group{ID: uuid.New()}
+	root := models.GroupMessage{GroupID: group.ID}
+	root.ID, root.SenderID, root.Content = uuid.New(), author, "root"
+	inThread := models.GroupMessage{GroupID: group.ID, ThreadRootID: &root.ID}
+	inThread.ID, inThread.SenderID, inThread.Content = uuid.New(), bystander, "first reply"
+	foreign := models.GroupMessage{GroupID: uuid.New()}
+	foreign.ID, foreign.SenderID = uuid.New(), author
+
+	tests := []struct {
+		name           string
+		replyTo        uuid.UUID
+		thread         bool
+		wantErr        error
+		wantThreadRoot *uuid.UUID
+		wantQuoted     uuid.UUID
+		wantReplyCount int
+	}{
+		{"quote in the group", root.ID, false, nil, nil, author, 0},
+		{"start a thread", root.ID, true, nil, &root.ID, author, 1},
+		{"reply inside a thread", inThread.ID, true, nil, &root.ID, bystander, 1},
+		{"thread without a parent", uuid.Nil, true, ErrThreadNeedsParent, nil, uuid.Nil, 0},
+		{"parent in another group", foreign.ID, false, ErrMessageNotFound, nil, uuid.Nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			groups := newFakeGroups(db, group)
+			groups.members = []models.GroupMember{
+				{GroupID: group.ID, UserID: sender},
+				{GroupID: group.ID, UserID: author},
+				{GroupID: group.ID, UserID: bystander},
+			}
+			messages := newFakeGroupMessages(db, root, inThread, foreign)
+			service := &chatService{
+				groupRepo:    groups,
+				groupMsgRepo: messages,
+				deliveryRepo: &fakeDeliveries{testDB: db},
+				outboxRepo:   &fakeOutbox{testDB: db},
+			}
+
+			data := GroupMessageDto{
+				MessageDto: MessageDto{Type: models.TEXT, Content: "reply"},
+				GroupID:    group.ID.String(),
+				Thread:     tt.thread,
+			}
+			if tt.replyTo != uuid.Nil {
+				data.ReplyToID = tt.replyTo.String()
+			}
+			ack, err := service.SendMsgToGroup(sender, data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SendMsgToGroup() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			stored := messages.messages[ack.MessageID]
+			if (stored.ThreadRootID == nil) != (tt.wantThreadRoot == nil) ||
+				stored.ThreadRootID != nil && *stored.ThreadRootID != *tt.wantThreadRoot {
+				t.Fatalf("ThreadRootID = %v, want %v", stored.ThreadRootID, tt.wantThreadRoot)
+			}
+			if stored.ReplyTo == nil || stored.ReplyTo.ID != tt.replyTo || stored.ReplyTo.SenderID != tt.wantQuoted {
+				t.Fatalf("ReplyTo = %+v, want %s by %s", stored.ReplyTo, tt.replyTo, tt.wantQuoted)
+			}
+			if got := messages.messages[root.ID].ReplyCount; got != tt.wantReplyCount {
+				t.Fatalf("root ReplyCount = %d, want %d", got, tt.wantReplyCount)
+			}
+		})
+	}
+}
+
+// Muting a group silences its messages, but not replies to the member's own
+// message.
+func TestSendGroupReplyToMutedAuthor(t *testing.T) {
+	sender, author, bystander := uuid.New(), uuid.New(), uuid.New()
+	group := models.Group{ID: uuid.New()}
+	parent := models.GroupMessage{GroupID: group.ID}
+	parent.ID, parent.SenderID = uuid.New(), author
+
+	db := newTestDB(t)
+	groups := newFakeGroups(db, group)
+	groups.members = []models.GroupMember{
+		{GroupID: group.ID, UserID: sender},
+		{GroupID: group.ID, UserID: author, Muted: true},
+		{GroupID: group.ID, UserID: bystander, Muted: true},
+	}
+	outbox := &fakeOutbox{testDB: db}
+	service := &chatService{
+		groupRepo:    groups,
+		groupMsgRepo: newFakeGroupMessages(db, parent),
+		deliveryRepo: &fakeDeliveries{testDB: db},
+		outboxRepo:   outbox,
+	}
+
+	_, err := service.SendMsgToGroup(sender, GroupMessageDto{
+		MessageDto: MessageDto{Type: models.TEXT, Content: "reply", ReplyToID: parent.ID.String()},
+		GroupID:    group.ID.String(),
+	})
+	if err != nil {
+		t.Fatalf("SendMsgToGroup() error = %v", err)
+	}
+	if len(outbox.events) != 1 {
+		t.Fatalf("%d notices queued, want 1", len(outbox.events))
+	}
+
+	var recipients []Recipient
+	if err := json.Unmarshal(outbox.events[0].Recipients, &recipients); err != nil {
+		t.Fatal(err)
+	}
+	silent := map[uuid.UUID]bool{}
+	for _, recipient := range recipients {
+		silent[recipient.UserID] = recipient.Silent
+	}
+	want := map[uuid.UUID]bool{sender: false, author: false, bystander: true}
+	for userID, wantSilent := range want {
+		if got, ok := silent[userID]; !ok || got != wantSilent {
+			t.Fatalf("recipient %s silent = %t (addressed %t), want %t", userID, got, ok, wantSilent)
+		}
+	}
+}
+
+func TestSendPrivateIdempotent(t *testing.T) {
+	sender, receiver, stranger := uuid.New(), uuid.New(), uuid.New()
+	clientID := "c0ffee"
+	stored := func(senderID uuid.UUID) models.PrivateMessage {
+		message := models.PrivateMessage{ChatID: uuid.New(), Seq: 7}
+		message.ID, message.SenderID, message.ClientMessageID = uuid.New(), senderID, &clientID
+		return message
+	}
+	original := stored(sender)
+	storeOriginal := func(f *fakePrivateMessages) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.messages[original.ID] = original
+	}
+
+	tests := []struct {
+		name          string
+		clientID      string
+		stored        []models.PrivateMessage
+		beforeCreate  func(*fakePrivateMessages)
+		wantErr       error
+		wantDuplicate bool
+		wantNotices   int
+	}{
+		{"first send", clientID, nil, nil, nil, false, 1},
+		{"retry", clientID, []models.PrivateMessage{original}, nil, nil, true, 0},
+		{"retry racing the original", clientID, nil, storeOriginal, nil, true, 0},
+		{"same id from someone else", clientID, []models.PrivateMessage{stored(stranger)}, nil, nil, false, 1},
+		{"no client id", "", []models.PrivateMessage{original}, nil, nil, false, 1},
+		{"client id too long", strings.Repeat("x", maxClientMessageIDLength+1), nil, nil, ErrInvalidClientMsgID, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			messages := newFakePrivateMessages(tt.stored...)
+			messages.beforeCreate = tt.beforeCreate
+			outbox := &fakeOutbox{testDB: db}
+			service := &chatService{
+				userRepo:           &fakeUsers{users: map[uuid.UUID]models.User{receiver: {ID: receiver}}},
+				privateChatRepo:    newFakePrivateChats(db),
+				privateMessageRepo: messages,
+				deliveryRepo:       &fakeDeliveries{testDB: db},
+				outboxRepo:         outbox,
+			}
+
+			ack, err := service.SendPrivateMsg(sender, PrivateMessageDto{
+				MessageDto: MessageDto{ClientMessageID: tt.clientID, Type: models.TEXT, Content: "hi"},
+				ReceiverID: receiver.String(),
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SendPrivateMsg() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if ack.Duplicate != tt.wantDuplicate || ack.ClientMessageID != tt.clientID {
+				t.Fatalf("ack = duplicate %t client id %q, want %t %q", ack.Duplicate, ack.ClientMessageID, tt.wantDuplicate, tt.clientID)
+			}
+			if tt.wantDuplicate && (ack.MessageID != original.ID || ack.Seq != original.Seq) {
+				t.Fatalf("ack = %s seq %d, want the original %s seq %d", ack.MessageID, ack.Seq, original.ID, original.Seq)
+			}
+			if !tt.wantDuplicate && (ack.MessageID == uuid.Nil || ack.CreatedAt.IsZero()) {
+				t.Fatalf("ack = %+v, want the stored message's id and time", ack)
+			}
+			if len(outbox.events) != tt.wantNotices {
+				t.Fatalf("%d notices queued, want %d", len(outbox.events), tt.wantNotices)
+			}
+		})
+	}
+}
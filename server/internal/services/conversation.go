@@ -0,0 +1,113 @@
+package services
+
+import (
+	"shiplabs/schat/internal/models"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserSummary struct {
+	ID    uuid.UUID `json:"id"`
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+}
+
+// Conversation is one inbox entry, either a private chat or a group.
+type Conversation struct {
+	Type           models.ConversationType `json:"type"`
+	ID             uuid.UUID               `json:"id"`
+	Counterpart    *UserSummary            `json:"counterpart,omitempty"`
+	Group          *models.Group           `json:"group,omitempty"`
+	LastMessage    any                     `json:"last_message"`
+	UnreadCount    int64                   `json:"unread_count"`
+	LastActivityAt time.Time               `json:"last_activity_at"`
+}
+
+func (c *chatService) GetConversations(userID uuid.UUID) ([]Conversation, error) {
+	chats, err := c.privateChatRepo.GetUserPrivateChats(userID)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := c.groupRepo.GetUserGroups(userID)
+	if err != nil {
+		return nil, err
+	}
+	unread, err := c.deliveryRepo.CountUnread(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	chatIDs := make([]uuid.UUID, 0, len(chats))
+	for _, chat := range chats {
+		chatIDs = append(chatIDs, chat.ID)
+	}
+	groupIDs := make([]uuid.UUID, 0, len(groups))
+	for _, group := range groups {
+		groupIDs = append(groupIDs, group.ID)
+	}
+
+	lastPrivate, err := c.privateMessageRepo.GetLastMessages(chatIDs, userID)
+	if err != nil {
+		return nil, err
+	}
+	lastGroup, err := c.groupMsgRepo.GetLastMessages(groupIDs, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	privateByChat := map[uuid.UUID]models.PrivateMessage{}
+	for _, msg := range lastPrivate {
+		privateByChat[msg.ChatID] = msg
+	}
+	groupByID := map[uuid.UUID]models.GroupMessage{}
+	for _, msg := range lastGroup {
+		groupByID[msg.GroupID] = msg
+	}
+
+	conversations := make([]Conversation, 0, len(chats)+len(groups))
+	for _, chat := range chats {
+		counterpart := chat.SecondMember
+		if chat.SecondMemberID == userID {
+			counterpart = chat.FirstMember
+		}
+		conv := Conversation{
+			Type: models.PrivateConversation,
+			ID:   chat.ID,
+			Counterpart: &UserSummary{
+				ID:    counterpart.ID,
+				Name:  counterpart.Name,
+				Email: counterpart.Email,
+			},
+			UnreadCount:    unread[chat.ID],
+			LastActivityAt: chat.CreatedAt,
+		}
+		if msg, ok := privateByChat[chat.ID]; ok {
+			conv.LastMessage = msg
+			conv.LastActivityAt = msg.CreatedAt
+		}
+		conversations = append(conversations, conv)
+	}
+	for i := range groups {
+		group := groups[i]
+		conv := Conversation{
+			Type:           models.GroupConversation,
+			ID:             group.ID,
+			Group:          &group,
+			UnreadCount:    unread[group.ID],
+			LastActivityAt: group.CreatedAt,
+		}
+		if msg, ok := groupByID[group.ID]; ok {
+			conv.LastMessage = msg
+			conv.LastActivityAt = msg.CreatedAt
+		}
+		conversations = append(conversations, conv)
+	}
+
+	sort.SliceStable(conversations, func(i, j int) bool {
+		return conversations[i].LastActivityAt.After(conversations[j].LastActivityAt)
+	})
+
+	return conversations, nil
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"shiplabs/schat/internal/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestGetConversations(t *testing.T) {
+	user := models.User{ID: uuid.New(), Name: "Ada"}
+	grace := models.User{ID: uuid.New(), Name: "Grace"}
+	alan := models.User{ID: uuid.New(), Name: "Alan"}
+	at := func(minute int) time.Time {
+		return time.Date(2026, 10, 18, 9, minute, 0, 0, time.UTC)
+	}
+
+	// quiet was opened first and never used, busy has the newest message
+	quiet := models.PrivateChat{ID: uuid.New(), FirstMemberID: user.ID, FirstMember: user,
+		SecondMemberID: alan.ID, SecondMember: alan, CreatedAt: at(0)}
+	busy := models.PrivateChat{ID: uuid.New(), FirstMemberID: grace.ID, FirstMember: grace,
+		SecondMemberID: user.ID, SecondMember: user, CreatedAt: at(1)}
+	group := models.Group{ID: uuid.New(), Name: "compilers", CreatedAt: at(2)}
+	elsewhere := models.Group{ID: uuid.New(), Name: "not a member", CreatedAt: at(9)}
+
+	older := models.PrivateMessage{ChatID: busy.ID}
+	older.ID, older.Content, older.CreatedAt = uuid.New(), "hello", at(3)
+	newest := models.PrivateMessage{ChatID: busy.ID}
+	newest.ID, newest.Content, newest.CreatedAt = uuid.New(), "are you there?", at(5)
+	posted := models.GroupMessage{GroupID: group.ID}
+	posted.ID, posted.Content, posted.CreatedAt = uuid.New(), "lexer done", at(4)
+
+	db := newTestDB(t)
+	groups := newFakeGroups(db, group, elsewhere)
+	groups.members = []models.GroupMember{{GroupID: group.ID, UserID: user.ID}}
+	unread := func(conversationID, messageID uuid.UUID) models.MessageDelivery {
+		return models.MessageDelivery{ConversationID: conversationID, MessageID: messageID, RecipientID: user.ID}
+	}
+	service := &chatService{
+		privateChatRepo:    newFakePrivateChats(db, quiet, busy),
+		groupRepo:          groups,
+		privateMessageRepo: newFakePrivateMessages(older, newest),
+		groupMsgRepo:       newFakeGroupMessages(db, posted),
+		deliveryRepo: &fakeDeliveries{testDB: db, deliveries: []models.MessageDelivery{
+			unread(busy.ID, older.ID), unread(busy.ID, newest.ID), unread(group.ID, posted.ID),
+		}},
+	}
+
+	got, err := service.GetConversations(user.ID)
+	if err != nil {
+		t.Fatalf("GetConversations() error = %v", err)
+	}
+
+	want := []struct {
+		id             uuid.UUID
+		counterpart    uuid.UUID
+		lastMessage    uuid.UUID
+		unread         int64
+		lastActivityAt time.Time
+	}{
+		{busy.ID, grace.ID, newest.ID, 2, at(5)},
+		{group.ID, uuid.Nil, posted.ID, 1, at(4)},
+		{quiet.ID, alan.ID, uuid.Nil, 0, at(0)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("GetConversations() returned %d conversations, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		conv := got[i]
+		if conv.ID != w.id {
+			t.Fatalf("conversation %d = %s, want %s", i, conv.ID, w.id)
+		}
+		if counterpart := conv.Counterpart; (counterpart == nil) != (w.counterpart == uuid.Nil) ||
+			counterpart != nil && counterpart.ID != w.counterpart {
+			t.Fatalf("conversation %d counterpart = %+v, want %s", i, counterpart, w.counterpart)
+		}
+		if id := lastMessageID(conv.LastMessage); id != w.lastMessage {
+			t.Fatalf("conversation %d last message = %s, want %s", i, id, w.lastMessage)
+		}
+		if conv.UnreadCount != w.unread {
+			t.Fatalf("conversation %d unread = %d, want %d", i, conv.UnreadCount, w.unread)
+		}
+		if !conv.LastActivityAt.Equal(w.lastActivityAt) {
+			t.Fatalf("conversation %d last activity = %s, want %s", i, conv.LastActivityAt, w.lastActivityAt)
+		}
+	}
+}
+
+func lastMessageID(message any) uuid.UUID {
+	switch m := message.(type) {
+	case models.PrivateMessage:
+		return m.ID
+	case models.GroupMessage:
+		return m.ID
+	}
+	return uuid.Nil
+}
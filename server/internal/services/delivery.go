@@ -0,0 +1,251 @@
+package services
+
+import (
+	"errors"
+	"shiplabs/schat/internal/models"
+	repos "shiplabs/schat/internal/repositories"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const undeliveredBatchSize = 200
+
+type ReceiptDto struct {
+	MessageID string               `json:"message_id"`
+	Status    models.ReceiptStatus `json:"status"`
+}
+
+// ReceiptUpdate is pushed to a message sender when one recipient's receipts
+// in a conversation advance.
+type ReceiptUpdate struct {
+	ConversationType models.ConversationType `json:"conversation_type"`
+	ConversationID   uuid.UUID               `json:"conversation_id"`
+	SenderID         uuid.UUID               `json:"sender_id"`
+	RecipientID      uuid.UUID               `json:"recipient_id"`
+	Status           models.ReceiptStatus    `json:"status"`
+	MessageIDs       []uuid.UUID             `json:"message_ids"`
+	At               time.Time               `json:"at"`
+}
+
+type GroupReceipts struct {
+	MessageID      uuid.UUID                `json:"message_id"`
+	Total          int                      `json:"total"`
+	DeliveredCount int                      `json:"delivered_count"`
+	ReadCount      int                      `json:"read_count"`
+	Members        []models.MessageDelivery `json:"members"`
+}
+
+type PendingMessage struct {
+	MessageID        uuid.UUID
+	ConversationType models.ConversationType
+	Message          any
+}
+
+type deliveryService struct {
+	deliveryRepo       repos.DeliveryRepoInterface
+	privateMessageRepo repos.PrivateMessageRepoInterface
+	groupMsgRepo       repos.GroupMessageRepoInterface
+	groupRepo          repos.GroupRepoInterface
+	outboxRepo         repos.OutboxRepoInterface
+}
+
+type DeliveryServiceInterface interface {
+	GetUndelivered(userID uuid.UUID) ([]PendingMessage, error)
+	MarkDelivered(userID uuid.UUID, messageIDs ...uuid.UUID) ([]ReceiptUpdate, error)
+	Acknowledge(userID uuid.UUID, data ReceiptDto) ([]ReceiptUpdate, error)
+	GetGroupReceipts(userID, groupID, messageID uuid.UUID) (GroupReceipts, error)
+}
+
+func NewDeliveryService(
+	deliveryRepo repos.DeliveryRepoInterface,
+	privateMessageRepo repos.PrivateMessageRepoInterface,
+	groupMsgRepo repos.GroupMessageRepoInterface,
+	groupRepo repos.GroupRepoInterface,
+	outboxRepo repos.OutboxRepoInterface,
+) DeliveryServiceInterface {
+	return &deliveryService{
+		deliveryRepo:       deliveryRepo,
+		privateMessageRepo: privateMessageRepo,
+		groupMsgRepo:       groupMsgRepo,
+		groupRepo:          groupRepo,
+		outboxRepo:         outboxRepo,
+	}
+}
+
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	ErrInvalidReceipt  = errors.New("receipt status must be delivered or read")
+)
+
+// GetUndelivered returns the next batch of messages the user has not
+// received yet, oldest first. Deliveries whose message no longer exists are
+// settled here so they don't block the queue.
+func (d *deliveryService) GetUndelivered(userID uuid.UUID) ([]PendingMessage, error) {
+	deliveries, err := d.deliveryRepo.GetUndelivered(userID, undeliveredBatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var privateIDs, groupIDs []uuid.UUID
+	for _, delivery := range deliveries {
+		if delivery.ConversationType == models.GroupConversation {
+			groupIDs = append(groupIDs, delivery.MessageID)
+		} else {
+			privateIDs = append(privateIDs, delivery.MessageID)
+		}
+	}
+
+	privateMsgs, err := d.privateMessageRepo.FindByIDs(privateIDs)
+	if err != nil {
+		return nil, err
+	}
+	groupMsgs, err := d.groupMsgRepo.FindByIDs(groupIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := map[uuid.UUID]any{}
+	for _, m := range privateMsgs {
+		messages[m.ID] = m
+	}
+	for _, m := range groupMsgs {
+		messages[m.ID] = m
+	}
+
+	var pending []PendingMessage
+	var missing []uuid.UUID
+	for _, delivery := range deliveries {
+		msg, ok := messages[delivery.MessageID]
+		if !ok {
+			missing = append(missing, delivery.MessageID)
+			continue
+		}
+		pending = append(pending, PendingMessage{
+			MessageID:        delivery.MessageID,
+			ConversationType: delivery.ConversationType,
+			Message:          msg,
+		})
+	}
+
+	if _, err := d.deliveryRepo.MarkDelivered(userID, missing); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+func (d *deliveryService) MarkDelivered(userID uuid.UUID, messageIDs ...uuid.UUID) ([]ReceiptUpdate, error) {
+	updated, err := d.deliveryRepo.MarkDelivered(userID, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	return buildReceiptUpdates(updated, models.Delivered), nil
+}
+
+// Acknowledge advances the caller's receipts up to and including the given
+// message, so clients only need to acknowledge the newest message they saw.
+func (d *deliveryService) Acknowledge(userID uuid.UUID, data ReceiptDto) ([]ReceiptUpdate, error) {
+	if data.Status != models.Delivered && data.Status != models.Read {
+		return nil, ErrInvalidReceipt
+	}
+	messageID, err := uuid.Parse(data.MessageID)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	upTo, err := d.deliveryRepo.FindDelivery(messageID, userID)
+	if err != nil {
+		return nil, ErrMessageNotFound
+	}
+
+	var updates []ReceiptUpdate
+	err = inTx(d.deliveryRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		updated, err := d.deliveryRepo.MarkUpTo(tx, upTo, data.Status)
+		if err != nil {
+			return err
+		}
+
+		updates = buildReceiptUpdates(updated, data.Status)
+		for _, update := range updates {
+			if err := notify(tx, d.outboxRepo, NoticeReceipt, update, []Recipient{{UserID: update.SenderID}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updates, nil
+}
+
+func (d *deliveryService) GetGroupReceipts(userID, groupID, messageID uuid.UUID) (GroupReceipts, error) {
+	receipts := GroupReceipts{MessageID: messageID}
+	if _, err := d.groupRepo.GetGroupMember(groupID, userID); err != nil {
+		return receipts, ErrNotMember
+	}
+
+	msgs, err := d.groupMsgRepo.FindByIDs([]uuid.UUID{messageID})
+	if err != nil {
+		return receipts, err
+	}
+	if len(msgs) == 0 || msgs[0].GroupID != groupID {
+		return receipts, ErrMessageNotFound
+	}
+
+	deliveries, err := d.deliveryRepo.GetMessageDeliveries(messageID)
+	if err != nil {
+		return receipts, err
+	}
+
+	receipts.Total = len(deliveries)
+	receipts.Members = deliveries
+	for _, delivery := range deliveries {
+		if delivery.DeliveredAt != nil {
+			receipts.DeliveredCount++
+		}
+		if delivery.ReadAt != nil {
+			receipts.ReadCount++
+		}
+	}
+
+	return receipts, nil
+}
+
+// buildReceiptUpdates folds updated receipts into one update per sender and
+// conversation, which is what the sender's devices get pushed.
+func buildReceiptUpdates(updated []models.MessageDelivery, status models.ReceiptStatus) []ReceiptUpdate {
+	type key struct {
+		senderID       uuid.UUID
+		conversationID uuid.UUID
+	}
+
+	var updates []ReceiptUpdate
+	index := map[key]int{}
+	for _, delivery := range updated {
+		k := key{delivery.SenderID, delivery.ConversationID}
+		i, ok := index[k]
+		if !ok {
+			at := delivery.DeliveredAt
+			if status == models.Read {
+				at = delivery.ReadAt
+			}
+			updates = append(updates, ReceiptUpdate{
+				ConversationType: delivery.ConversationType,
+				ConversationID:   delivery.ConversationID,
+				SenderID:         delivery.SenderID,
+				RecipientID:      delivery.RecipientID,
+				Status:           status,
+				At:               *at,
+			})
+			i = len(updates) - 1
+			index[k] = i
+		}
+		updates[i].MessageIDs = append(updates[i].MessageIDs, delivery.MessageID)
+	}
+
+	return updates
+}
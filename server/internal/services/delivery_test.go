@@ -0,0 +1,164 @@
+package services
+
+import (
+	"errors"
+	"shiplabs/schat/internal/models"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestGetUndelivered checks the backlog comes back oldest first across
+// conversation types, and a delivery whose message is gone is settled
+// rather than left blocking the queue.
+func TestGetUndelivered(t *testing.T) {
+	recipient, sender := uuid.New(), uuid.New()
+	private := models.PrivateMessage{ChatID: uuid.New()}
+	private.ID = uuid.New()
+	group := models.GroupMessage{GroupID: uuid.New()}
+	group.ID = uuid.New()
+	gone := uuid.New()
+
+	deliveries := &fakeDeliveries{deliveries: []models.MessageDelivery{
+		{MessageID: group.ID, RecipientID: recipient, SenderID: sender, ConversationType: models.GroupConversation},
+		{MessageID: gone, RecipientID: recipient, SenderID: sender, ConversationType: models.PrivateConversation},
+		{MessageID: private.ID, RecipientID: recipient, SenderID: sender, ConversationType: models.PrivateConversation},
+		{MessageID: private.ID, RecipientID: sender, SenderID: recipient, ConversationType: models.PrivateConversation},
+	}}
+	service := &deliveryService{
+		deliveryRepo:       deliveries,
+		privateMessageRepo: newFakePrivateMessages(private),
+		groupMsgRepo:       newFakeGroupMessages(nil, group),
+	}
+
+	pending, err := service.GetUndelivered(recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PendingMessage{
+		{MessageID: group.ID, ConversationType: models.GroupConversation},
+		{MessageID: private.ID, ConversationType: models.PrivateConversation},
+	}
+	if len(pending) != len(want) {
+		t.Fatalf("GetUndelivered() returned %d messages, want %d", len(pending), len(want))
+	}
+	for i := range want {
+		if pending[i].MessageID != want[i].MessageID || pending[i].ConversationType != want[i].ConversationType {
+			t.Fatalf("pending[%d] = %+v, want %+v", i, pending[i], want[i])
+		}
+	}
+	if _, ok := pending[0].Message.(models.GroupMessage); !ok {
+		t.Fatalf("group message carried as %T", pending[0].Message)
+	}
+
+	for _, delivery := range deliveries.deliveries {
+		if delivery.MessageID == gone && delivery.DeliveredAt == nil {
+			t.Fatal("delivery of a deleted message left pending")
+		}
+	}
+}
+
+func TestBuildReceiptUpdates(t *testing.T) {
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	chat, group := uuid.New(), uuid.New()
+	at := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	delivery := func(senderID, conversationID uuid.UUID) models.MessageDelivery {
+		return models.MessageDelivery{
+			MessageID: uuid.New(), RecipientID: carol, SenderID: senderID,
+			ConversationID: conversationID, DeliveredAt: &at, ReadAt: &at,
+		}
+	}
+	updated := []models.MessageDelivery{
+		delivery(alice, chat), delivery(bob, group), delivery(alice, chat), delivery(alice, group),
+	}
+
+	updates := buildReceiptUpdates(updated, models.Read)
+	want := []struct {
+		senderID       uuid.UUID
+		conversationID uuid.UUID
+		messages       []uuid.UUID
+	}{
+		{alice, chat, []uuid.UUID{updated[0].MessageID, updated[2].MessageID}},
+		{bob, group, []uuid.UUID{updated[1].MessageID}},
+		{alice, group, []uuid.UUID{updated[3].MessageID}},
+	}
+	if len(updates) != len(want) {
+		t.Fatalf("buildReceiptUpdates() returned %d updates, want one per sender and conversation", len(updates))
+	}
+	for i, w := range want {
+		got := updates[i]
+		if got.SenderID != w.senderID || got.ConversationID != w.conversationID || got.Status != models.Read || got.RecipientID != carol {
+			t.Fatalf("update %d = %+v", i, got)
+		}
+		if !slices.Equal(got.MessageIDs, w.messages) {
+			t.Fatalf("update %d carries %v, want %v", i, got.MessageIDs, w.messages)
+		}
+	}
+	if len(buildReceiptUpdates(nil, models.Delivered)) != 0 {
+		t.Fatal("updates built from no receipts")
+	}
+}
+
+// TestAcknowledge checks a receipt covers everything up to the message it
+// names, and the senders are told through the outbox.
+func TestAcknowledge(t *testing.T) {
+	reader, alice, bob := uuid.New(), uuid.New(), uuid.New()
+	chat := uuid.New()
+	messages := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	senders := []uuid.UUID{alice, bob, alice}
+
+	tests := []struct {
+		name        string
+		data        func() ReceiptDto
+		wantErr     error
+		wantRead    int
+		wantNotices int
+	}{
+		{"read up to the second", func() ReceiptDto {
+			return ReceiptDto{MessageID: messages[1].String(), Status: models.Read}
+		}, nil, 2, 2},
+		{"read all", func() ReceiptDto {
+			return ReceiptDto{MessageID: messages[2].String(), Status: models.Read}
+		}, nil, 3, 2},
+		{"unknown status", func() ReceiptDto {
+			return ReceiptDto{MessageID: messages[2].String(), Status: "seen"}
+		}, ErrInvalidReceipt, 0, 0},
+		{"invalid id", func() ReceiptDto { return ReceiptDto{MessageID: "nope", Status: models.Read} }, ErrInvalidID, 0, 0},
+		{"not a recipient", func() ReceiptDto {
+			return ReceiptDto{MessageID: uuid.NewString(), Status: models.Read}
+		}, ErrMessageNotFound, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			deliveries := &fakeDeliveries{testDB: db}
+			for i, id := range messages {
+				deliveries.deliveries = append(deliveries.deliveries, models.MessageDelivery{
+					MessageID: id, RecipientID: reader, SenderID: senders[i],
+					ConversationType: models.PrivateConversation, ConversationID: chat,
+				})
+			}
+			outbox := &fakeOutbox{testDB: db}
+			service := &deliveryService{deliveryRepo: deliveries, outboxRepo: outbox}
+
+			updates, err := service.Acknowledge(reader, tt.data())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Acknowledge() error = %v, want %v", err, tt.wantErr)
+			}
+			read := 0
+			for _, delivery := range deliveries.deliveries {
+				if delivery.ReadAt != nil {
+					read++
+				}
+			}
+			if read != tt.wantRead {
+				t.Fatalf("%d messages read, want %d", read, tt.wantRead)
+			}
+			if len(updates) != tt.wantNotices || len(outbox.events) != tt.wantNotices {
+				t.Fatalf("%d updates and %d notices, want %d", len(updates), len(outbox.events), tt.wantNotices)
+			}
+		})
+	}
+}
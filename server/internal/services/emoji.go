@@ -0,0 +1,111 @@
+package services
+
+import "unicode"
+
+const (
+	zeroWidthJoiner   = '\u200D'
+	variationSelector = '\uFE0F'
+	keycap            = '\u20E3'
+	tagCancel         = '\U000E007F'
+)
+
+// pictographs covers the blocks emoji are drawn from, including the odd
+// symbols that only render as emoji when followed by the variation
+// selector.
+var pictographs = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00A9, Hi: 0x00AE, Stride: 5},
+		{Lo: 0x203C, Hi: 0x2049, Stride: 13},
+		{Lo: 0x2122, Hi: 0x2139, Stride: 23},
+		{Lo: 0x2194, Hi: 0x2199, Stride: 1},
+		{Lo: 0x21A9, Hi: 0x21AA, Stride: 1},
+		{Lo: 0x231A, Hi: 0x231B, Stride: 1},
+		{Lo: 0x2328, Hi: 0x23CF, Stride: 167},
+		{Lo: 0x23E9, Hi: 0x23F3, Stride: 1},
+		{Lo: 0x23F8, Hi: 0x23FA, Stride: 1},
+		{Lo: 0x24C2, Hi: 0x24C2, Stride: 1},
+		{Lo: 0x25AA, Hi: 0x25AB, Stride: 1},
+		{Lo: 0x25B6, Hi: 0x25C0, Stride: 10},
+		{Lo: 0x25FB, Hi: 0x25FE, Stride: 1},
+		{Lo: 0x2600, Hi: 0x27BF, Stride: 1},
+		{Lo: 0x2934, Hi: 0x2935, Stride: 1},
+		{Lo: 0x2B05, Hi: 0x2B07, Stride: 1},
+		{Lo: 0x2B1B, Hi: 0x2B1C, Stride: 1},
+		{Lo: 0x2B50, Hi: 0x2B55, Stride: 5},
+		{Lo: 0x3030, Hi: 0x303D, Stride: 13},
+		{Lo: 0x3297, Hi: 0x3299, Stride: 2},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1F000, Hi: 0x1FAFF, Stride: 1},
+	},
+	LatinOffset: 1,
+}
+
+func isRegionalIndicator(r rune) bool { return r >= 0x1F1E6 && r <= 0x1F1FF }
+func isSkinTone(r rune) bool          { return r >= 0x1F3FB && r <= 0x1F3FF }
+func isTag(r rune) bool               { return r >= 0xE0020 && r <= 0xE007E }
+func isKeycapBase(r rune) bool        { return r == '#' || r == '*' || (r >= '0' && r <= '9') }
+
+// isEmoji tells whether s is exactly one emoji: a pictograph with optional
+// presentation selector and skin tone, a keycap, a flag or a tagged
+// subdivision flag, or several of those joined with zero width joiners.
+func isEmoji(s string) bool {
+	runes := []rune(s)
+	i := 0
+
+	// element consumes one emoji at runes[i:] and reports whether it found one
+	element := func() bool {
+		if i >= len(runes) {
+			return false
+		}
+		r := runes[i]
+		switch {
+		case isRegionalIndicator(r):
+			if i+1 >= len(runes) || !isRegionalIndicator(runes[i+1]) {
+				return false
+			}
+			i += 2
+			return true
+		case isKeycapBase(r):
+			i++
+			if i < len(runes) && runes[i] == variationSelector {
+				i++
+			}
+			if i >= len(runes) || runes[i] != keycap {
+				return false
+			}
+			i++
+			return true
+		case unicode.Is(pictographs, r):
+			i++
+			if i < len(runes) && runes[i] == variationSelector {
+				i++
+			}
+			if i < len(runes) && isSkinTone(runes[i]) {
+				i++
+			}
+			return true
+		}
+		return false
+	}
+
+	if !element() {
+		return false
+	}
+	if i < len(runes) && isTag(runes[i]) {
+		for i < len(runes) && isTag(runes[i]) {
+			i++
+		}
+		if i >= len(runes) || runes[i] != tagCancel {
+			return false
+		}
+		i++
+	}
+	for i < len(runes) && runes[i] == zeroWidthJoiner {
+		i++
+		if !element() {
+			return false
+		}
+	}
+	return i == len(runes)
+}
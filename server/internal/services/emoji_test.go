@@ -0,0 +1,49 @@
+package services
+
+import "testing"
+
+func TestIsEmoji(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want bool
+	}{
+		{"pictograph", "\U0001F600", true},
+		{"heart with presentation selector", "\u2764\uFE0F", true},
+		{"heart without selector", "\u2764", true},
+		{"copyright", "\u00A9\uFE0F", true},
+		{"skin tone", "\U0001F44D\U0001F3FD", true},
+		{"selector then skin tone", "\u270B\uFE0F\U0001F3FB", true},
+		{"flag", "\U0001F1F3\U0001F1F1", true},
+		{"keycap", "1\uFE0F\u20E3", true},
+		{"keycap without selector", "#\u20E3", true},
+		{"subdivision flag", "\U0001F3F4\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007F", true},
+		{"family", "\U0001F468\u200D\U0001F469\u200D\U0001F467", true},
+		{"rainbow flag", "\U0001F3F3\uFE0F\u200D\U0001F308", true},
+		{"joined with skin tones", "\U0001F469\U0001F3FD\u200D\U0001F4BB", true},
+
+		{"empty", "", false},
+		{"letter", "a", false},
+		{"word", "lol", false},
+		{"digit alone", "1", false},
+		{"digit with selector only", "1\uFE0F", false},
+		{"lone regional indicator", "\U0001F1F3", false},
+		{"three regional indicators", "\U0001F1F3\U0001F1F1\U0001F1F3", false},
+		{"two emoji", "\U0001F600\U0001F600", false},
+		{"emoji and text", "\U0001F600!", false},
+		{"leading space", " \U0001F600", false},
+		{"trailing joiner", "\U0001F468\u200D", false},
+		{"joiner before text", "\U0001F468\u200Da", false},
+		{"lone selector", "\uFE0F", false},
+		{"lone skin tone after text", "a\U0001F3FB", false},
+		{"tags without cancel", "\U0001F3F4\U000E0067\U000E0062", false},
+		{"lone keycap mark", "\u20E3", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isEmoji(tt.s); got != tt.want {
+				t.Fatalf("isEmoji(%+q) = %t, want %t", tt.s, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,901 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/internal/pkg/mailer"
+	repos "shiplabs/schat/internal/repositories"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+// errNoDatabase is what a statement reaching the fake connection gets. The
+// fake repositories never send one, so seeing it means a test missed a
+// repository call.
+var errNoDatabase = errors.New("no database in service tests")
+
+// txLog counts the transactions the service under test committed and
+// rolled back. The statements inside them go to the fake repositories.
+type txLog struct {
+	mu        sync.Mutex
+	commits   int
+	rollbacks int
+}
+
+func (l *txLog) PrepareContext(context.Context, string) (*sql.Stmt, error) {
+	return nil, errNoDatabase
+}
+
+func (l *txLog) ExecContext(context.Context, string, ...any) (sql.Result, error) {
+	return nil, errNoDatabase
+}
+
+func (l *txLog) QueryContext(context.Context, string, ...any) (*sql.Rows, error) {
+	return nil, errNoDatabase
+}
+
+func (l *txLog) QueryRowContext(context.Context, string, ...any) *sql.Row {
+	return nil
+}
+
+func (l *txLog) BeginTx(context.Context, *sql.TxOptions) (gorm.ConnPool, error) {
+	return &fakeTx{txLog: l}, nil
+}
+
+type fakeTx struct {
+	*txLog
+}
+
+func (t *fakeTx) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.commits++
+	return nil
+}
+
+func (t *fakeTx) Rollback() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollbacks++
+	return nil
+}
+
+func (l *txLog) counts() (int, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.commits, l.rollbacks
+}
+
+// testDB hands out transactions that only get committed or rolled back.
+type testDB struct {
+	db  *gorm.DB
+	log *txLog
+}
+
+func newTestDB(t *testing.T) *testDB {
+	t.Helper()
+	log := &txLog{}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{ConnPool: log})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testDB{db: db, log: log}
+}
+
+func (d *testDB) BeginDBTx() *gorm.DB {
+	return d.db.Begin()
+}
+
+// The fakes below embed the repository interface they stand in for and
+// implement only what the tests reach, anything else panics.
+
+type fakeUsers struct {
+	repos.UserRepoInterface
+	mu    sync.Mutex
+	users map[uuid.UUID]models.User
+}
+
+func (f *fakeUsers) Create(user *models.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.users == nil {
+		f.users = map[uuid.UUID]models.User{}
+	}
+	user.ID = uuid.New()
+	f.users[user.ID] = *user
+	return nil
+}
+
+func (f *fakeUsers) FindByID(id uuid.UUID) (models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[id]
+	if !ok {
+		return models.User{}, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+func (f *fakeUsers) FindByEmail(email string) (models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, user := range f.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return models.User{}, gorm.ErrRecordNotFound
+}
+
+func (f *fakeUsers) UpdatePassword(_ *gorm.DB, id uuid.UUID, password string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user := f.users[id]
+	user.Password = password
+	f.users[id] = user
+	return nil
+}
+
+func (f *fakeUsers) MarkEmailVerified(_ *gorm.DB, id uuid.UUID, email string, at time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user := f.users[id]
+	if user.Email == email {
+		user.EmailVerifiedAt = &at
+		f.users[id] = user
+	}
+	return nil
+}
+
+// fakeSessions beforeRotate runs ahead of RotateRefreshToken, standing in
+// for a refresh with the same token that got there first.
+type fakeSessions struct {
+	repos.SessionRepoInterface
+	*testDB
+	mu           sync.Mutex
+	sessions     map[uuid.UUID]models.Session
+	refresh      map[string]models.RefreshToken
+	tickets      map[string]models.ConnectTicket
+	beforeRotate func(f *fakeSessions, id uuid.UUID)
+}
+
+func newFakeSessions(db *testDB) *fakeSessions {
+	return &fakeSessions{
+		testDB:   db,
+		sessions: map[uuid.UUID]models.Session{},
+		refresh:  map[string]models.RefreshToken{},
+		tickets:  map[string]models.ConnectTicket{},
+	}
+}
+
+func (f *fakeSessions) BeginDBTx() *gorm.DB {
+	return f.testDB.BeginDBTx()
+}
+
+func (f *fakeSessions) CreateSession(_ *gorm.DB, session *models.Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	session.ID = uuid.New()
+	f.sessions[session.ID] = *session
+	return nil
+}
+
+func (f *fakeSessions) FindByID(id uuid.UUID) (models.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	session, ok := f.sessions[id]
+	if !ok {
+		return models.Session{}, gorm.ErrRecordNotFound
+	}
+	return session, nil
+}
+
+func (f *fakeSessions) ExtendSession(_ *gorm.DB, id uuid.UUID, expiresAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	session := f.sessions[id]
+	session.ExpiresAt = expiresAt
+	f.sessions[id] = session
+	return nil
+}
+
+func (f *fakeSessions) RevokeSession(_ *gorm.DB, id uuid.UUID, reason string, at time.Time) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	session, ok := f.sessions[id]
+	if !ok || session.RevokedAt != nil {
+		return false, nil
+	}
+	session.RevokedAt = &at
+	session.RevokeReason = reason
+	f.sessions[id] = session
+	return true, nil
+}
+
+func (f *fakeSessions) RevokeUserSessions(_ *gorm.DB, userID uuid.UUID, reason string, at time.Time) ([]uuid.UUID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var revoked []uuid.UUID
+	for id, session := range f.sessions {
+		if session.UserID != userID || session.RevokedAt != nil {
+			continue
+		}
+		session.RevokedAt = &at
+		session.RevokeReason = reason
+		f.sessions[id] = session
+		revoked = append(revoked, id)
+	}
+	return revoked, nil
+}
+
+func (f *fakeSessions) CreateRefreshToken(_ *gorm.DB, token *models.RefreshToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	token.ID = uuid.New()
+	f.refresh[token.TokenHash] = *token
+	return nil
+}
+
+func (f *fakeSessions) FindRefreshToken(tokenHash string) (models.RefreshToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	token, ok := f.refresh[tokenHash]
+	if !ok {
+		return models.RefreshToken{}, gorm.ErrRecordNotFound
+	}
+	return token, nil
+}
+
+func (f *fakeSessions) RotateRefreshToken(_ *gorm.DB, id uuid.UUID, at time.Time) (bool, error) {
+	if f.beforeRotate != nil {
+		f.beforeRotate(f, id)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for hash, token := range f.refresh {
+		if token.ID != id {
+			continue
+		}
+		if token.RotatedAt != nil {
+			return false, nil
+		}
+		token.RotatedAt = &at
+		f.refresh[hash] = token
+		return true, nil
+	}
+	return false, nil
+}
+
+func (f *fakeSessions) CreateConnectTicket(ticket *models.ConnectTicket) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ticket.ID = uuid.New()
+	f.tickets[ticket.TokenHash] = *ticket
+	return nil
+}
+
+func (f *fakeSessions) RedeemConnectTicket(tokenHash string, at time.Time) (models.ConnectTicket, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ticket, ok := f.tickets[tokenHash]
+	if !ok || ticket.UsedAt != nil || !at.Before(ticket.ExpiresAt) {
+		return models.ConnectTicket{}, false, nil
+	}
+	ticket.UsedAt = &at
+	f.tickets[tokenHash] = ticket
+	return ticket, true, nil
+}
+
+func (f *fakeSessions) PurgeConnectTickets(before time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for hash, ticket := range f.tickets {
+		if ticket.ExpiresAt.Before(before) {
+			delete(f.tickets, hash)
+		}
+	}
+	return nil
+}
+
+type fakeUserTokens struct {
+	repos.UserTokenRepoInterface
+	*testDB
+	mu     sync.Mutex
+	tokens []models.UserToken
+}
+
+func (f *fakeUserTokens) BeginDBTx() *gorm.DB {
+	return f.testDB.BeginDBTx()
+}
+
+func (f *fakeUserTokens) InvalidateTokens(_ *gorm.DB, userID uuid.UUID, purpose models.TokenPurpose, at time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, token := range f.tokens {
+		if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil {
+			f.tokens[i].UsedAt = &at
+		}
+	}
+	return nil
+}
+
+func (f *fakeUserTokens) ConsumeToken(_ *gorm.DB, purpose models.TokenPurpose, tokenHash string, at time.Time) (models.UserToken, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, token := range f.tokens {
+		if token.TokenHash == tokenHash && token.Purpose == purpose && token.UsedAt == nil && at.Before(token.ExpiresAt) {
+			f.tokens[i].UsedAt = &at
+			return f.tokens[i], true, nil
+		}
+	}
+	return models.UserToken{}, false, nil
+}
+
+func (f *fakeUserTokens) CreateToken(_ *gorm.DB, token *models.UserToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = append(f.tokens, *token)
+	return nil
+}
+
+type fakeSigningKeys struct {
+	repos.SigningKeyRepoInterface
+}
+
+func (fakeSigningKeys) Publish(*models.SigningKey) error {
+	return nil
+}
+
+type fakeOutbox struct {
+	repos.OutboxRepoInterface
+	*testDB
+	mu     sync.Mutex
+	events []models.OutboxEvent
+}
+
+func (f *fakeOutbox) BeginDBTx() *gorm.DB {
+	return f.testDB.BeginDBTx()
+}
+
+func (f *fakeOutbox) Append(_ *gorm.DB, events []models.OutboxEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, event := range events {
+		event.ID = uuid.New()
+		f.events = append(f.events, event)
+	}
+	return nil
+}
+
+// Claim leases like the real query: a claimed event stays out of later
+// claims until its lease runs out or it is dispatched.
+func (f *fakeOutbox) Claim(limit int, lease time.Duration) ([]models.OutboxEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	var claimed []models.OutboxEvent
+	for i, event := range f.events {
+		if len(claimed) == limit {
+			break
+		}
+		if event.DispatchedAt != nil || event.AvailableAt.After(now) {
+			continue
+		}
+		f.events[i].AvailableAt = now.Add(lease)
+		f.events[i].Attempts++
+		claimed = append(claimed, f.events[i])
+	}
+	return claimed, nil
+}
+
+func (f *fakeOutbox) MarkDispatched(id uuid.UUID) error {
+	return f.retire(id, "")
+}
+
+func (f *fakeOutbox) MarkFailed(id uuid.UUID, reason string) error {
+	return f.retire(id, reason)
+}
+
+func (f *fakeOutbox) retire(id uuid.UUID, reason string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for i, event := range f.events {
+		if event.ID == id {
+			f.events[i].DispatchedAt = &now
+			f.events[i].LastError = reason
+		}
+	}
+	return nil
+}
+
+// fakeMailer hands every mail sent to the test.
+type fakeMailer chan mailer.Message
+
+func (f fakeMailer) Send(_ context.Context, msg mailer.Message) error {
+	f <- msg
+	return nil
+}
+
+type fakePrivateChats struct {
+	repos.PrivateChatRepoInterface
+	*testDB
+	mu    sync.Mutex
+	chats map[uuid.UUID]models.PrivateChat
+}
+
+func newFakePrivateChats(db *testDB, chats ...models.PrivateChat) *fakePrivateChats {
+	f := &fakePrivateChats{testDB: db, chats: map[uuid.UUID]models.PrivateChat{}}
+	for _, chat := range chats {
+		f.chats[chat.ID] = chat
+	}
+	return f
+}
+
+func (f *fakePrivateChats) BeginDBTx() *gorm.DB {
+	return f.testDB.BeginDBTx()
+}
+
+func (f *fakePrivateChats) FindByID(chatID uuid.UUID) (models.PrivateChat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	chat, ok := f.chats[chatID]
+	if !ok {
+		return models.PrivateChat{}, gorm.ErrRecordNotFound
+	}
+	return chat, nil
+}
+
+func (f *fakePrivateChats) FindChat(mem1, mem2 uuid.UUID) (models.PrivateChat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, chat := range f.chats {
+		if chat.FirstMemberID == mem1 && chat.SecondMemberID == mem2 ||
+			chat.FirstMemberID == mem2 && chat.SecondMemberID == mem1 {
+			return chat, nil
+		}
+	}
+	return models.PrivateChat{}, gorm.ErrRecordNotFound
+}
+
+func (f *fakePrivateChats) FindOrCreateChat(_ *gorm.DB, mem1, mem2 uuid.UUID) (models.PrivateChat, error) {
+	if chat, err := f.FindChat(mem1, mem2); err == nil {
+		return chat, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	chat := models.PrivateChat{ID: uuid.New(), FirstMemberID: mem1, SecondMemberID: mem2, CreatedAt: time.Now()}
+	f.chats[chat.ID] = chat
+	return chat, nil
+}
+
+func (f *fakePrivateChats) NextSeq(_ *gorm.DB, chatID uuid.UUID) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	chat := f.chats[chatID]
+	chat.LastSeq++
+	f.chats[chatID] = chat
+	return chat.LastSeq, nil
+}
+
+// fakePrivateMessages beforeLock runs ahead of LockForUpdate, standing in
+// for a request that got there between the service's read and its lock.
+// beforeCreate does the same for Create.
+type fakePrivateMessages struct {
+	repos.PrivateMessageRepoInterface
+	mu           sync.Mutex
+	messages     map[uuid.UUID]models.PrivateMessage
+	beforeLock   func(f *fakePrivateMessages, id uuid.UUID)
+	beforeCreate func(f *fakePrivateMessages)
+}
+
+func newFakePrivateMessages(messages ...models.PrivateMessage) *fakePrivateMessages {
+	f := &fakePrivateMessages{messages: map[uuid.UUID]models.PrivateMessage{}}
+	for _, message := range messages {
+		f.messages[message.ID] = message
+	}
+	return f
+}
+
+// Create enforces the unique client message ID of a sender like the
+// database does.
+func (f *fakePrivateMessages) Create(_ *gorm.DB, message *models.PrivateMessage) error {
+	if f.beforeCreate != nil {
+		f.beforeCreate(f)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, stored := range f.messages {
+		if sameClientMessage(stored.BaseMessage, message.BaseMessage) {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+	message.ID = uuid.New()
+	message.CreatedAt = time.Now()
+	f.messages[message.ID] = *message
+	return nil
+}
+
+func (f *fakePrivateMessages) FindByClientID(senderID uuid.UUID, clientMessageID string) (models.PrivateMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, message := range f.messages {
+		if message.SenderID == senderID && message.ClientMessageID != nil && *message.ClientMessageID == clientMessageID {
+			return message, nil
+		}
+	}
+	return models.PrivateMessage{}, gorm.ErrRecordNotFound
+}
+
+func (f *fakePrivateMessages) FindByIDs(ids []uuid.UUID) ([]models.PrivateMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []models.PrivateMessage
+	for _, id := range ids {
+		if message, ok := f.messages[id]; ok {
+			found = append(found, message)
+		}
+	}
+	return found, nil
+}
+
+func (f *fakePrivateMessages) LockForUpdate(_ *gorm.DB, id uuid.UUID) (models.BaseMessage, error) {
+	if f.beforeLock != nil {
+		f.beforeLock(f, id)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	message, ok := f.messages[id]
+	if !ok {
+		return models.BaseMessage{}, gorm.ErrRecordNotFound
+	}
+	return message.BaseMessage, nil
+}
+
+func (f *fakePrivateMessages) MarkDeletedForAll(_ *gorm.DB, id uuid.UUID, deletedAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	message := f.messages[id]
+	message.DeletedForAllAt = &deletedAt
+	f.messages[id] = message
+	return nil
+}
+
+type fakeMessageActions struct {
+	repos.MessageActionRepoInterface
+	*testDB
+}
+
+func (f *fakeMessageActions) BeginDBTx() *gorm.DB {
+	return f.testDB.BeginDBTx()
+}
+
+type fakeGroupMessages struct {
+	repos.GroupMessageRepoInterface
+	*testDB
+	mu       sync.Mutex
+	messages map[uuid.UUID]models.GroupMessage
+}
+
+func newFakeGroupMessages(db *testDB, messages ...models.GroupMessage) *fakeGroupMessages {
+	f := &fakeGroupMessages{testDB: db, messages: map[uuid.UUID]models.GroupMessage{}}
+	for _, message := range messages {
+		f.messages[message.ID] = message
+	}
+	return f
+}
+
+func (f *fakeGroupMessages) BeginDBTx() *gorm.DB {
+	return f.testDB.BeginDBTx()
+}
+
+// Create enforces the unique client message ID of a sender like the
+// database does.
+func (f *fakeGroupMessages) Create(_ *gorm.DB, message *models.GroupMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, stored := range f.messages {
+		if sameClientMessage(stored.BaseMessage, message.BaseMessage) {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+	message.ID = uuid.New()
+	message.CreatedAt = time.Now()
+	f.messages[message.ID] = *message
+	return nil
+}
+
+func (f *fakeGroupMessages) FindByClientID(senderID uuid.UUID, clientMessageID string) (models.GroupMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, message := range f.messages {
+		if message.SenderID == senderID && message.ClientMessageID != nil && *message.ClientMessageID == clientMessageID {
+			return message, nil
+		}
+	}
+	return models.GroupMessage{}, gorm.ErrRecordNotFound
+}
+
+func (f *fakeGroupMessages) IncrementReplyCount(_ *gorm.DB, rootID uuid.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	root := f.messages[rootID]
+	root.ReplyCount++
+	f.messages[rootID] = root
+	return nil
+}
+
+func (f *fakeGroupMessages) GetGroupMessagesSince(groupID uuid.UUID, page repos.SeqPage) ([]models.GroupMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var since []models.GroupMessage
+	for _, message := range f.messages {
+		if message.GroupID == groupID && message.Seq > page.Since {
+			since = append(since, message)
+		}
+	}
+	slices.SortFunc(since, func(a, b models.GroupMessage) int { return cmp.Compare(a.Seq, b.Seq) })
+	return since[:min(page.Limit, len(since))], nil
+}
+
+func sameClientMessage(a, b models.BaseMessage) bool {
+	return a.SenderID == b.SenderID && a.ClientMessageID != nil && b.ClientMessageID != nil &&
+		*a.ClientMessageID == *b.ClientMessageID
+}
+
+func (f *fakeGroupMessages) FindByIDs(ids []uuid.UUID) ([]models.GroupMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []models.GroupMessage
+	for _, id := range ids {
+		if message, ok := f.messages[id]; ok {
+			found = append(found, message)
+		}
+	}
+	return found, nil
+}
+
+type fakeDeliveries struct {
+	repos.DeliveryRepoInterface
+	*testDB
+	mu         sync.Mutex
+	deliveries []models.MessageDelivery
+}
+
+func (f *fakeDeliveries) BeginDBTx() *gorm.DB {
+	return f.testDB.BeginDBTx()
+}
+
+func (f *fakeDeliveries) CreateDeliveries(_ *gorm.DB, deliveries []models.MessageDelivery) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deliveries = append(f.deliveries, deliveries...)
+	return nil
+}
+
+func (f *fakeDeliveries) GetUndelivered(recipientID uuid.UUID, limit int) ([]models.MessageDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var undelivered []models.MessageDelivery
+	for _, delivery := range f.deliveries {
+		if delivery.RecipientID == recipientID && delivery.DeliveredAt == nil && len(undelivered) < limit {
+			undelivered = append(undelivered, delivery)
+		}
+	}
+	return undelivered, nil
+}
+
+func (f *fakeDeliveries) MarkDelivered(recipientID uuid.UUID, messageIDs []uuid.UUID) ([]models.MessageDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	var updated []models.MessageDelivery
+	for i, delivery := range f.deliveries {
+		for _, id := range messageIDs {
+			if delivery.MessageID == id && delivery.RecipientID == recipientID && delivery.DeliveredAt == nil {
+				f.deliveries[i].DeliveredAt = &now
+				updated = append(updated, f.deliveries[i])
+			}
+		}
+	}
+	return updated, nil
+}
+
+func (f *fakeDeliveries) FindDelivery(messageID, recipientID uuid.UUID) (models.MessageDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, delivery := range f.deliveries {
+		if delivery.MessageID == messageID && delivery.RecipientID == recipientID {
+			return delivery, nil
+		}
+	}
+	return models.MessageDelivery{}, gorm.ErrRecordNotFound
+}
+
+// MarkUpTo goes by the order deliveries were added in, standing in for
+// their creation time.
+func (f *fakeDeliveries) MarkUpTo(_ *gorm.DB, upTo models.MessageDelivery, status models.ReceiptStatus) ([]models.MessageDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	var updated []models.MessageDelivery
+	for i, delivery := range f.deliveries {
+		if delivery.RecipientID != upTo.RecipientID || delivery.ConversationID != upTo.ConversationID {
+			continue
+		}
+		changed := false
+		if status == models.Read && delivery.ReadAt == nil {
+			delivery.ReadAt = &now
+			changed = true
+		}
+		if delivery.DeliveredAt == nil {
+			delivery.DeliveredAt = &now
+			changed = changed || status == models.Delivered
+		}
+		f.deliveries[i] = delivery
+		if changed {
+			updated = append(updated, delivery)
+		}
+		if delivery.MessageID == upTo.MessageID {
+			break
+		}
+	}
+	return updated, nil
+}
+
+func (f *fakePrivateChats) GetUserPrivateChats(userID uuid.UUID) ([]models.PrivateChat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var chats []models.PrivateChat
+	for _, chat := range f.chats {
+		if chat.FirstMemberID == userID || chat.SecondMemberID == userID {
+			chats = append(chats, chat)
+		}
+	}
+	return chats, nil
+}
+
+func (f *fakePrivateMessages) GetLastMessages(chatIDs []uuid.UUID, _ uuid.UUID) ([]models.PrivateMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	last := map[uuid.UUID]models.PrivateMessage{}
+	for _, message := range f.messages {
+		if newest, ok := last[message.ChatID]; !ok || message.CreatedAt.After(newest.CreatedAt) {
+			last[message.ChatID] = message
+		}
+	}
+	var found []models.PrivateMessage
+	for _, id := range chatIDs {
+		if message, ok := last[id]; ok {
+			found = append(found, message)
+		}
+	}
+	return found, nil
+}
+
+func (f *fakeGroupMessages) GetLastMessages(groupIDs []uuid.UUID, _ uuid.UUID) ([]models.GroupMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	last := map[uuid.UUID]models.GroupMessage{}
+	for _, message := range f.messages {
+		if newest, ok := last[message.GroupID]; !ok || message.CreatedAt.After(newest.CreatedAt) {
+			last[message.GroupID] = message
+		}
+	}
+	var found []models.GroupMessage
+	for _, id := range groupIDs {
+		if message, ok := last[id]; ok {
+			found = append(found, message)
+		}
+	}
+	return found, nil
+}
+
+// CountUnread counts every unread receipt, the exclusions live in the query.
+func (f *fakeDeliveries) CountUnread(recipientID uuid.UUID) (map[uuid.UUID]int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := map[uuid.UUID]int64{}
+	for _, delivery := range f.deliveries {
+		if delivery.RecipientID == recipientID && delivery.ReadAt == nil {
+			counts[delivery.ConversationID]++
+		}
+	}
+	return counts, nil
+}
+
+type fakeGroups struct {
+	repos.GroupRepoInterface
+	*testDB
+	mu      sync.Mutex
+	groups  map[uuid.UUID]models.Group
+	members []models.GroupMember
+}
+
+func newFakeGroups(db *testDB, groups ...models.Group) *fakeGroups {
+	f := &fakeGroups{testDB: db, groups: map[uuid.UUID]models.Group{}}
+	for _, group := range groups {
+		f.groups[group.ID] = group
+	}
+	return f
+}
+
+func (f *fakeGroups) BeginDBTx() *gorm.DB {
+	return f.testDB.BeginDBTx()
+}
+
+func (f *fakeGroups) FindByID(groupID uuid.UUID) (models.Group, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group, ok := f.groups[groupID]
+	if !ok {
+		return models.Group{}, gorm.ErrRecordNotFound
+	}
+	return group, nil
+}
+
+func (f *fakeGroups) NextSeq(_ *gorm.DB, groupID uuid.UUID) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group := f.groups[groupID]
+	group.LastSeq++
+	f.groups[groupID] = group
+	return group.LastSeq, nil
+}
+
+func (f *fakeGroups) GetUserGroups(userID uuid.UUID) ([]models.Group, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var groups []models.Group
+	for _, member := range f.members {
+		if group, ok := f.groups[member.GroupID]; ok && member.UserID == userID {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+func (f *fakeGroups) GetGroupMember(groupID, userID uuid.UUID) (models.GroupMember, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, member := range f.members {
+		if member.GroupID == groupID && member.UserID == userID {
+			return member, nil
+		}
+	}
+	return models.GroupMember{}, gorm.ErrRecordNotFound
+}
+
+func (f *fakeGroups) GetGroupMembers(groupID uuid.UUID) ([]models.GroupMember, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var members []models.GroupMember
+	for _, member := range f.members {
+		if member.GroupID == groupID {
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+type fakeAttachments struct {
+	repos.AttachmentRepoInterface
+	mu          sync.Mutex
+	attachments []models.Attachment
+}
+
+func (f *fakeAttachments) Create(attachment *models.Attachment) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attachments = append(f.attachments, *attachment)
+	return nil
+}
@@ -7,9 +7,11 @@ import (
 	repos "shiplabs/schat/internal/repositories"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type CreateGroupDto struct {
+	Origin
 	GroupName   string   `json:"group_name"`
 	Description string   `json:"description"`
 	Members     []string `json:"members"`
@@ -23,55 +25,81 @@ const (
 )
 
 type GroupMembershipDto struct {
+	Origin
+	GroupID  string                `json:"group_id"`
+	MemberID string                `json:"member_id"`
+	Action   GroupMembershipAction `json:"action"`
+}
+
+type GroupMuteDto struct {
+	Origin
+	GroupID string `json:"group_id"`
+	Muted   bool   `json:"muted"`
+}
+
+// MembershipUpdate is pushed to the group's members when someone joins or
+// leaves, the removed member included.
+type MembershipUpdate struct {
+	GroupID  string                `json:"group_id"`
 	MemberID string                `json:"member_id"`
 	Action   GroupMembershipAction `json:"action"`
 }
 
 type groupService struct {
-	userRepo  repos.UserRepoInterface
-	groupRepo repos.GroupRepoInterface
+	userRepo   repos.UserRepoInterface
+	groupRepo  repos.GroupRepoInterface
+	outboxRepo repos.OutboxRepoInterface
 }
 
 type GroupServiceInterface interface {
-	CreateGroup(userID uuid.UUID, data CreateGroupDto) error
+	CreateGroup(userID uuid.UUID, data CreateGroupDto) (models.Group, error)
 	GetGroupMembers(groupID uuid.UUID) ([]models.GroupMember, error)
-	HandleMembership(groupID, adminID, memberID uuid.UUID, action GroupMembershipAction) error
+	HandleMembership(adminID uuid.UUID, data GroupMembershipDto) (MembershipUpdate, error)
+	SetMuted(userID uuid.UUID, data GroupMuteDto) (GroupMuteDto, error)
 }
 
 func NewGroupService(
 	userRepo repos.UserRepoInterface,
 	groupRepo repos.GroupRepoInterface,
+	outboxRepo repos.OutboxRepoInterface,
 ) GroupServiceInterface {
 	return &groupService{
-		userRepo:  userRepo,
-		groupRepo: groupRepo,
+		userRepo:   userRepo,
+		groupRepo:  groupRepo,
+		outboxRepo: outboxRepo,
 	}
 }
 
 var (
-	ErrNotAdmin = errors.New("user not group admin")
+	ErrNotAdmin          = errors.New("user not group admin")
+	ErrInvalidMemberID   = errors.New("invalid member id")
+	ErrInvalidMembership = errors.New("invalid action")
 )
 
-func (g *groupService) CreateGroup(userID uuid.UUID, data CreateGroupDto) error {
-	// tx := g.groupRepo.BeginDBTx()
+func (g *groupService) CreateGroup(userID uuid.UUID, data CreateGroupDto) (models.Group, error) {
 	group := models.Group{
 		CreatorID:   userID,
 		Name:        data.GroupName,
 		Description: &data.Description,
 	}
-	//if group is not modified id will be (0000-0000-0000), watch out for that
-	if err := g.groupRepo.CreateGroup(&group); err != nil {
-		// tx.Rollback()
-		return err
-	}
 
-	members := g.buildMembershipSlice(group.ID, userID, data.Members)
-	if err := g.groupRepo.CreateGroupMembership(nil, &members); err != nil {
-		// tx.Rollback()
-		return err
+	err := inTx(g.groupRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		//if group is not modified id will be (0000-0000-0000), watch out for that
+		if err := g.groupRepo.CreateGroup(tx, &group); err != nil {
+			return err
+		}
+
+		members := g.buildMembershipSlice(group.ID, userID, data.Members)
+		if err := g.groupRepo.CreateGroupMembership(tx, &members); err != nil {
+			return err
+		}
+		return notify(tx, g.outboxRepo, NoticeGroupCreate, group, fanOut(memberIDs(members), userID, data.Origin))
+	})
+	if err != nil {
+		return models.Group{}, err
 	}
-	//tx.Commit()
-	return nil
+
+	return group, nil
 }
 
 func (g *groupService) buildMembershipSlice(groupID, adminID uuid.UUID, membersID []string) []models.GroupMember {
@@ -102,7 +130,7 @@ func (g *groupService) buildMembershipSlice(groupID, adminID uuid.UUID, membersI
 	return members
 }
 
-func (g *groupService) addToGroup(groupID, adminID, newMemberID uuid.UUID) error {
+func (g *groupService) addToGroup(tx *gorm.DB, groupID, adminID, newMemberID uuid.UUID) error {
 	_, err := g.userRepo.FindByID(newMemberID)
 	if err != nil {
 		return err
@@ -122,7 +150,7 @@ func (g *groupService) addToGroup(groupID, adminID, newMemberID uuid.UUID) error
 		Role:    models.Member,
 	}
 
-	if err := g.groupRepo.CreateGroupMembership(nil, &[]models.GroupMember{memberShip}); err != nil {
+	if err := g.groupRepo.CreateGroupMembership(tx, &[]models.GroupMember{memberShip}); err != nil {
 		return err
 	}
 
@@ -133,20 +161,58 @@ func (g *groupService) GetGroupMembers(groupID uuid.UUID) ([]models.GroupMember,
 	return g.groupRepo.GetGroupMembers(groupID)
 }
 
-func (g *groupService) HandleMembership(groupID, adminID, memberID uuid.UUID, action GroupMembershipAction) error {
-	switch action {
-	case Add:
-		return g.addToGroup(groupID, adminID, memberID)
-	case Remove:
-		return g.removeFromGroup(groupID, adminID, memberID)
-	default:
-		return errors.New("invalid action")
+func (g *groupService) HandleMembership(adminID uuid.UUID, data GroupMembershipDto) (MembershipUpdate, error) {
+	groupID, err := uuid.Parse(data.GroupID)
+	if err != nil {
+		return MembershipUpdate{}, ErrInvalidID
+	}
+	memberID, err := uuid.Parse(data.MemberID)
+	if err != nil {
+		return MembershipUpdate{}, ErrInvalidMemberID
+	}
+	if data.Action != Add && data.Action != Remove {
+		return MembershipUpdate{}, ErrInvalidMembership
+	}
+
+	// everyone in the group before the change hears about it, plus the
+	// member who joined or left
+	members, err := g.groupRepo.GetGroupMembers(groupID)
+	if err != nil {
+		return MembershipUpdate{}, err
+	}
+	participants := memberIDs(members)
+	if !containsID(participants, memberID) {
+		participants = append(participants, memberID)
+	}
+
+	update := MembershipUpdate{
+		GroupID:  groupID.String(),
+		MemberID: memberID.String(),
+		Action:   data.Action,
+	}
+
+	err = inTx(g.groupRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		var err error
+		if data.Action == Add {
+			err = g.addToGroup(tx, groupID, adminID, memberID)
+		} else {
+			err = g.removeFromGroup(tx, groupID, adminID, memberID)
+		}
+		if err != nil {
+			return err
+		}
+		return notify(tx, g.outboxRepo, NoticeGroupMembership, update, fanOut(participants, adminID, data.Origin))
+	})
+	if err != nil {
+		return MembershipUpdate{}, err
 	}
+
+	return update, nil
 }
 
-func (g *groupService) removeFromGroup(groupID, adminID, memberID uuid.UUID) error {
+func (g *groupService) removeFromGroup(tx *gorm.DB, groupID, adminID, memberID uuid.UUID) error {
 	if g.isGroupAdmin(groupID, adminID) {
-		return g.groupRepo.RevokeMembership(groupID, memberID)
+		return g.groupRepo.RevokeMembership(tx, groupID, memberID)
 	}
 
 	return ErrNotAdmin
@@ -159,3 +225,40 @@ func (g *groupService) isGroupAdmin(groupID, userID uuid.UUID) bool {
 	}
 	return membership.Role == models.Admin
 }
+
+// SetMuted silences the group's notifications for the user. Muted members
+// keep receiving messages, replies to their own messages still notify.
+func (g *groupService) SetMuted(userID uuid.UUID, data GroupMuteDto) (GroupMuteDto, error) {
+	groupID, err := uuid.Parse(data.GroupID)
+	if err != nil {
+		return data, ErrInvalidID
+	}
+	if _, err := g.groupRepo.GetGroupMember(groupID, userID); err != nil {
+		return data, ErrNotMember
+	}
+
+	err = inTx(g.groupRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		if err := g.groupRepo.SetMuted(tx, groupID, userID, data.Muted); err != nil {
+			return err
+		}
+		return notify(tx, g.outboxRepo, NoticeGroupMute, data, fanOut([]uuid.UUID{userID}, userID, data.Origin))
+	})
+	return data, err
+}
+
+func memberIDs(members []models.GroupMember) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.UserID)
+	}
+	return ids
+}
+
+func containsID(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"errors"
+	"shiplabs/schat/internal/models"
+	repos "shiplabs/schat/internal/repositories"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// HistoryQuery is the raw pagination input. Before and After accept either
+// a message ID or an RFC 3339 timestamp.
+type HistoryQuery struct {
+	Before string `form:"before"`
+	After  string `form:"after"`
+	Limit  int    `form:"limit"`
+}
+
+type PageInfo struct {
+	HasMore bool       `json:"has_more"`
+	Before  *uuid.UUID `json:"before"`
+	After   *uuid.UUID `json:"after"`
+}
+
+type PrivateHistory struct {
+	Messages []models.PrivateMessage `json:"messages"`
+	PageInfo PageInfo                `json:"page_info"`
+}
+
+type GroupHistory struct {
+	Messages []models.GroupMessage `json:"messages"`
+	PageInfo PageInfo              `json:"page_info"`
+}
+
+var (
+	ErrInvalidCursor = errors.New("cursor must be a message id or an RFC 3339 timestamp")
+	ErrBothCursors   = errors.New("before and after cannot be combined")
+)
+
+func (c *chatService) GetPrivateChatHistory(userID, chatID uuid.UUID, query HistoryQuery) (PrivateHistory, error) {
+	history := PrivateHistory{Messages: []models.PrivateMessage{}}
+
+	chat, err := c.privateChatRepo.FindByID(chatID)
+	if err != nil {
+		return history, ErrChat404
+	}
+	if chat.FirstMemberID != userID && chat.SecondMemberID != userID {
+		return history, ErrNotMember
+	}
+
+	page, err := buildPage(query, func(id uuid.UUID) (repos.Cursor, bool) {
+		msgs, err := c.privateMessageRepo.FindByIDs([]uuid.UUID{id})
+		if err != nil || len(msgs) == 0 || msgs[0].ChatID != chatID {
+			return repos.Cursor{}, false
+		}
+		return repos.Cursor{CreatedAt: msgs[0].CreatedAt, ID: id}, true
+	})
+	if err != nil {
+		return history, err
+	}
+
+	requested := page.Limit
+	page.Limit++
+	page.ViewerID = userID
+	messages, err := c.privateMessageRepo.GetChatMessages(chatID, page)
+	if err != nil {
+		return history, err
+	}
+
+	messages, hasMore := trimPage(messages, requested, page.After != nil)
+	if err := attachReplies(messages, c.privateMessageRepo.FindByIDs); err != nil {
+		return history, err
+	}
+	history.Messages = messages
+	history.PageInfo.HasMore = hasMore
+	if len(messages) > 0 {
+		history.PageInfo.Before = &messages[0].ID
+		history.PageInfo.After = &messages[len(messages)-1].ID
+	}
+
+	return history, nil
+}
+
+func (c *chatService) GetGroupHistory(userID, groupID uuid.UUID, query HistoryQuery) (GroupHistory, error) {
+	if _, err := c.groupRepo.GetGroupMember(groupID, userID); err != nil {
+		return GroupHistory{Messages: []models.GroupMessage{}}, ErrNotMember
+	}
+
+	return c.groupPage(userID, query,
+		func(msg models.GroupMessage) bool { return msg.GroupID == groupID },
+		func(page repos.MessagePage) ([]models.GroupMessage, error) {
+			return c.groupMsgRepo.GetGroupMessages(groupID, page)
+		},
+	)
+}
+
+// GetThreadHistory pages through the replies of a thread, oldest first.
+func (c *chatService) GetThreadHistory(userID, groupID, rootID uuid.UUID, query HistoryQuery) (GroupHistory, error) {
+	empty := GroupHistory{Messages: []models.GroupMessage{}}
+	if _, err := c.groupRepo.GetGroupMember(groupID, userID); err != nil {
+		return empty, ErrNotMember
+	}
+	roots, err := c.groupMsgRepo.FindByIDs([]uuid.UUID{rootID})
+	if err != nil || len(roots) == 0 || roots[0].GroupID != groupID || roots[0].ThreadRootID != nil {
+		return empty, ErrMessageNotFound
+	}
+
+	return c.groupPage(userID, query,
+		func(msg models.GroupMessage) bool { return msg.ThreadRootID != nil && *msg.ThreadRootID == rootID },
+		func(page repos.MessagePage) ([]models.GroupMessage, error) {
+			return c.groupMsgRepo.GetThreadMessages(rootID, page)
+		},
+	)
+}
+
+// groupPage runs a paginated group query. inScope tells whether a message
+// used as a cursor belongs to the listing being paged.
+func (c *chatService) groupPage(
+	userID uuid.UUID,
+	query HistoryQuery,
+	inScope func(msg models.GroupMessage) bool,
+	fetch func(page repos.MessagePage) ([]models.GroupMessage, error),
+) (GroupHistory, error) {
+	history := GroupHistory{Messages: []models.GroupMessage{}}
+
+	page, err := buildPage(query, func(id uuid.UUID) (repos.Cursor, bool) {
+		msgs, err := c.groupMsgRepo.FindByIDs([]uuid.UUID{id})
+		if err != nil || len(msgs) == 0 || !inScope(msgs[0]) {
+			return repos.Cursor{}, false
+		}
+		return repos.Cursor{CreatedAt: msgs[0].CreatedAt, ID: id}, true
+	})
+	if err != nil {
+		return history, err
+	}
+
+	requested := page.Limit
+	page.Limit++
+	page.ViewerID = userID
+	messages, err := fetch(page)
+	if err != nil {
+		return history, err
+	}
+
+	messages, hasMore := trimPage(messages, requested, page.After != nil)
+	if err := attachReplies(messages, c.groupMsgRepo.FindByIDs); err != nil {
+		return history, err
+	}
+	history.Messages = messages
+	history.PageInfo.HasMore = hasMore
+	if len(messages) > 0 {
+		history.PageInfo.Before = &messages[0].ID
+		history.PageInfo.After = &messages[len(messages)-1].ID
+	}
+
+	return history, nil
+}
+
+// buildPage resolves the query's cursors. Message ID cursors are looked up
+// through resolve so they only work inside the conversation being read.
+func buildPage(query HistoryQuery, resolve func(id uuid.UUID) (repos.Cursor, bool)) (repos.MessagePage, error) {
+	page := repos.MessagePage{Limit: query.Limit}
+	if page.Limit <= 0 || page.Limit > repos.MaxPageSize {
+		page.Limit = repos.DefaultPageSize
+	}
+	if query.Before != "" && query.After != "" {
+		return page, ErrBothCursors
+	}
+
+	parse := func(raw string) (*repos.Cursor, error) {
+		if raw == "" {
+			return nil, nil
+		}
+		if id, err := uuid.Parse(raw); err == nil {
+			cursor, ok := resolve(id)
+			if !ok {
+				return nil, ErrMessageNotFound
+			}
+			return &cursor, nil
+		}
+		ts, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return &repos.Cursor{CreatedAt: ts.UTC()}, nil
+	}
+
+	var err error
+	if page.Before, err = parse(query.Before); err != nil {
+		return page, err
+	}
+	if page.After, err = parse(query.After); err != nil {
+		return page, err
+	}
+
+	return page, nil
+}
+
+// trimPage drops the extra row fetched to detect another page. Forward
+// pages are oldest first so the extra row is at the end, backward pages are
+// already reversed into chronological order so it is at the start.
+func trimPage[T any](messages []T, limit int, forward bool) ([]T, bool) {
+	if len(messages) <= limit {
+		return messages, false
+	}
+	if forward {
+		return messages[:limit], true
+	}
+	return messages[len(messages)-limit:], true
+}
+
+type replyable interface {
+	models.PrivateMessage | models.GroupMessage
+}
+
+// attachReplies fills in the quoted parent of every reply, looking the
+// parents up with find.
+func attachReplies[T replyable](messages []T, find func(ids []uuid.UUID) ([]T, error)) error {
+	var ids []uuid.UUID
+	for i := range messages {
+		if replyTo := baseOf(&messages[i]).ReplyToID; replyTo != nil {
+			ids = append(ids, *replyTo)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	parents, err := find(ids)
+	if err != nil {
+		return err
+	}
+
+	previews := map[uuid.UUID]*models.MessagePreview{}
+	for i := range parents {
+		parent := baseOf(&parents[i])
+		previews[parent.ID] = parent.Preview()
+	}
+	for i := range messages {
+		base := baseOf(&messages[i])
+		if base.ReplyToID != nil {
+			base.ReplyTo = previews[*base.ReplyToID]
+		}
+	}
+	return nil
+}
+
+func baseOf[T replyable](msg *T) *models.BaseMessage {
+	switch m := any(msg).(type) {
+	case *models.PrivateMessage:
+		return &m.BaseMessage
+	case *models.GroupMessage:
+		return &m.BaseMessage
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"errors"
+	repos "shiplabs/schat/internal/repositories"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestBuildPage(t *testing.T) {
+	known := uuid.New()
+	knownCursor := repos.Cursor{CreatedAt: time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC), ID: known}
+	resolve := func(id uuid.UUID) (repos.Cursor, bool) {
+		return knownCursor, id == known
+	}
+	ts := time.Date(2026, 10, 18, 11, 0, 0, 500, time.FixedZone("CEST", 2*60*60))
+
+	tests := []struct {
+		name       string
+		query      HistoryQuery
+		wantLimit  int
+		wantBefore *repos.Cursor
+		wantAfter  *repos.Cursor
+		wantErr    error
+	}{
+		{"latest", HistoryQuery{}, repos.DefaultPageSize, nil, nil, nil},
+		{"limit", HistoryQuery{Limit: 10}, 10, nil, nil, nil},
+		{"limit over the max", HistoryQuery{Limit: repos.MaxPageSize + 1}, repos.DefaultPageSize, nil, nil, nil},
+		{"negative limit", HistoryQuery{Limit: -1}, repos.DefaultPageSize, nil, nil, nil},
+		{"before a message", HistoryQuery{Before: known.String()}, repos.DefaultPageSize, &knownCursor, nil, nil},
+		{"after a timestamp", HistoryQuery{After: ts.Format(time.RFC3339Nano)}, repos.DefaultPageSize, nil,
+			&repos.Cursor{CreatedAt: ts.UTC()}, nil},
+		{"message of another conversation", HistoryQuery{Before: uuid.NewString()}, 0, nil, nil, ErrMessageNotFound},
+		{"garbage cursor", HistoryQuery{After: "yesterday"}, 0, nil, nil, ErrInvalidCursor},
+		{"both cursors", HistoryQuery{Before: known.String(), After: known.String()}, 0, nil, nil, ErrBothCursors},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := buildPage(tt.query, resolve)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("buildPage() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if page.Limit != tt.wantLimit {
+				t.Fatalf("Limit = %d, want %d", page.Limit, tt.wantLimit)
+			}
+			if !sameCursor(page.Before, tt.wantBefore) || !sameCursor(page.After, tt.wantAfter) {
+				t.Fatalf("cursors = %v, %v, want %v, %v", page.Before, page.After, tt.wantBefore, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func sameCursor(a, b *repos.Cursor) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.CreatedAt.Equal(b.CreatedAt) && a.ID == b.ID
+}
+
+func TestTrimPage(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []int
+		limit    int
+		forward  bool
+		want     []int
+		wantMore bool
+	}{
+		{"short page", []int{1, 2}, 3, false, []int{1, 2}, false},
+		{"exact page", []int{1, 2, 3}, 3, true, []int{1, 2, 3}, false},
+		{"forward drops the newest", []int{1, 2, 3, 4}, 3, true, []int{1, 2, 3}, true},
+		{"backward drops the oldest", []int{1, 2, 3, 4}, 3, false, []int{2, 3, 4}, true},
+		{"empty", nil, 3, true, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, more := trimPage(tt.messages, tt.limit, tt.forward)
+			if !slices.Equal(got, tt.want) || more != tt.wantMore {
+				t.Fatalf("trimPage() = %v, %t, want %v, %t", got, more, tt.want, tt.wantMore)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"errors"
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/pkg/shared"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type DeleteScope string
+
+const (
+	DeleteForMe       DeleteScope = "me"
+	DeleteForEveryone DeleteScope = "everyone"
+)
+
+// MessageRef points at a message inside a conversation.
+type MessageRef struct {
+	ConversationType models.ConversationType `json:"conversation_type"`
+	MessageID        string                  `json:"message_id"`
+}
+
+type EditMessageDto struct {
+	Origin
+	MessageRef
+	Content string `json:"content"`
+}
+
+type DeleteMessageDto struct {
+	Origin
+	MessageRef
+	Scope DeleteScope `json:"scope"`
+}
+
+// MessageChange describes an edit or delete so it can be broadcast to the
+// participants of the conversation.
+type MessageChange struct {
+	ConversationType models.ConversationType `json:"conversation_type"`
+	ConversationID   uuid.UUID               `json:"conversation_id"`
+	MessageID        uuid.UUID               `json:"message_id"`
+	Seq              int64                   `json:"seq"`
+	Scope            DeleteScope             `json:"scope,omitempty"`
+	Message          any                     `json:"message,omitempty"`
+}
+
+var (
+	ErrNotSender           = errors.New("only the sender can change this message")
+	ErrMessageDeleted      = errors.New("message has been deleted")
+	ErrNotEditable         = errors.New("only text messages can be edited")
+	ErrInvalidScope        = errors.New("scope must be me or everyone")
+	ErrInvalidConversation = errors.New("conversation type must be private or group")
+)
+
+// conversationMessage is the part of a private or group message the
+// edit and delete rules care about.
+type conversationMessage struct {
+	base           models.BaseMessage
+	conversationID uuid.UUID
+	seq            int64
+}
+
+func (c *chatService) findMessage(userID uuid.UUID, ref MessageRef) (conversationMessage, []uuid.UUID, error) {
+	messageID, err := uuid.Parse(ref.MessageID)
+	if err != nil {
+		return conversationMessage{}, nil, ErrInvalidID
+	}
+
+	var msg conversationMessage
+	switch ref.ConversationType {
+	case models.PrivateConversation:
+		msgs, err := c.privateMessageRepo.FindByIDs([]uuid.UUID{messageID})
+		if err != nil || len(msgs) == 0 {
+			return msg, nil, ErrMessageNotFound
+		}
+		msg = conversationMessage{base: msgs[0].BaseMessage, conversationID: msgs[0].ChatID, seq: msgs[0].Seq}
+	case models.GroupConversation:
+		msgs, err := c.groupMsgRepo.FindByIDs([]uuid.UUID{messageID})
+		if err != nil || len(msgs) == 0 {
+			return msg, nil, ErrMessageNotFound
+		}
+		msg = conversationMessage{base: msgs[0].BaseMessage, conversationID: msgs[0].GroupID, seq: msgs[0].Seq}
+	default:
+		return msg, nil, ErrInvalidConversation
+	}
+
+	participants, err := c.GetParticipants(ref.ConversationType, msg.conversationID)
+	if err != nil {
+		return msg, nil, err
+	}
+	for _, participant := range participants {
+		if participant == userID {
+			return msg, participants, nil
+		}
+	}
+
+	return msg, nil, ErrNotMember
+}
+
+// GetParticipants lists every user taking part in a conversation.
+func (c *chatService) GetParticipants(conversationType models.ConversationType, conversationID uuid.UUID) ([]uuid.UUID, error) {
+	switch conversationType {
+	case models.PrivateConversation:
+		chat, err := c.privateChatRepo.FindByID(conversationID)
+		if err != nil {
+			return nil, ErrChat404
+		}
+		return []uuid.UUID{chat.FirstMemberID, chat.SecondMemberID}, nil
+	case models.GroupConversation:
+		members, err := c.groupRepo.GetGroupMembers(conversationID)
+		if err != nil {
+			return nil, err
+		}
+		participants := make([]uuid.UUID, 0, len(members))
+		for _, member := range members {
+			participants = append(participants, member.UserID)
+		}
+		return participants, nil
+	default:
+		return nil, ErrInvalidConversation
+	}
+}
+
+func (c *chatService) EditMessage(userID uuid.UUID, data EditMessageDto) (MessageChange, error) {
+	msg, participants, err := c.findMessage(userID, data.MessageRef)
+	if err != nil {
+		return MessageChange{}, err
+	}
+	if msg.base.SenderID != userID {
+		return MessageChange{}, ErrNotSender
+	}
+	if msg.base.DeletedForAllAt != nil {
+		return MessageChange{}, ErrMessageDeleted
+	}
+	if msg.base.Type != models.TEXT {
+		return MessageChange{}, ErrNotEditable
+	}
+	if strings.TrimSpace(data.Content) == "" {
+		return MessageChange{}, ErrEmptyMessage
+	}
+
+	editedAt := shared.TimeNow()
+	change := MessageChange{
+		ConversationType: data.ConversationType,
+		ConversationID:   msg.conversationID,
+		MessageID:        msg.base.ID,
+		Seq:              msg.seq,
+	}
+
+	err = inTx(c.messageActionRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		// the content being replaced is read under lock, a concurrent edit
+		// may have changed it since findMessage
+		var current models.BaseMessage
+		var err error
+		if data.ConversationType == models.GroupConversation {
+			current, err = c.groupMsgRepo.LockForUpdate(tx, msg.base.ID)
+		} else {
+			current, err = c.privateMessageRepo.LockForUpdate(tx, msg.base.ID)
+		}
+		if err != nil {
+			return err
+		}
+		if current.DeletedForAllAt != nil {
+			return ErrMessageDeleted
+		}
+
+		edit := &models.MessageEdit{
+			MessageID:        msg.base.ID,
+			ConversationType: data.ConversationType,
+			PreviousContent:  current.Content,
+		}
+		if err := c.messageActionRepo.CreateEdit(tx, edit); err != nil {
+			return err
+		}
+
+		current.Content = data.Content
+		current.EditedAt = &editedAt
+		change.Message = current
+
+		if data.ConversationType == models.GroupConversation {
+			err = c.groupMsgRepo.UpdateContent(tx, msg.base.ID, data.Content, editedAt)
+		} else {
+			err = c.privateMessageRepo.UpdateContent(tx, msg.base.ID, data.Content, editedAt)
+		}
+		if err != nil {
+			return err
+		}
+		return notify(tx, c.outboxRepo, NoticeMessageEdit, change, fanOut(participants, userID, data.Origin))
+	})
+	if err != nil {
+		return MessageChange{}, err
+	}
+
+	return change, nil
+}
+
+// DeleteMessage hides the message for the caller only, or tombstones it for
+// everyone. Deleting for everyone is reserved to the sender and, in groups,
+// to group admins.
+func (c *chatService) DeleteMessage(userID uuid.UUID, data DeleteMessageDto) (MessageChange, error) {
+	msg, participants, err := c.findMessage(userID, data.MessageRef)
+	if err != nil {
+		return MessageChange{}, err
+	}
+
+	change := MessageChange{
+		ConversationType: data.ConversationType,
+		ConversationID:   msg.conversationID,
+		MessageID:        msg.base.ID,
+		Seq:              msg.seq,
+		Scope:            data.Scope,
+	}
+
+	switch data.Scope {
+	case DeleteForMe:
+		// only the caller's other devices need to hear about it
+		err := inTx(c.messageActionRepo.BeginDBTx(), func(tx *gorm.DB) error {
+			if err := c.messageActionRepo.HideMessage(tx, userID, msg.base.ID); err != nil {
+				return err
+			}
+			return notify(tx, c.outboxRepo, NoticeMessageDelete, change, fanOut([]uuid.UUID{userID}, userID, data.Origin))
+		})
+		return change, err
+	case DeleteForEveryone:
+	default:
+		return change, ErrInvalidScope
+	}
+
+	if msg.base.SenderID != userID && !c.isGroupAdmin(data.ConversationType, msg.conversationID, userID) {
+		return change, ErrNotSender
+	}
+
+	deletedAt := shared.TimeNow()
+	err = inTx(c.messageActionRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		// checked under lock, a concurrent delete must not be announced twice
+		var current models.BaseMessage
+		var err error
+		if data.ConversationType == models.GroupConversation {
+			current, err = c.groupMsgRepo.LockForUpdate(tx, msg.base.ID)
+		} else {
+			current, err = c.privateMessageRepo.LockForUpdate(tx, msg.base.ID)
+		}
+		if err != nil {
+			return err
+		}
+		if current.DeletedForAllAt != nil {
+			return ErrMessageDeleted
+		}
+
+		if data.ConversationType == models.GroupConversation {
+			err = c.groupMsgRepo.MarkDeletedForAll(tx, msg.base.ID, deletedAt)
+		} else {
+			err = c.privateMessageRepo.MarkDeletedForAll(tx, msg.base.ID, deletedAt)
+		}
+		if err != nil {
+			return err
+		}
+		return notify(tx, c.outboxRepo, NoticeMessageDelete, change, fanOut(participants, userID, data.Origin))
+	})
+	return change, err
+}
+
+func (c *chatService) GetEditHistory(userID uuid.UUID, ref MessageRef) ([]models.MessageEdit, error) {
+	msg, _, err := c.findMessage(userID, ref)
+	if err != nil {
+		return nil, err
+	}
+	if msg.base.DeletedForAllAt != nil {
+		return []models.MessageEdit{}, nil
+	}
+
+	return c.messageActionRepo.GetEdits(msg.base.ID)
+}
+
+func (c *chatService) isGroupAdmin(conversationType models.ConversationType, groupID, userID uuid.UUID) bool {
+	if conversationType != models.GroupConversation {
+		return false
+	}
+	membership, err := c.groupRepo.GetGroupMember(groupID, userID)
+	if err != nil {
+		return false
+	}
+	return membership.Role == models.Admin
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"errors"
+	"shiplabs/schat/internal/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDeleteForEveryone(t *testing.T) {
+	sender, partner := uuid.New(), uuid.New()
+	chat := models.PrivateChat{ID: uuid.New(), FirstMemberID: sender, SecondMemberID: partner}
+	deletedAt := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	deleteConcurrently := func(f *fakePrivateMessages, id uuid.UUID) {
+		f.MarkDeletedForAll(nil, id, deletedAt)
+	}
+
+	tests := []struct {
+		name        string
+		userID      uuid.UUID
+		deleted     bool
+		beforeLock  func(*fakePrivateMessages, uuid.UUID)
+		wantErr     error
+		wantNotices int
+	}{
+		{"sender deletes", sender, false, nil, nil, 1},
+		{"not the sender", partner, false, nil, ErrNotSender, 0},
+		{"already deleted", sender, true, nil, ErrMessageDeleted, 0},
+		{"deleted after it was read", sender, false, deleteConcurrently, ErrMessageDeleted, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := models.PrivateMessage{ChatID: chat.ID, Seq: 1}
+			message.ID = uuid.New()
+			message.SenderID = sender
+			if tt.deleted {
+				message.DeletedForAllAt = &deletedAt
+			}
+			db := newTestDB(t)
+			messages := newFakePrivateMessages(message)
+			messages.beforeLock = tt.beforeLock
+			outbox := &fakeOutbox{testDB: db}
+			service := &chatService{
+				privateChatRepo:    newFakePrivateChats(db, chat),
+				privateMessageRepo: messages,
+				messageActionRepo:  &fakeMessageActions{testDB: db},
+				outboxRepo:         outbox,
+			}
+
+			_, err := service.DeleteMessage(tt.userID, DeleteMessageDto{
+				MessageRef: MessageRef{ConversationType: models.PrivateConversation, MessageID: message.ID.String()},
+				Scope:      DeleteForEveryone,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DeleteMessage() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(outbox.events) != tt.wantNotices {
+				t.Fatalf("%d delete notices queued, want %d", len(outbox.events), tt.wantNotices)
+			}
+			if tt.wantErr == nil && messages.messages[message.ID].DeletedForAllAt == nil {
+				t.Fatal("message not marked deleted")
+			}
+		})
+	}
+}
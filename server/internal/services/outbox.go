@@ -0,0 +1,189 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"shiplabs/schat/internal/models"
+	repos "shiplabs/schat/internal/repositories"
+	"shiplabs/schat/pkg/shared"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Notice kinds double as the event types clients receive.
+const (
+	NoticePrivateMessage  = "private_message"
+	NoticeGroupMessage    = "group_message"
+	NoticeGroupCreate     = "group_create"
+	NoticeGroupMembership = "group_membership"
+	NoticeGroupMute       = "group_mute"
+	NoticeReceipt         = "receipt"
+	NoticeMessageEdit     = "message_edit"
+	NoticeMessageDelete   = "message_delete"
+	NoticeReactionAdd     = "reaction_add"
+	NoticeReactionRemove  = "reaction_remove"
+	NoticeSessionRevoked  = "session_revoked"
+)
+
+// Origin identifies the device a change came from. It already gets the
+// change as the reply to its request so the fan-out skips it.
+type Origin struct {
+	DeviceID string `json:"-"`
+}
+
+// Recipient is one user a notice is pushed to. Track marks recipients of a
+// new message whose delivery receipt is settled once they get it.
+type Recipient struct {
+	UserID     uuid.UUID `json:"user_id"`
+	SkipDevice string    `json:"skip_device,omitempty"`
+	Silent     bool      `json:"silent,omitempty"`
+	Track      bool      `json:"track,omitempty"`
+}
+
+// Notice is a realtime notification waiting in the outbox.
+type Notice struct {
+	ID         uuid.UUID
+	Kind       string
+	Payload    json.RawMessage
+	Recipients []Recipient
+	MessageID  *uuid.UUID
+}
+
+type outboxService struct {
+	outboxRepo repos.OutboxRepoInterface
+}
+
+type OutboxServiceInterface interface {
+	Claim(limit int, lease time.Duration) ([]Notice, error)
+	Complete(id uuid.UUID) error
+	Purge(olderThan time.Duration) error
+}
+
+func NewOutboxService(outboxRepo repos.OutboxRepoInterface) OutboxServiceInterface {
+	return &outboxService{
+		outboxRepo: outboxRepo,
+	}
+}
+
+// outboxWake lets the local dispatcher pick events up right after commit
+// instead of waiting for its next poll. Other instances still poll.
+var outboxWake = make(chan struct{}, 1)
+
+func OutboxWake() <-chan struct{} {
+	return outboxWake
+}
+
+func wakeOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// inTx runs fn inside tx and commits it, waking the dispatcher once the
+// outbox events written by fn are visible.
+func inTx(tx *gorm.DB, fn func(tx *gorm.DB) error) error {
+	if err := tx.Error; err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	wakeOutbox()
+	return nil
+}
+
+func newNotice(kind string, payload any, recipients []Recipient) (models.OutboxEvent, error) {
+	rawPayload, err := json.Marshal(payload)
+	if err != nil {
+		return models.OutboxEvent{}, err
+	}
+	rawRecipients, err := json.Marshal(recipients)
+	if err != nil {
+		return models.OutboxEvent{}, err
+	}
+
+	return models.OutboxEvent{
+		Kind:        kind,
+		Payload:     rawPayload,
+		Recipients:  rawRecipients,
+		AvailableAt: shared.TimeNow(),
+	}, nil
+}
+
+// notify queues a notice inside the transaction.
+func notify(tx *gorm.DB, outboxRepo repos.OutboxRepoInterface, kind string, payload any, recipients []Recipient) error {
+	if len(recipients) == 0 {
+		return nil
+	}
+	event, err := newNotice(kind, payload, recipients)
+	if err != nil {
+		return err
+	}
+	return outboxRepo.Append(tx, []models.OutboxEvent{event})
+}
+
+// notifyMessage queues the notice of a new message. Tracked recipients
+// have a delivery record that is settled when the notice reaches them.
+func notifyMessage(tx *gorm.DB, outboxRepo repos.OutboxRepoInterface, kind string, messageID uuid.UUID, payload any, recipients []Recipient) error {
+	event, err := newNotice(kind, payload, recipients)
+	if err != nil {
+		return err
+	}
+	event.MessageID = &messageID
+	return outboxRepo.Append(tx, []models.OutboxEvent{event})
+}
+
+// fanOut addresses every participant, skipping the origin device for the
+// user who made the change.
+func fanOut(participants []uuid.UUID, actorID uuid.UUID, origin Origin) []Recipient {
+	recipients := make([]Recipient, 0, len(participants))
+	for _, participant := range participants {
+		recipient := Recipient{UserID: participant}
+		if participant == actorID {
+			recipient.SkipDevice = origin.DeviceID
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients
+}
+
+func (o *outboxService) Claim(limit int, lease time.Duration) ([]Notice, error) {
+	events, err := o.outboxRepo.Claim(limit, lease)
+	if err != nil {
+		return nil, err
+	}
+
+	notices := make([]Notice, 0, len(events))
+	for _, event := range events {
+		notice := Notice{
+			ID:        event.ID,
+			Kind:      event.Kind,
+			Payload:   event.Payload,
+			MessageID: event.MessageID,
+		}
+		if err := json.Unmarshal(event.Recipients, &notice.Recipients); err != nil {
+			// retrying won't make the recipients readable
+			if err := o.outboxRepo.MarkFailed(event.ID, err.Error()); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		notices = append(notices, notice)
+	}
+	return notices, nil
+}
+
+func (o *outboxService) Complete(id uuid.UUID) error {
+	return o.outboxRepo.MarkDispatched(id)
+}
+
+func (o *outboxService) Purge(olderThan time.Duration) error {
+	return o.outboxRepo.PurgeDispatched(shared.TimeNow().Add(-olderThan))
+}
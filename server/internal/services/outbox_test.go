@@ -0,0 +1,63 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func TestOutboxClaim(t *testing.T) {
+	const lease = 50 * time.Millisecond
+	db := newTestDB(t)
+	outbox := &fakeOutbox{testDB: db}
+	service := &outboxService{outboxRepo: outbox}
+	userID := uuid.New()
+
+	err := inTx(db.BeginDBTx(), func(tx *gorm.DB) error {
+		for _, kind := range []string{NoticeGroupCreate, NoticeGroupMembership, NoticeGroupMute} {
+			if err := notify(tx, outbox, kind, map[string]string{"kind": kind}, []Recipient{{UserID: userID}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// recipients written by an older release the dispatcher can't read
+	outbox.events[1].Recipients = json.RawMessage(`{"user_id": 1}`)
+
+	claimed, err := service.Claim(10, lease)
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+	if len(claimed) != 2 || claimed[0].Kind != NoticeGroupCreate || claimed[1].Kind != NoticeGroupMute {
+		t.Fatalf("Claim() = %+v, want the readable notices in order", claimed)
+	}
+	if recipients := claimed[0].Recipients; len(recipients) != 1 || recipients[0].UserID != userID {
+		t.Fatalf("Recipients = %+v, want %s", recipients, userID)
+	}
+	if failed := outbox.events[1]; failed.DispatchedAt == nil || failed.LastError == "" {
+		t.Fatalf("unreadable event = %+v, want it retired with the error", failed)
+	}
+
+	if again, _ := service.Claim(10, lease); len(again) != 0 {
+		t.Fatalf("Claim() during the lease = %d notices, want none", len(again))
+	}
+
+	// the first notice got dispatched, the dispatcher of the second died
+	if err := service.Complete(claimed[0].ID); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	time.Sleep(2 * lease)
+	reclaimed, err := service.Claim(10, lease)
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+	if len(reclaimed) != 1 || reclaimed[0].ID != claimed[1].ID {
+		t.Fatalf("Claim() after the lease = %+v, want only the uncompleted notice", reclaimed)
+	}
+}
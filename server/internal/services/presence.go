@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"shiplabs/schat/internal/models"
+	repos "shiplabs/schat/internal/repositories"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PresenceStatus string
+
+const (
+	Online  PresenceStatus = "online"
+	Away    PresenceStatus = "away"
+	Offline PresenceStatus = "offline"
+)
+
+// Presence is what contacts see of a user. LastSeen is only set once the
+// user has gone offline.
+type Presence struct {
+	UserID   uuid.UUID      `json:"user_id"`
+	Status   PresenceStatus `json:"status"`
+	LastSeen *time.Time     `json:"last_seen,omitempty"`
+}
+
+// PresenceDto is sent by a client to flag itself away or back online.
+type PresenceDto struct {
+	Status PresenceStatus `json:"status"`
+}
+
+type TypingState string
+
+const (
+	TypingStarted TypingState = "started"
+	TypingStopped TypingState = "stopped"
+)
+
+type TypingDto struct {
+	ConversationType models.ConversationType `json:"conversation_type"`
+	ConversationID   string                  `json:"conversation_id"`
+	State            TypingState             `json:"state"`
+}
+
+// TypingUpdate is never persisted. ExpiresIn tells receivers how long to
+// show the indicator if no stop arrives, e.g. because the typist dropped.
+type TypingUpdate struct {
+	UserID           uuid.UUID               `json:"user_id"`
+	ConversationType models.ConversationType `json:"conversation_type"`
+	ConversationID   uuid.UUID               `json:"conversation_id"`
+	State            TypingState             `json:"state"`
+	ExpiresIn        int                     `json:"expires_in"`
+}
+
+type presenceService struct {
+	userRepo        repos.UserRepoInterface
+	privateChatRepo repos.PrivateChatRepoInterface
+	groupRepo       repos.GroupRepoInterface
+}
+
+type PresenceServiceInterface interface {
+	GetAudience(userID uuid.UUID) ([]uuid.UUID, error)
+	RecordLastSeen(userID uuid.UUID, at time.Time) error
+	GetLastSeen(userIDs []uuid.UUID) (map[uuid.UUID]time.Time, error)
+}
+
+func NewPresenceService(
+	userRepo repos.UserRepoInterface,
+	privateChatRepo repos.PrivateChatRepoInterface,
+	groupRepo repos.GroupRepoInterface,
+) PresenceServiceInterface {
+	return &presenceService{
+		userRepo:        userRepo,
+		privateChatRepo: privateChatRepo,
+		groupRepo:       groupRepo,
+	}
+}
+
+var (
+	ErrInvalidPresence = errors.New("status must be online or away")
+	ErrInvalidTyping   = errors.New("typing state must be started or stopped")
+)
+
+// GetAudience lists the users allowed to follow this user's presence:
+// private chat partners and anyone sharing a group.
+func (p *presenceService) GetAudience(userID uuid.UUID) ([]uuid.UUID, error) {
+	partners, err := p.privateChatRepo.GetPartnerIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	coMembers, err := p.groupRepo.GetCoMemberIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uuid.UUID]bool, len(partners)+len(coMembers))
+	audience := make([]uuid.UUID, 0, len(partners)+len(coMembers))
+	for _, id := range append(partners, coMembers...) {
+		if id == userID || seen[id] {
+			continue
+		}
+		seen[id] = true
+		audience = append(audience, id)
+	}
+
+	return audience, nil
+}
+
+func (p *presenceService) RecordLastSeen(userID uuid.UUID, at time.Time) error {
+	return p.userRepo.UpdateLastSeen(userID, at)
+}
+
+func (p *presenceService) GetLastSeen(userIDs []uuid.UUID) (map[uuid.UUID]time.Time, error) {
+	users, err := p.userRepo.FindByIDs(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	lastSeen := make(map[uuid.UUID]time.Time, len(users))
+	for _, user := range users {
+		if user.LastSeenAt != nil {
+			lastSeen[user.ID] = *user.LastSeenAt
+		}
+	}
+	return lastSeen, nil
+}
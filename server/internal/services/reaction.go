@@ -0,0 +1,131 @@
+package services
+
+import (
+	"errors"
+	"shiplabs/schat/internal/models"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	maxEmojiLength = 16
+	// maxUserReactions caps the distinct emojis one user may put on a
+	// single message.
+	maxUserReactions = 10
+)
+
+type ReactionAction string
+
+const (
+	AddReaction    ReactionAction = "add"
+	RemoveReaction ReactionAction = "remove"
+)
+
+type ReactionDto struct {
+	Origin
+	MessageRef
+	Emoji string `json:"emoji"`
+}
+
+// ReactionChange is broadcast to every participant of the conversation.
+// Reactions is the message's new aggregate, Reacted is left false because
+// it depends on who reads it.
+type ReactionChange struct {
+	ConversationType models.ConversationType `json:"conversation_type"`
+	ConversationID   uuid.UUID               `json:"conversation_id"`
+	MessageID        uuid.UUID               `json:"message_id"`
+	Seq              int64                   `json:"seq"`
+	UserID           uuid.UUID               `json:"user_id"`
+	Emoji            string                  `json:"emoji"`
+	Action           ReactionAction          `json:"action"`
+	Reactions        []models.ReactionCount  `json:"reactions"`
+}
+
+var (
+	ErrInvalidEmoji  = errors.New("invalid emoji")
+	ErrTooManyEmojis = errors.New("too many reactions on this message")
+)
+
+func (c *chatService) React(userID uuid.UUID, data ReactionDto, action ReactionAction) (ReactionChange, error) {
+	emoji := strings.TrimSpace(data.Emoji)
+	// removal stays lenient so reactions stored before emojis were
+	// validated can still be taken back
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxEmojiLength || (action == AddReaction && !isEmoji(emoji)) {
+		return ReactionChange{}, ErrInvalidEmoji
+	}
+
+	msg, participants, err := c.findMessage(userID, data.MessageRef)
+	if err != nil {
+		return ReactionChange{}, err
+	}
+	if msg.base.DeletedForAllAt != nil {
+		return ReactionChange{}, ErrMessageDeleted
+	}
+
+	change := ReactionChange{
+		ConversationType: data.ConversationType,
+		ConversationID:   msg.conversationID,
+		MessageID:        msg.base.ID,
+		Seq:              msg.seq,
+		UserID:           userID,
+		Emoji:            emoji,
+		Action:           action,
+	}
+
+	kind := NoticeReactionAdd
+	if action == RemoveReaction {
+		kind = NoticeReactionRemove
+	}
+
+	err = inTx(c.reactionRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		var err error
+		switch action {
+		case AddReaction:
+			// the message row lock serializes the user's concurrent adds so
+			// the cap holds
+			if data.ConversationType == models.GroupConversation {
+				_, err = c.groupMsgRepo.LockForUpdate(tx, msg.base.ID)
+			} else {
+				_, err = c.privateMessageRepo.LockForUpdate(tx, msg.base.ID)
+			}
+			if err != nil {
+				return err
+			}
+			var others int64
+			others, err = c.reactionRepo.CountOtherEmojis(tx, msg.base.ID, userID, emoji)
+			if err != nil {
+				return err
+			}
+			if others >= maxUserReactions {
+				return ErrTooManyEmojis
+			}
+			err = c.reactionRepo.AddReaction(tx, &models.MessageReaction{
+				MessageID:        msg.base.ID,
+				UserID:           userID,
+				Emoji:            emoji,
+				ConversationType: data.ConversationType,
+				ConversationID:   msg.conversationID,
+			})
+		case RemoveReaction:
+			err = c.reactionRepo.RemoveReaction(tx, msg.base.ID, userID, emoji)
+		}
+		if err != nil {
+			return err
+		}
+
+		counts, err := c.reactionRepo.GetReactionCounts(tx, []uuid.UUID{msg.base.ID}, uuid.Nil)
+		if err != nil {
+			return err
+		}
+		change.Reactions = counts[msg.base.ID]
+		return notify(tx, c.outboxRepo, kind, change, fanOut(participants, userID, data.Origin))
+	})
+	if err != nil {
+		return ReactionChange{}, err
+	}
+
+	return change, nil
+}
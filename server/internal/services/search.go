@@ -0,0 +1,159 @@
+package services
+
+import (
+	"errors"
+	"shiplabs/schat/internal/models"
+	repos "shiplabs/schat/internal/repositories"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SearchQuery is the raw search input. From and To are RFC 3339
+// timestamps, To is exclusive.
+type SearchQuery struct {
+	Query            string `form:"q"`
+	SenderID         string `form:"sender_id"`
+	ConversationType string `form:"conversation_type"`
+	ConversationID   string `form:"conversation_id"`
+	Type             string `form:"type"`
+	From             string `form:"from"`
+	To               string `form:"to"`
+	Limit            int    `form:"limit"`
+	Offset           int    `form:"offset"`
+}
+
+type SearchPageInfo struct {
+	HasMore    bool `json:"has_more"`
+	NextOffset *int `json:"next_offset"`
+}
+
+type SearchResults struct {
+	Hits     []repos.SearchHit `json:"hits"`
+	PageInfo SearchPageInfo    `json:"page_info"`
+}
+
+type searchService struct {
+	searchRepo repos.SearchRepoInterface
+}
+
+type SearchServiceInterface interface {
+	SearchMessages(userID uuid.UUID, query SearchQuery) (SearchResults, error)
+}
+
+func NewSearchService(searchRepo repos.SearchRepoInterface) SearchServiceInterface {
+	return &searchService{
+		searchRepo: searchRepo,
+	}
+}
+
+var (
+	ErrEmptySearch        = errors.New("search query cannot be empty")
+	ErrConversationFilter = errors.New("conversation_id needs a conversation_type")
+	ErrInvalidDate        = errors.New("from and to must be RFC 3339 timestamps")
+	ErrInvalidOffset      = errors.New("offset cannot be negative")
+)
+
+// SearchMessages runs a ranked full-text search over the messages of every
+// chat and group the user is a member of. Results are paged by offset
+// since rank order has no stable cursor.
+func (s *searchService) SearchMessages(userID uuid.UUID, query SearchQuery) (SearchResults, error) {
+	filter, err := buildSearchFilter(userID, query)
+	if err != nil {
+		return SearchResults{}, err
+	}
+
+	limit := filter.Limit
+	filter.Limit++
+	hits, err := s.searchRepo.SearchMessages(filter)
+	if err != nil {
+		return SearchResults{}, err
+	}
+
+	results := SearchResults{Hits: hits}
+	if len(hits) > limit {
+		results.Hits = hits[:limit]
+		next := filter.Offset + limit
+		results.PageInfo = SearchPageInfo{HasMore: true, NextOffset: &next}
+	}
+	if results.Hits == nil {
+		results.Hits = []repos.SearchHit{}
+	}
+
+	return results, nil
+}
+
+func buildSearchFilter(userID uuid.UUID, query SearchQuery) (repos.SearchFilter, error) {
+	filter := repos.SearchFilter{
+		UserID: userID,
+		Query:  strings.TrimSpace(query.Query),
+		Limit:  query.Limit,
+		Offset: query.Offset,
+	}
+	if filter.Query == "" {
+		return filter, ErrEmptySearch
+	}
+	if filter.Limit <= 0 || filter.Limit > repos.MaxPageSize {
+		filter.Limit = repos.DefaultPageSize
+	}
+	if filter.Offset < 0 {
+		return filter, ErrInvalidOffset
+	}
+
+	if query.SenderID != "" {
+		senderID, err := uuid.Parse(query.SenderID)
+		if err != nil {
+			return filter, ErrInvalidID
+		}
+		filter.SenderID = &senderID
+	}
+
+	switch models.ConversationType(query.ConversationType) {
+	case "":
+	case models.PrivateConversation, models.GroupConversation:
+		filter.ConversationType = models.ConversationType(query.ConversationType)
+	default:
+		return filter, ErrInvalidConversation
+	}
+
+	if query.ConversationID != "" {
+		if filter.ConversationType == "" {
+			return filter, ErrConversationFilter
+		}
+		conversationID, err := uuid.Parse(query.ConversationID)
+		if err != nil {
+			return filter, ErrInvalidID
+		}
+		filter.ConversationID = &conversationID
+	}
+
+	switch models.ValidMsgType(query.Type) {
+	case "", models.TEXT, models.IMAGE, models.VIDEO, models.AUDIO:
+		filter.Type = models.ValidMsgType(query.Type)
+	default:
+		return filter, ErrInvalidMsgType
+	}
+
+	parse := func(raw string) (*time.Time, error) {
+		if raw == "" {
+			return nil, nil
+		}
+		ts, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return nil, ErrInvalidDate
+		}
+		ts = ts.UTC()
+		return &ts, nil
+	}
+
+	var err error
+	if filter.From, err = parse(query.From); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parse(query.To); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"errors"
+	"shiplabs/schat/internal/models"
+	repos "shiplabs/schat/internal/repositories"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestBuildSearchFilter(t *testing.T) {
+	userID, chatID := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name    string
+		query   SearchQuery
+		wantErr error
+	}{
+		{"words", SearchQuery{Query: "release notes"}, nil},
+		{"every filter", SearchQuery{
+			Query:            "release",
+			SenderID:         uuid.NewString(),
+			ConversationType: string(models.PrivateConversation),
+			ConversationID:   chatID.String(),
+			Type:             string(models.IMAGE),
+			From:             "2026-10-01T00:00:00+02:00",
+			To:               "2026-10-18T00:00:00Z",
+		}, nil},
+		{"blank query", SearchQuery{Query: "   "}, ErrEmptySearch},
+		{"negative offset", SearchQuery{Query: "release", Offset: -1}, ErrInvalidOffset},
+		{"bad sender", SearchQuery{Query: "release", SenderID: "ada"}, ErrInvalidID},
+		{"unknown conversation type", SearchQuery{Query: "release", ConversationType: "channel"}, ErrInvalidConversation},
+		{"conversation without its type", SearchQuery{Query: "release", ConversationID: chatID.String()}, ErrConversationFilter},
+		{"bad conversation", SearchQuery{Query: "release", ConversationType: "group", ConversationID: "general"}, ErrInvalidID},
+		{"unknown message type", SearchQuery{Query: "release", Type: "STICKER"}, ErrInvalidMsgType},
+		{"bad date", SearchQuery{Query: "release", From: "last week"}, ErrInvalidDate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := buildSearchFilter(userID, tt.query)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("buildSearchFilter() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if filter.UserID != userID || filter.Limit != repos.DefaultPageSize {
+				t.Fatalf("buildSearchFilter() = user %s limit %d, want %s %d", filter.UserID, filter.Limit, userID, repos.DefaultPageSize)
+			}
+			if filter.From != nil && filter.From.Location() != time.UTC {
+				t.Fatalf("From = %s, want UTC", filter.From)
+			}
+		})
+	}
+}
+
+type fakeSearch struct {
+	repos.SearchRepoInterface
+	matches int
+}
+
+func (f *fakeSearch) SearchMessages(filter repos.SearchFilter) ([]repos.SearchHit, error) {
+	hits := make([]repos.SearchHit, 0, filter.Limit)
+	for i := filter.Offset; i < f.matches && len(hits) < filter.Limit; i++ {
+		hits = append(hits, repos.SearchHit{MessageID: uuid.New()})
+	}
+	return hits, nil
+}
+
+func TestSearchMessagesPages(t *testing.T) {
+	tests := []struct {
+		name     string
+		matches  int
+		offset   int
+		wantHits int
+		wantNext *int
+	}{
+		{"first page", 25, 0, 10, intPtr(10)},
+		{"last page", 25, 20, 5, nil},
+		{"exactly a page", 10, 0, 10, nil},
+		{"no matches", 0, 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			search := &fakeSearch{matches: tt.matches}
+			service := &searchService{searchRepo: search}
+
+			got, err := service.SearchMessages(uuid.New(), SearchQuery{Query: "release", Limit: 10, Offset: tt.offset})
+			if err != nil {
+				t.Fatalf("SearchMessages() error = %v", err)
+			}
+			if got.Hits == nil || len(got.Hits) != tt.wantHits {
+				t.Fatalf("SearchMessages() returned %d hits (nil %t), want %d", len(got.Hits), got.Hits == nil, tt.wantHits)
+			}
+			if (got.PageInfo.NextOffset == nil) != (tt.wantNext == nil) ||
+				got.PageInfo.NextOffset != nil && *got.PageInfo.NextOffset != *tt.wantNext {
+				t.Fatalf("NextOffset = %v, want %v", got.PageInfo.NextOffset, tt.wantNext)
+			}
+			if got.PageInfo.HasMore != (tt.wantNext != nil) {
+				t.Fatalf("HasMore = %t, want %t", got.PageInfo.HasMore, tt.wantNext != nil)
+			}
+		})
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"crypto"
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/internal/pkg/config"
+	"shiplabs/schat/internal/pkg/keyring"
+	"shiplabs/schat/pkg/shared"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// publishedKeys remembers which signing keys this instance already
+// published, so it happens once per key rather than per token.
+var publishedKeys sync.Map
+
+// publishSigningKey makes the signer's public key available to the other
+// instances before the first token it signs leaves this one.
+func (a *AuthService) publishSigningKey() error {
+	if _, done := publishedKeys.Load(a.Signer.KeyID); done {
+		return nil
+	}
+
+	public, err := keyring.MarshalPublicKey(a.Signer.Public())
+	if err != nil {
+		return err
+	}
+	err = a.SigningKeyRepo.Publish(&models.SigningKey{
+		KeyID:     a.Signer.KeyID,
+		Algorithm: a.Signer.Method.Alg(),
+		PublicKey: public,
+	})
+	if err != nil {
+		return err
+	}
+
+	publishedKeys.Store(a.Signer.KeyID, true)
+	return nil
+}
+
+// verificationKey is the jwt key func. Tokens must name their key, and a
+// retired key only verifies during its grace period.
+func (a *AuthService) verificationKey(token *jwt.Token) (any, error) {
+	keyID, _ := token.Header["kid"].(string)
+	if keyID == "" {
+		return nil, ErrInvalidToken
+	}
+	if keyID == a.Signer.KeyID {
+		return a.Signer.Public(), nil
+	}
+
+	key, err := a.SigningKeyRepo.FindByKeyID(keyID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if key.RetiredAt != nil && shared.TimeNow().After(key.RetiredAt.Add(config.Configs.Auth.KeyGrace)) {
+		return nil, ErrInvalidToken
+	}
+	public, err := keyring.ParsePublicKey(key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	// the key must match the algorithm it was published for
+	if token.Method.Alg() != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	return public, nil
+}
+
+// JWKS lists every key tokens may currently be verified with.
+func (a *AuthService) JWKS() (keyring.JWKSet, error) {
+	set := keyring.JWKSet{Keys: []keyring.JWK{}}
+
+	keys, err := a.SigningKeyRepo.GetVerifiable(shared.TimeNow().Add(-config.Configs.Auth.KeyGrace))
+	if err != nil {
+		return set, err
+	}
+
+	seen := map[string]bool{}
+	add := func(keyID string, public crypto.PublicKey) error {
+		if seen[keyID] {
+			return nil
+		}
+		jwk, err := keyring.NewJWK(keyID, public)
+		if err != nil {
+			return err
+		}
+		seen[keyID] = true
+		set.Keys = append(set.Keys, jwk)
+		return nil
+	}
+
+	if err := add(a.Signer.KeyID, a.Signer.Public()); err != nil {
+		return set, err
+	}
+	for _, key := range keys {
+		public, err := keyring.ParsePublicKey(key.PublicKey)
+		if err != nil {
+			return set, err
+		}
+		if err := add(key.KeyID, public); err != nil {
+			return set, err
+		}
+	}
+
+	return set, nil
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"shiplabs/schat/internal/models"
+	repos "shiplabs/schat/internal/repositories"
+
+	"github.com/google/uuid"
+)
+
+// SyncQuery asks for the messages numbered above Since, the highest
+// sequence number the client has without a gap before it.
+type SyncQuery struct {
+	Since int64 `form:"since"`
+	Limit int   `form:"limit"`
+}
+
+// SyncInfo tells the client how far the returned messages got it. Every
+// message up to Until has been returned or isn't visible to the caller, so
+// the client can use it as the next Since. LastSeq is the conversation's
+// newest number, HasMore is set while Until is behind it.
+type SyncInfo struct {
+	Until   int64 `json:"until"`
+	LastSeq int64 `json:"last_seq"`
+	HasMore bool  `json:"has_more"`
+}
+
+type PrivateSync struct {
+	Messages []models.PrivateMessage `json:"messages"`
+	SyncInfo
+}
+
+type GroupSync struct {
+	Messages []models.GroupMessage `json:"messages"`
+	SyncInfo
+}
+
+func (c *chatService) SyncPrivateChat(userID, chatID uuid.UUID, query SyncQuery) (PrivateSync, error) {
+	sync := PrivateSync{Messages: []models.PrivateMessage{}}
+
+	chat, err := c.privateChatRepo.FindByID(chatID)
+	if err != nil {
+		return sync, ErrChat404
+	}
+	if chat.FirstMemberID != userID && chat.SecondMemberID != userID {
+		return sync, ErrNotMember
+	}
+
+	page, requested := buildSeqPage(query, userID)
+	messages, err := c.privateMessageRepo.GetChatMessagesSince(chatID, page)
+	if err != nil {
+		return sync, err
+	}
+
+	messages, hasMore := trimPage(messages, requested, true)
+	if err := attachReplies(messages, c.privateMessageRepo.FindByIDs); err != nil {
+		return sync, err
+	}
+	sync.Messages = messages
+	sync.SyncInfo = SyncInfo{Until: max(page.Since, chat.LastSeq), LastSeq: chat.LastSeq, HasMore: hasMore}
+	if len(messages) > 0 {
+		sync.SyncInfo = advanceSync(sync.SyncInfo, messages[len(messages)-1].Seq)
+	}
+
+	return sync, nil
+}
+
+// SyncGroup covers thread replies too, they share the group's numbering.
+func (c *chatService) SyncGroup(userID, groupID uuid.UUID, query SyncQuery) (GroupSync, error) {
+	sync := GroupSync{Messages: []models.GroupMessage{}}
+	if _, err := c.groupRepo.GetGroupMember(groupID, userID); err != nil {
+		return sync, ErrNotMember
+	}
+	group, err := c.groupRepo.FindByID(groupID)
+	if err != nil {
+		return sync, err
+	}
+
+	page, requested := buildSeqPage(query, userID)
+	messages, err := c.groupMsgRepo.GetGroupMessagesSince(groupID, page)
+	if err != nil {
+		return sync, err
+	}
+
+	messages, hasMore := trimPage(messages, requested, true)
+	if err := attachReplies(messages, c.groupMsgRepo.FindByIDs); err != nil {
+		return sync, err
+	}
+	sync.Messages = messages
+	sync.SyncInfo = SyncInfo{Until: max(page.Since, group.LastSeq), LastSeq: group.LastSeq, HasMore: hasMore}
+	if len(messages) > 0 {
+		sync.SyncInfo = advanceSync(sync.SyncInfo, messages[len(messages)-1].Seq)
+	}
+
+	return sync, nil
+}
+
+// buildSeqPage asks for one message more than requested to tell whether
+// the client is caught up.
+func buildSeqPage(query SyncQuery, viewerID uuid.UUID) (repos.SeqPage, int) {
+	requested := query.Limit
+	if requested <= 0 || requested > repos.MaxPageSize {
+		requested = repos.DefaultPageSize
+	}
+	return repos.SeqPage{Since: max(query.Since, 0), Limit: requested + 1, ViewerID: viewerID}, requested
+}
+
+// advanceSync accounts for the last returned message. When more follow the
+// client is only caught up to it, otherwise messages committed after the
+// conversation was read may put it past LastSeq.
+func advanceSync(info SyncInfo, lastReturned int64) SyncInfo {
+	if info.HasMore {
+		info.Until = lastReturned
+	} else {
+		info.Until = max(info.Until, lastReturned)
+	}
+	info.LastSeq = max(info.LastSeq, lastReturned)
+	return info
+}
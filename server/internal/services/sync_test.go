@@ -0,0 +1,122 @@
+package services
+
+import (
+	"errors"
+	"shiplabs/schat/internal/models"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Thread replies and group messages draw from the same numbering.
+func TestSendGroupAssignsSeq(t *testing.T) {
+	sender := uuid.New()
+	group := models.Group{ID: uuid.New(), LastSeq: 4}
+	db := newTestDB(t)
+	groups := newFakeGroups(db, group)
+	groups.members = []models.GroupMember{{GroupID: group.ID, UserID: sender}}
+	service := &chatService{
+		groupRepo:    groups,
+		groupMsgRepo: newFakeGroupMessages(db),
+		deliveryRepo: &fakeDeliveries{testDB: db},
+		outboxRepo:   &fakeOutbox{testDB: db},
+	}
+	send := func(replyTo string, thread bool) MessageAck {
+		t.Helper()
+		ack, err := service.SendMsgToGroup(sender, GroupMessageDto{
+			MessageDto: MessageDto{Type: models.TEXT, Content: "hi", ReplyToID: replyTo},
+			GroupID:    group.ID.String(),
+			Thread:     thread,
+		})
+		if err != nil {
+			t.Fatalf("SendMsgToGroup() error = %v", err)
+		}
+		return ack
+	}
+
+	root := send("", false)
+	reply := send(root.MessageID.String(), true)
+	next := send("", false)
+	if got := []int64{root.Seq, reply.Seq, next.Seq}; !slices.Equal(got, []int64{5, 6, 7}) {
+		t.Fatalf("seqs = %v, want [5 6 7]", got)
+	}
+}
+
+func TestSyncGroup(t *testing.T) {
+	member := uuid.New()
+	group := models.Group{ID: uuid.New(), LastSeq: 6}
+	// 3 was deleted and 6 hidden by the member, neither comes back
+	var stored []models.GroupMessage
+	for _, seq := range []int64{1, 2, 4, 5} {
+		message := models.GroupMessage{GroupID: group.ID, Seq: seq}
+		message.ID = uuid.New()
+		stored = append(stored, message)
+	}
+
+	tests := []struct {
+		name      string
+		query     SyncQuery
+		wantSeqs  []int64
+		wantUntil int64
+		wantMore  bool
+	}{
+		{"from the start", SyncQuery{Limit: 2}, []int64{1, 2}, 2, true},
+		{"across the gap", SyncQuery{Since: 2, Limit: 2}, []int64{4, 5}, 6, false},
+		{"everything", SyncQuery{}, []int64{1, 2, 4, 5}, 6, false},
+		{"caught up", SyncQuery{Since: 6}, nil, 6, false},
+		{"negative since", SyncQuery{Since: -3, Limit: 1}, []int64{1}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			groups := newFakeGroups(db, group)
+			groups.members = []models.GroupMember{{GroupID: group.ID, UserID: member}}
+			service := &chatService{groupRepo: groups, groupMsgRepo: newFakeGroupMessages(db, stored...)}
+
+			got, err := service.SyncGroup(member, group.ID, tt.query)
+			if err != nil {
+				t.Fatalf("SyncGroup() error = %v", err)
+			}
+			var seqs []int64
+			for _, message := range got.Messages {
+				seqs = append(seqs, message.Seq)
+			}
+			if !slices.Equal(seqs, tt.wantSeqs) {
+				t.Fatalf("SyncGroup() seqs = %v, want %v", seqs, tt.wantSeqs)
+			}
+			if got.Until != tt.wantUntil || got.LastSeq != group.LastSeq || got.HasMore != tt.wantMore {
+				t.Fatalf("SyncInfo = %+v, want until %d last %d more %t", got.SyncInfo, tt.wantUntil, group.LastSeq, tt.wantMore)
+			}
+		})
+	}
+}
+
+func TestSyncGroupNotMember(t *testing.T) {
+	db := newTestDB(t)
+	group := models.Group{ID: uuid.New()}
+	service := &chatService{groupRepo: newFakeGroups(db, group)}
+	if _, err := service.SyncGroup(uuid.New(), group.ID, SyncQuery{}); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("SyncGroup() error = %v, want %v", err, ErrNotMember)
+	}
+}
+
+func TestAdvanceSync(t *testing.T) {
+	tests := []struct {
+		name         string
+		info         SyncInfo
+		lastReturned int64
+		want         SyncInfo
+	}{
+		{"more to come", SyncInfo{Until: 9, LastSeq: 9, HasMore: true}, 4, SyncInfo{Until: 4, LastSeq: 9, HasMore: true}},
+		{"caught up", SyncInfo{Until: 9, LastSeq: 9}, 8, SyncInfo{Until: 9, LastSeq: 9}},
+		{"committed after the read", SyncInfo{Until: 9, LastSeq: 9}, 11, SyncInfo{Until: 11, LastSeq: 11}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := advanceSync(tt.info, tt.lastReturned); got != tt.want {
+				t.Fatalf("advanceSync() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/internal/pkg/config"
+	"shiplabs/schat/pkg/shared"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const connectTicketSize = 32
+
+var (
+	ErrInvalidTicket = errors.New("invalid, expired or already used connect ticket")
+)
+
+// ConnectTicket is redeemed once, within seconds, when opening a websocket
+// from a browser.
+type ConnectTicket struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IssueConnectTicket hands out a ticket bound to the caller's session, the
+// socket opened with it belongs to that session.
+func (a *AuthService) IssueConnectTicket(userID, sessionID uuid.UUID) (ConnectTicket, error) {
+	now := shared.TimeNow()
+	ticket := ConnectTicket{
+		Ticket:    shared.RandomToken(connectTicketSize),
+		ExpiresAt: now.Add(config.Configs.Auth.TicketTTL),
+	}
+
+	err := a.SessionRepo.CreateConnectTicket(&models.ConnectTicket{
+		SessionID: sessionID,
+		UserID:    userID,
+		TokenHash: shared.HashToken(ticket.Ticket),
+		ExpiresAt: ticket.ExpiresAt,
+	})
+	if err != nil {
+		return ConnectTicket{}, err
+	}
+
+	// expired tickets are of no use to anyone
+	if err := a.SessionRepo.PurgeConnectTickets(now); err != nil {
+		log.Println("failed to purge connect tickets", err)
+	}
+
+	return ticket, nil
+}
+
+// RedeemConnectTicket resolves a ticket to its user and session, using it
+// up. The session must still be live.
+func (a *AuthService) RedeemConnectTicket(ticket string) (uuid.UUID, uuid.UUID, error) {
+	if ticket == "" {
+		return uuid.Nil, uuid.Nil, ErrInvalidTicket
+	}
+
+	redeemed, ok, err := a.SessionRepo.RedeemConnectTicket(shared.HashToken(ticket), shared.TimeNow())
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	if !ok {
+		return uuid.Nil, uuid.Nil, ErrInvalidTicket
+	}
+
+	if err := a.checkSession(redeemed.UserID, redeemed.SessionID); err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+
+	return redeemed.UserID, redeemed.SessionID, nil
+}
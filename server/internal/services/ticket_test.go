@@ -0,0 +1,60 @@
+package services
+
+import (
+	"errors"
+	"shiplabs/schat/internal/pkg/config"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRedeemConnectTicket(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		redeem  func(service *AuthService, ticket string, userID, sessionID uuid.UUID)
+		ticket  string
+		wantErr error
+	}{
+		{"redeemed", time.Minute, nil, "", nil},
+		{"used twice", time.Minute, func(service *AuthService, ticket string, _, _ uuid.UUID) {
+			service.RedeemConnectTicket(ticket)
+		}, "", ErrInvalidTicket},
+		{"expired", -time.Second, nil, "", ErrInvalidTicket},
+		{"session logged out", time.Minute, func(service *AuthService, _ string, userID, sessionID uuid.UUID) {
+			service.Logout(userID, sessionID)
+		}, "", ErrSessionRevoked},
+		{"made up", time.Minute, nil, "not a ticket", ErrInvalidTicket},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _, _ := newTestAuthService(t)
+			config.Configs.Auth.TicketTTL = tt.ttl
+			userID := uuid.New()
+			tokens, err := service.startSession(userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			issued, err := service.IssueConnectTicket(userID, tokens.SessionID)
+			if err != nil {
+				t.Fatalf("IssueConnectTicket() error = %v", err)
+			}
+			ticket := issued.Ticket
+			if tt.ticket != "" {
+				ticket = tt.ticket
+			}
+			if tt.redeem != nil {
+				tt.redeem(service, ticket, userID, tokens.SessionID)
+			}
+
+			gotUser, gotSession, err := service.RedeemConnectTicket(ticket)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RedeemConnectTicket() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (gotUser != userID || gotSession != tokens.SessionID) {
+				t.Fatalf("RedeemConnectTicket() = %s %s, want %s %s", gotUser, gotSession, userID, tokens.SessionID)
+			}
+		})
+	}
+}
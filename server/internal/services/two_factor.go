@@ -0,0 +1,315 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"log"
+	"shiplabs/schat/internal/models"
+	"shiplabs/schat/internal/pkg/config"
+	"shiplabs/schat/internal/pkg/totp"
+	"shiplabs/schat/pkg/shared"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	recoveryCodeCount = 10
+	recoveryCodeSize  = 6
+	// totpSkew accepts the codes of the steps next to the current one
+	totpSkew   = 1
+	maxLockout = 24 * time.Hour
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication not enabled")
+	ErrTwoFactorNotEnrolled = errors.New("no two-factor enrollment to confirm")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	ErrInvalidChallenge     = errors.New("invalid, expired or exhausted login challenge")
+	ErrTwoFactorLocked      = errors.New("too many wrong two-factor codes, try again later")
+)
+
+// TwoFactorEnrollment is shown once so the user can add the secret to an
+// authenticator app, URI is the payload of the QR code to scan.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type TwoFactorStatus struct {
+	Enabled           bool  `json:"enabled"`
+	RecoveryCodesLeft int64 `json:"recovery_codes_left"`
+}
+
+// RecoveryCodes are only ever shown when generated.
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+func (a *AuthService) TwoFactorStatus(userID uuid.UUID) (TwoFactorStatus, error) {
+	twoFactor, err := a.TwoFactorRepo.FindByUserID(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && twoFactor.ConfirmedAt == nil) {
+		return TwoFactorStatus{}, nil
+	}
+	if err != nil {
+		return TwoFactorStatus{}, err
+	}
+
+	left, err := a.TwoFactorRepo.CountRecoveryCodes(userID)
+	if err != nil {
+		return TwoFactorStatus{}, err
+	}
+	return TwoFactorStatus{Enabled: true, RecoveryCodesLeft: left}, nil
+}
+
+// EnrollTwoFactor provisions a new secret. It takes effect once confirmed
+// with a code generated from it, enrolling again before that starts over.
+func (a *AuthService) EnrollTwoFactor(userID uuid.UUID) (TwoFactorEnrollment, error) {
+	user, err := a.UserRepo.FindByID(userID)
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+
+	secret := totp.GenerateSecret()
+	sealed, err := shared.Encrypt(secret, totpKey())
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	saved, err := a.TwoFactorRepo.SaveEnrollment(&models.TwoFactor{
+		UserID: userID,
+		Secret: sealed,
+	})
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	if !saved {
+		return TwoFactorEnrollment{}, ErrTwoFactorEnabled
+	}
+
+	return TwoFactorEnrollment{
+		Secret: secret,
+		URI:    totp.URI(config.Configs.Auth.TOTPIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTwoFactor turns two-factor on and hands out the recovery codes.
+func (a *AuthService) ConfirmTwoFactor(userID uuid.UUID, code string) (RecoveryCodes, error) {
+	twoFactor, err := a.TwoFactorRepo.FindByUserID(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return RecoveryCodes{}, ErrTwoFactorNotEnrolled
+	}
+	if err != nil {
+		return RecoveryCodes{}, err
+	}
+	if twoFactor.ConfirmedAt != nil {
+		return RecoveryCodes{}, ErrTwoFactorEnabled
+	}
+
+	now := shared.TimeNow()
+	step, ok := a.validateTOTP(twoFactor, code)
+	if !ok {
+		return RecoveryCodes{}, ErrInvalidTwoFactorCode
+	}
+
+	var codes RecoveryCodes
+	err = inTx(a.TwoFactorRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		confirmed, err := a.TwoFactorRepo.Confirm(tx, userID, step, now)
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			return ErrInvalidTwoFactorCode
+		}
+		codes, err = a.replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// RegenerateRecoveryCodes replaces the remaining recovery codes, it takes
+// a current code so a hijacked session alone can't.
+func (a *AuthService) RegenerateRecoveryCodes(userID uuid.UUID, code string) (RecoveryCodes, error) {
+	var codes RecoveryCodes
+	err := a.withSecondFactor(userID, code, func(tx *gorm.DB) error {
+		var err error
+		codes, err = a.replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+func (a *AuthService) DisableTwoFactor(userID uuid.UUID, code string) error {
+	return a.withSecondFactor(userID, code, func(tx *gorm.DB) error {
+		return a.TwoFactorRepo.Delete(tx, userID)
+	})
+}
+
+// issueLoginChallenge stands in for the tokens of a user with two-factor
+// on, until CompleteLogin is called with a code.
+func (a *AuthService) issueLoginChallenge(user models.User) (LoginResult, error) {
+	ttl := config.Configs.Auth.ChallengeTTL
+	token, err := a.issueUserToken(user, models.LoginChallenge, ttl)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	return LoginResult{Challenge: &LoginChallenge{
+		Token:     token,
+		ExpiresAt: shared.TimeNow().Add(ttl),
+	}}, nil
+}
+
+// CompleteLogin finishes a login with a TOTP or recovery code. Every try
+// counts against the challenge, which gives out after a few wrong codes.
+func (a *AuthService) CompleteLogin(challengeToken, code string) (AuthTokens, error) {
+	hash := shared.HashToken(challengeToken)
+	challenge, ok, err := a.UserTokenRepo.AttemptToken(models.LoginChallenge, hash,
+		config.Configs.Auth.ChallengeAttempts, shared.TimeNow())
+	if err != nil {
+		return AuthTokens{}, err
+	}
+	if !ok {
+		return AuthTokens{}, ErrInvalidChallenge
+	}
+
+	err = a.withSecondFactor(challenge.UserID, code, func(tx *gorm.DB) error {
+		_, used, err := a.UserTokenRepo.ConsumeToken(tx, models.LoginChallenge, hash, shared.TimeNow())
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidChallenge
+		}
+		return nil
+	})
+	if err != nil {
+		return AuthTokens{}, err
+	}
+
+	return a.startSession(challenge.UserID)
+}
+
+// withSecondFactor runs fn in the transaction using up code, a TOTP code
+// or a recovery code, of the user's confirmed enrollment. Wrong codes are
+// counted against the user, not the challenge, so logging in again
+// doesn't buy more guesses.
+func (a *AuthService) withSecondFactor(userID uuid.UUID, code string, fn func(tx *gorm.DB) error) error {
+	twoFactor, err := a.TwoFactorRepo.FindByUserID(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && twoFactor.ConfirmedAt == nil) {
+		return ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		return err
+	}
+	if twoFactor.LockedUntil != nil && shared.TimeNow().Before(*twoFactor.LockedUntil) {
+		return ErrTwoFactorLocked
+	}
+
+	err = a.useSecondFactor(twoFactor, code, fn)
+	if errors.Is(err, ErrInvalidTwoFactorCode) {
+		a.recordFailure(userID)
+	}
+	return err
+}
+
+func (a *AuthService) useSecondFactor(twoFactor models.TwoFactor, code string, fn func(tx *gorm.DB) error) error {
+	userID := twoFactor.UserID
+	return inTx(a.TwoFactorRepo.BeginDBTx(), func(tx *gorm.DB) error {
+		var used bool
+		var err error
+		if step, ok := a.validateTOTP(twoFactor, code); ok {
+			used, err = a.TwoFactorRepo.UseStep(tx, userID, step)
+		} else {
+			used, err = a.TwoFactorRepo.UseRecoveryCode(tx, userID, hashRecoveryCode(code), shared.TimeNow())
+		}
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidTwoFactorCode
+		}
+		if err := a.TwoFactorRepo.ResetFailures(tx, userID); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// recordFailure locks two-factor after every few wrong codes in a row, for
+// twice as long each time.
+func (a *AuthService) recordFailure(userID uuid.UUID) {
+	failures, err := a.TwoFactorRepo.RecordFailure(userID)
+	if err != nil {
+		log.Println("failed to record two-factor failure for", userID, err)
+		return
+	}
+
+	authConfig := config.Configs.Auth
+	if authConfig.TwoFactorMaxFailures <= 0 || failures%authConfig.TwoFactorMaxFailures != 0 {
+		return
+	}
+	lockout := authConfig.TwoFactorLockout
+	for round := failures / authConfig.TwoFactorMaxFailures; round > 1 && lockout < maxLockout; round-- {
+		lockout *= 2
+	}
+	lockout = min(lockout, maxLockout)
+
+	if err := a.TwoFactorRepo.Lock(userID, shared.TimeNow().Add(lockout)); err != nil {
+		log.Println("failed to lock two-factor for", userID, err)
+	}
+}
+
+// validateTOTP checks the code against the secret, not whether its step
+// was used already.
+func (a *AuthService) validateTOTP(twoFactor models.TwoFactor, code string) (int64, bool) {
+	secret, err := shared.Decrypt(twoFactor.Secret, totpKey())
+	if err != nil {
+		return 0, false
+	}
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	return totp.Validate(secret, code, shared.TimeNow(), totpSkew)
+}
+
+func (a *AuthService) replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) (RecoveryCodes, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code := newRecoveryCode()
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	if err := a.TwoFactorRepo.ReplaceRecoveryCodes(tx, userID, hashes); err != nil {
+		return RecoveryCodes{}, err
+	}
+	return RecoveryCodes{Codes: codes}, nil
+}
+
+// newRecoveryCode reads as xxxxx-xxxxx, lowercase base32.
+func newRecoveryCode() string {
+	buf := make([]byte, recoveryCodeSize)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))
+	return code[:5] + "-" + code[5:]
+}
+
+// hashRecoveryCode ignores case, spaces and dashes the way users type the
+// code back in.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return shared.HashToken(code)
+}
+
+func totpKey() string {
+	if key := config.Configs.Auth.TOTPKey; key != "" {
+		return key
+	}
+	return config.Configs.APP_SECRET
+}
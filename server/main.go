@@ -2,8 +2,12 @@ package main
 
 import (
 	"shiplabs/schat/api"
+	"shiplabs/schat/internal/pkg/blob"
+	"shiplabs/schat/internal/pkg/broker"
 	"shiplabs/schat/internal/pkg/config"
 	"shiplabs/schat/internal/pkg/db"
+	"shiplabs/schat/internal/pkg/keyring"
+	"shiplabs/schat/internal/pkg/mailer"
 	"shiplabs/schat/internal/pkg/store"
 
 	"github.com/gin-gonic/gin"
@@ -13,6 +17,10 @@ func init() {
 	config.Load()
 	store.InitStore()
 	db.Connect()
+	blob.InitStorage()
+	broker.InitBroker()
+	keyring.InitKeys()
+	mailer.InitMailer()
 }
 
 func main() {
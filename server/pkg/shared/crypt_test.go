@@ -0,0 +1,66 @@
+package shared
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, data := range []string{"", "JBSWY3DPEHPK3PXP", "ünïcödé ✓"} {
+		sealed, err := Encrypt(data, "secret")
+		if err != nil {
+			t.Fatalf("Encrypt(%q): %v", data, err)
+		}
+		plain, err := Decrypt(sealed, "secret")
+		if err != nil {
+			t.Fatalf("Decrypt(%q): %v", data, err)
+		}
+		if plain != data {
+			t.Errorf("Decrypt() = %q, want %q", plain, data)
+		}
+	}
+}
+
+func TestEncryptNonce(t *testing.T) {
+	first, _ := Encrypt("data", "secret")
+	second, _ := Encrypt("data", "secret")
+	if first == second {
+		t.Fatal("the same plaintext sealed twice gave the same ciphertext")
+	}
+}
+
+func TestDecryptRejects(t *testing.T) {
+	sealed, err := Encrypt("JBSWY3DPEHPK3PXP", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(sealed)
+	flip := func(i int) string {
+		tampered := append([]byte(nil), raw...)
+		tampered[i] ^= 0x01
+		return base64.StdEncoding.EncodeToString(tampered)
+	}
+
+	tests := []struct {
+		name   string
+		data   string
+		secret string
+	}{
+		{"wrong key", sealed, "other secret"},
+		{"tampered nonce", flip(0), "secret"},
+		{"tampered ciphertext", flip(len(raw) / 2), "secret"},
+		{"tampered tag", flip(len(raw) - 1), "secret"},
+		{"truncated", base64.StdEncoding.EncodeToString(raw[:len(raw)-1]), "secret"},
+		{"shorter than nonce", base64.StdEncoding.EncodeToString(raw[:4]), "secret"},
+		{"not base64", "%%%", "secret"},
+		{"empty", "", "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decrypt(tt.data, tt.secret); !errors.Is(err, ErrDecrypt) {
+				t.Fatalf("Decrypt() error = %v, want %v", err, ErrDecrypt)
+			}
+		})
+	}
+}
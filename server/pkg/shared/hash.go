@@ -1,6 +1,13 @@
 package shared
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+
+	"golang.org/x/crypto/bcrypt"
+)
 
 func HashData(data string) string {
 	encrypted, err := bcrypt.GenerateFromPassword([]byte(data), 14)
@@ -14,3 +21,19 @@ func VerifyDataHash(data, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(data))
 	return err == nil
 }
+
+// RandomToken returns size random bytes, URL safe encoded.
+func RandomToken(size int) string {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// HashToken is for high entropy tokens, which don't need a slow hash to be
+// stored safely.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,18 @@
+package shared
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+func SignData(key, data string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func VerifySignature(key, data, signature string) bool {
+	expected := SignData(key, data)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
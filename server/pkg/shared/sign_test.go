@@ -0,0 +1,33 @@
+package shared
+
+import "testing"
+
+func TestVerifySignature(t *testing.T) {
+	const payload = "0b5c2d1e-6f0a-4c5e-9a38-1f7d2b0c9e11:original:1760000000"
+	signature := SignData("key", payload)
+	tampered := []byte(signature)
+	tampered[0] ^= 0x01
+
+	tests := []struct {
+		name      string
+		key       string
+		data      string
+		signature string
+		want      bool
+	}{
+		{"valid", "key", payload, signature, true},
+		{"wrong key", "other key", payload, signature, false},
+		{"extended expiry", "key", "0b5c2d1e-6f0a-4c5e-9a38-1f7d2b0c9e11:original:1760003600", signature, false},
+		{"other variant", "key", "0b5c2d1e-6f0a-4c5e-9a38-1f7d2b0c9e11:thumbnail:1760000000", signature, false},
+		{"tampered signature", "key", payload, string(tampered), false},
+		{"truncated signature", "key", payload, signature[:len(signature)-1], false},
+		{"empty signature", "key", payload, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifySignature(tt.key, tt.data, tt.signature); got != tt.want {
+				t.Fatalf("VerifySignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}